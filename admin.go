@@ -0,0 +1,413 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pendingSuspend is a manually requested suspend waiting out its confirmation delay
+type pendingSuspend struct {
+	token     string
+	suspendAt time.Time
+	timer     *time.Timer
+}
+
+var (
+	pending   *pendingSuspend
+	pendingMu sync.Mutex
+
+	errNoPendingSuspend = errors.New("no suspend is pending")
+	errTokenMismatch    = errors.New("cancellation token does not match the pending suspend")
+)
+
+// Scopes a token in TOKENS can be limited to, one per admin endpoint
+const (
+	scopeSuspend       = "suspend"
+	scopeCancelSuspend = "cancel-suspend"
+	scopeTimeout       = "timeout"
+	scopeTestHook      = "test-hook"
+	scopeShutdown      = "shutdown"
+	scopeActivity      = "activity"
+	scopeDebug         = "debug"
+	scopeSources       = "sources"
+	scopeAll           = "*"
+)
+
+var adminScopes = []string{scopeSuspend, scopeCancelSuspend, scopeTimeout, scopeTestHook, scopeShutdown, scopeActivity, scopeDebug, scopeSources, scopeAll}
+
+// adminToken is a named bearer token and the admin endpoints it may call
+type adminToken struct {
+	Name   string
+	Token  string
+	Scopes []string
+}
+
+func (t adminToken) allows(scope string) bool {
+	return slices.Contains(t.Scopes, scopeAll) || slices.Contains(t.Scopes, scope)
+}
+
+// parseAdminTokens parses TOKENS, e.g. "ci:s3cret:suspend,cancel-suspend;ops:t0ken:*"
+func parseAdminTokens(value string) ([]adminToken, error) {
+	var tokens []adminToken
+	for entry := range strings.SplitSeq(value, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+			return nil, fmt.Errorf("token entries must look like name:token:scope,scope")
+		}
+
+		token := adminToken{Name: parts[0], Token: parts[1]}
+		for scope := range strings.SplitSeq(parts[2], ",") {
+			scope = strings.TrimSpace(scope)
+			if !slices.Contains(adminScopes, scope) {
+				return nil, fmt.Errorf("token %s: unknown scope %q, expected one of %s", token.Name, scope, strings.Join(adminScopes, ", "))
+			}
+			token.Scopes = append(token.Scopes, scope)
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// adminTokens returns every token allowed to call admin endpoints, ADMIN_TOKEN being an "admin" token with every scope
+func adminTokens(cfg *Config) []adminToken {
+	tokens := cfg.Tokens
+	if cfg.AdminToken != "" {
+		tokens = append([]adminToken{{Name: "admin", Token: cfg.AdminToken, Scopes: []string{scopeAll}}}, tokens...)
+	}
+	return tokens
+}
+
+var (
+	errNoBearerToken        = errors.New("missing bearer token")
+	errMalformedBearerToken = errors.New(`malformed Authorization header, expected "Bearer <token>"`)
+	errUnknownBearerToken   = errors.New("invalid bearer token")
+)
+
+// parseBearerToken returns the token from an "Authorization: Bearer <token>" header
+// The scheme is case-insensitive, but anything else, like another scheme, extra spaces or a token containing whitespace, is rejected
+func parseBearerToken(header string) (string, error) {
+	if header == "" {
+		return "", errNoBearerToken
+	}
+	scheme, token, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" || strings.ContainsAny(token, " \t\r\n") {
+		return "", errMalformedBearerToken
+	}
+	return token, nil
+}
+
+// authenticateAdmin returns the token presented as a bearer token, if it is one we know
+func authenticateAdmin(r *http.Request) (adminToken, error) {
+	presented, err := parseBearerToken(r.Header.Get("Authorization"))
+	if err != nil {
+		return adminToken{}, err
+	}
+
+	// Digests are compared rather than the tokens themselves, ConstantTimeCompare returns early on a length mismatch
+	presentedSum := sha256.Sum256([]byte(presented))
+	var match adminToken
+	found := false
+	// Compare against every token so the time taken doesn't reveal which one matched
+	for _, token := range adminTokens(config()) {
+		tokenSum := sha256.Sum256([]byte(token.Token))
+		if subtle.ConstantTimeCompare(presentedSum[:], tokenSum[:]) == 1 {
+			match = token
+			found = true
+		}
+	}
+	if !found {
+		return adminToken{}, errUnknownBearerToken
+	}
+	return match, nil
+}
+
+// requireAdmin rejects requests that don't carry a bearer token from ADMIN_TOKEN or TOKENS with the given scope
+// Every request, allowed or not, ends up in the audit log
+func requireAdmin(scope string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w}
+
+		token, err := authenticateAdmin(r)
+		if err != nil {
+			slog.Warn("Unauthorized admin request", "path", r.URL.Path, "remote_addr", r.RemoteAddr, "error", err)
+			recorder.Header().Set("WWW-Authenticate", bearerChallenge(err))
+			http.Error(recorder, "Unauthorized: "+err.Error(), http.StatusUnauthorized)
+			audit(r, "", recorder.status)
+			return
+		}
+		if !token.allows(scope) {
+			slog.Warn("Admin token lacks the scope for this request", "path", r.URL.Path, "token", token.Name, "scope", scope)
+			http.Error(recorder, "Forbidden", http.StatusForbidden)
+			audit(r, token.Name, recorder.status)
+			return
+		}
+
+		r.Body = http.MaxBytesReader(recorder, r.Body, config().AdminMaxBodyBytes)
+		next(recorder, r)
+		audit(r, token.Name, recorder.status)
+	}
+}
+
+// bearerChallenge is the WWW-Authenticate value for a failed authentication, per RFC 6750 a request without credentials gets no error code
+func bearerChallenge(err error) string {
+	switch {
+	case errors.Is(err, errMalformedBearerToken):
+		return `Bearer realm="lightsout", error="invalid_request"`
+	case errors.Is(err, errUnknownBearerToken):
+		return `Bearer realm="lightsout", error="invalid_token"`
+	default:
+		return `Bearer realm="lightsout"`
+	}
+}
+
+// decodeAdminJSON enforces the method and content type of an admin request and strictly decodes its body into dst
+// On failure the error response has already been written and false is returned
+func decodeAdminJSON(w http.ResponseWriter, r *http.Request, method string, dst any) bool {
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return false
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+		return false
+	}
+
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(dst); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return false
+		}
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return false
+	}
+	if decoder.More() {
+		http.Error(w, "Invalid request body: unexpected data after JSON object", http.StatusBadRequest)
+		return false
+	}
+
+	return true
+}
+
+func newCancellationToken() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// scheduleManualSuspend arms a suspend that fires after MANUAL_SUSPEND_DELAY unless cancelled
+func scheduleManualSuspend() (*pendingSuspend, bool) {
+	cfg := config()
+
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+
+	if pending != nil {
+		return pending, false
+	}
+
+	p := &pendingSuspend{
+		token:     newCancellationToken(),
+		suspendAt: time.Now().Add(cfg.ManualSuspendDelay),
+	}
+	p.timer = time.AfterFunc(cfg.ManualSuspendDelay, func() {
+		pendingMu.Lock()
+		if pending != p {
+			// cancelled while the timer was firing
+			pendingMu.Unlock()
+			return
+		}
+		pending = nil
+		pendingMu.Unlock()
+
+		slog.Info("Manual suspend confirmation delay elapsed, suspending")
+		_ = requestSuspend("manual suspend", nil)
+	})
+	pending = p
+
+	return p, true
+}
+
+// cancelPendingSuspend aborts the pending manual suspend
+// An empty token cancels whatever is pending
+func cancelPendingSuspend(token string) error {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+
+	if pending == nil {
+		return errNoPendingSuspend
+	}
+	if token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(pending.token)) != 1 {
+		return errTokenMismatch
+	}
+
+	pending.timer.Stop()
+	pending = nil
+	return nil
+}
+
+func suspendHandler(w http.ResponseWriter, r *http.Request) {
+	if config().ManualSuspendDelay <= 0 {
+		slog.Info("Manual suspend requested", "remote_addr", r.RemoteAddr)
+		go requestSuspend("manual suspend", nil)
+		writeJSON(w, http.StatusAccepted, map[string]any{"pending": false})
+		return
+	}
+
+	p, created := scheduleManualSuspend()
+	status := http.StatusAccepted
+	if !created {
+		status = http.StatusConflict
+	} else {
+		slog.Info("Manual suspend scheduled",
+			"remote_addr", r.RemoteAddr,
+			"suspend_at", p.suspendAt)
+	}
+
+	writeJSON(w, status, map[string]any{
+		"pending":    true,
+		"token":      p.token,
+		"suspend_at": p.suspendAt,
+	})
+}
+
+func cancelSuspendHandler(w http.ResponseWriter, r *http.Request) {
+	err := cancelPendingSuspend(r.URL.Query().Get("token"))
+	switch {
+	case errors.Is(err, errNoPendingSuspend):
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	case errors.Is(err, errTokenMismatch):
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	slog.Info("Manual suspend cancelled", "remote_addr", r.RemoteAddr)
+	writeJSON(w, http.StatusOK, map[string]any{"pending": false})
+}
+
+type timeoutRequest struct {
+	// Timeout is a Go duration string, e.g. "15m"
+	Timeout string `json:"timeout"`
+}
+
+func timeoutHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := config()
+
+	var req timeoutRequest
+	if !decodeAdminJSON(w, r, http.MethodPut, &req) {
+		return
+	}
+
+	timeout, err := time.ParseDuration(req.Timeout)
+	if err != nil {
+		http.Error(w, "Invalid timeout: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if timeout < cfg.MinInactivityTimeout || timeout > cfg.MaxInactivityTimeout {
+		http.Error(w, fmt.Sprintf("Timeout must be between %s and %s", cfg.MinInactivityTimeout, cfg.MaxInactivityTimeout), http.StatusBadRequest)
+		return
+	}
+
+	previous := inactivityTimeout()
+	setInactivityTimeout(timeout)
+
+	slog.Info("Inactivity timeout changed",
+		"remote_addr", r.RemoteAddr,
+		"previous_seconds", int(previous.Seconds()),
+		"timeout_seconds", int(timeout.Seconds()))
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"inactivity_timeout_seconds": int(timeout.Seconds()),
+	})
+}
+
+type activityRequest struct {
+	// Source names what the activity came from, e.g. "github_actions"
+	Source string `json:"source"`
+	// Timestamp is when the activity happened, defaults to now
+	Timestamp *time.Time `json:"timestamp"`
+}
+
+// activityHandler records activity reported by an integration that can't poll /ping, such as a CI job starting or finishing
+func activityHandler(w http.ResponseWriter, r *http.Request) {
+	var req activityRequest
+	if !decodeAdminJSON(w, r, http.MethodPost, &req) {
+		return
+	}
+
+	now := time.Now()
+	at := now
+	if req.Timestamp != nil {
+		at = *req.Timestamp
+	}
+	if at.After(now) {
+		http.Error(w, "Timestamp must not be in the future", http.StatusBadRequest)
+		return
+	}
+	if req.Source == "" {
+		req.Source = "api"
+	}
+
+	timerReset := recordSourceActivity(req.Source, at, now)
+
+	slog.Info("Activity recorded",
+		"source", req.Source,
+		"timestamp", at,
+		"remote_addr", r.RemoteAddr,
+		"timer_reset", timerReset)
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"source":      req.Source,
+		"timestamp":   at,
+		"timer_reset": timerReset,
+	})
+}
+
+// recordSourceLastSeen remembers at as source's last activity unless it already has a later one
+func recordSourceLastSeen(source string, at time.Time) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if tracker.sourceLastSeen == nil {
+		tracker.sourceLastSeen = make(map[string]time.Time)
+	}
+	if at.After(tracker.sourceLastSeen[source]) {
+		tracker.sourceLastSeen[source] = at
+	}
+}
+
+// recordSourceActivity remembers activity a client reported for source and resets the timer like a ping would,
+// unless the activity is already too old to matter. It reports whether the timer was reset
+func recordSourceActivity(source string, at, now time.Time) bool {
+	recordSourceLastSeen(source, at)
+	recordActivity(at)
+
+	timerReset := now.Sub(at) < inactivityTimeout() && !keepOnline()
+	if timerReset {
+		resetShutdownTimer()
+	}
+	return timerReset
+}
@@ -0,0 +1,359 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func adminRequest(method, target string) *http.Request {
+	req := httptest.NewRequest(method, target, nil)
+	req.Header.Set("Authorization", "Bearer test-admin-token")
+	return req
+}
+
+func adminJSONRequest(method, target, body string) *http.Request {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer test-admin-token")
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestAdminEndpointRequiresToken(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	tests := []struct {
+		name      string
+		header    string
+		challenge string
+	}{
+		{"missing", "", `Bearer realm="lightsout"`},
+		{"no scheme", "test-admin-token", `Bearer realm="lightsout", error="invalid_request"`},
+		{"other scheme", "Token test-admin-token", `Bearer realm="lightsout", error="invalid_request"`},
+		{"empty token", "Bearer ", `Bearer realm="lightsout", error="invalid_request"`},
+		{"double space", "Bearer  test-admin-token", `Bearer realm="lightsout", error="invalid_request"`},
+		{"wrong token", "Bearer wrong-token", `Bearer realm="lightsout", error="invalid_token"`},
+		{"wrong token of the same length", "Bearer test-admin-tokeN", `Bearer realm="lightsout", error="invalid_token"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/suspend", nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			requireAdmin(scopeSuspend, suspendHandler)(w, req)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Fatalf("Authorization %q: expected status 401, got %d", tt.header, w.Code)
+			}
+			if got := w.Header().Get("WWW-Authenticate"); got != tt.challenge {
+				t.Fatalf("Expected WWW-Authenticate %q, got %q", tt.challenge, got)
+			}
+		})
+	}
+}
+
+func TestParseBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		token  string
+		err    error
+	}{
+		{"Bearer abc", "abc", nil},
+		{"bearer abc", "abc", nil},
+		{"", "", errNoBearerToken},
+		{"abc", "", errMalformedBearerToken},
+		{"Token abc", "", errMalformedBearerToken},
+		{"Basic YWRtaW46YWJj", "", errMalformedBearerToken},
+		{"Bearer abc ", "", errMalformedBearerToken},
+		{"Bearer abc def", "", errMalformedBearerToken},
+		{"Bearer\tabc", "", errMalformedBearerToken},
+	}
+	for _, tt := range tests {
+		token, err := parseBearerToken(tt.header)
+		if token != tt.token || !errors.Is(err, tt.err) {
+			t.Errorf("parseBearerToken(%q) = %q, %v, expected %q, %v", tt.header, token, err, tt.token, tt.err)
+		}
+	}
+}
+
+func TestManualSuspendAfterDelay(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		w := httptest.NewRecorder()
+		requireAdmin(scopeSuspend, suspendHandler)(w, adminRequest("POST", "/suspend"))
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("Expected status 202, got %d", w.Code)
+		}
+
+		// A second request while pending should not schedule another suspend
+		w = httptest.NewRecorder()
+		requireAdmin(scopeSuspend, suspendHandler)(w, adminRequest("POST", "/suspend"))
+		if w.Code != http.StatusConflict {
+			t.Fatalf("Expected status 409, got %d", w.Code)
+		}
+
+		status := currentStatus()
+		if status.PendingSuspend == nil || status.PendingSuspend.RemainingSeconds != 30 {
+			t.Fatalf("Expected pending suspend with 30s remaining, got %+v", status.PendingSuspend)
+		}
+
+		time.Sleep(config().ManualSuspendDelay - time.Second)
+		if mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should not be called before the confirmation delay")
+		}
+
+		time.Sleep(2 * time.Second)
+		if !mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should be called after the confirmation delay")
+		}
+		if currentStatus().PendingSuspend != nil {
+			t.Fatal("Pending suspend should be cleared after it fires")
+		}
+	})
+}
+
+func TestCancelManualSuspend(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		w := httptest.NewRecorder()
+		requireAdmin(scopeSuspend, suspendHandler)(w, adminRequest("POST", "/suspend"))
+
+		var body struct {
+			Token string `json:"token"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+
+		w = httptest.NewRecorder()
+		requireAdmin(scopeCancelSuspend, cancelSuspendHandler)(w, adminRequest("POST", "/cancel-suspend?token=wrong"))
+		if w.Code != http.StatusConflict {
+			t.Fatalf("Expected status 409 for a wrong token, got %d", w.Code)
+		}
+
+		w = httptest.NewRecorder()
+		requireAdmin(scopeCancelSuspend, cancelSuspendHandler)(w, adminRequest("POST", "/cancel-suspend?token="+body.Token))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+
+		time.Sleep(config().ManualSuspendDelay * 2)
+		if mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should not be called after cancellation")
+		}
+
+		w = httptest.NewRecorder()
+		requireAdmin(scopeCancelSuspend, cancelSuspendHandler)(w, adminRequest("POST", "/cancel-suspend"))
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("Expected status 404 with nothing pending, got %d", w.Code)
+		}
+	})
+}
+
+func TestAdjustTimeoutAtRuntime(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		resetShutdownTimer()
+		time.Sleep(config().InactivityTimeout - time.Second)
+
+		req := adminJSONRequest("PUT", "/timeout", `{"timeout": "10m"}`)
+		w := httptest.NewRecorder()
+		requireAdmin(scopeTimeout, timeoutHandler)(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		// The old timeout would have fired by now
+		time.Sleep(5 * time.Minute)
+		if mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should follow the new timeout")
+		}
+
+		time.Sleep(5*time.Minute + time.Second)
+		if !mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should be called after the new timeout")
+		}
+	})
+}
+
+func TestAdjustTimeoutValidation(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	for _, body := range []string{`{"timeout": "soon"}`, `{"timeout": "0s"}`, `{"timeout": "-5m"}`, `{"timeout": "2h"}`, `not json`} {
+		req := adminJSONRequest("PUT", "/timeout", body)
+		w := httptest.NewRecorder()
+		requireAdmin(scopeTimeout, timeoutHandler)(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Body %s: expected status 400, got %d", body, w.Code)
+		}
+	}
+
+	if config().InactivityTimeout != 90*time.Second {
+		t.Fatalf("Invalid requests should not change the timeout, got %s", config().InactivityTimeout)
+	}
+}
+
+func TestDecodeAdminJSON(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	tests := []struct {
+		name        string
+		method      string
+		contentType string
+		body        string
+		want        int
+	}{
+		{"valid", "PUT", "application/json", `{"timeout": "5m"}`, http.StatusOK},
+		{"charset", "PUT", "application/json; charset=utf-8", `{"timeout": "5m"}`, http.StatusOK},
+		{"wrong method", "POST", "application/json", `{"timeout": "5m"}`, http.StatusMethodNotAllowed},
+		{"wrong content type", "PUT", "text/plain", `{"timeout": "5m"}`, http.StatusUnsupportedMediaType},
+		{"unknown field", "PUT", "application/json", `{"timeout": "5m", "force": true}`, http.StatusBadRequest},
+		{"trailing data", "PUT", "application/json", `{"timeout": "5m"}{}`, http.StatusBadRequest},
+		{"too large", "PUT", "application/json", `{"timeout": "` + strings.Repeat("1", 8192) + `"}`, http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := adminJSONRequest(tt.method, "/timeout", tt.body)
+			req.Header.Set("Content-Type", tt.contentType)
+			w := httptest.NewRecorder()
+
+			requireAdmin(scopeTimeout, func(w http.ResponseWriter, r *http.Request) {
+				var body timeoutRequest
+				if decodeAdminJSON(w, r, http.MethodPut, &body) {
+					w.WriteHeader(http.StatusOK)
+				}
+			})(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("Expected status %d, got %d: %s", tt.want, w.Code, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestParseAdminTokens(t *testing.T) {
+	tokens, err := parseAdminTokens("ci:ci-secret:suspend,cancel-suspend; ops:ops-secret:*")
+	if err != nil {
+		t.Fatalf("parseAdminTokens: %v", err)
+	}
+	if len(tokens) != 2 || tokens[0].Name != "ci" || tokens[1].Token != "ops-secret" {
+		t.Fatalf("Unexpected tokens %+v", tokens)
+	}
+	if !tokens[0].allows(scopeCancelSuspend) || tokens[0].allows(scopeTimeout) || !tokens[1].allows(scopeShutdown) {
+		t.Fatalf("Unexpected scopes %+v", tokens)
+	}
+
+	for _, value := range []string{"ci:secret", "ci::suspend", "ci:secret:reboot", ":secret:*"} {
+		if _, err := parseAdminTokens(value); err == nil {
+			t.Fatalf("Expected an error for %q", value)
+		}
+	}
+}
+
+func TestScopedAdminTokens(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) {
+		cfg.Tokens = []adminToken{{Name: "ci", Token: "ci-secret", Scopes: []string{scopeTimeout}}}
+	})
+
+	tests := []struct {
+		name  string
+		token string
+		scope string
+		want  int
+	}{
+		{"scoped token within scope", "ci-secret", scopeTimeout, http.StatusOK},
+		{"scoped token outside scope", "ci-secret", scopeSuspend, http.StatusForbidden},
+		{"admin token has every scope", "test-admin-token", scopeSuspend, http.StatusOK},
+		{"unknown token", "other-secret", scopeTimeout, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/", nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			w := httptest.NewRecorder()
+
+			requireAdmin(tt.scope, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusOK)
+			})(w, req)
+
+			if w.Code != tt.want {
+				t.Fatalf("Expected status %d, got %d", tt.want, w.Code)
+			}
+		})
+	}
+}
+
+func TestRecordActivity(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		resetShutdownTimer()
+		time.Sleep(config().InactivityTimeout - time.Second)
+
+		w := httptest.NewRecorder()
+		requireAdmin(scopeActivity, activityHandler)(w, adminJSONRequest("POST", "/activity", `{"source": "github_actions"}`))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		time.Sleep(2 * time.Second)
+		if mockGCP.WasSuspendCalled() {
+			t.Fatal("Recorded activity should reset the timer like a ping")
+		}
+
+		tracker.mu.RLock()
+		lastSeen := tracker.sourceLastSeen["github_actions"]
+		tracker.mu.RUnlock()
+		if lastSeen.IsZero() || !currentStatus().LastActivity.Equal(lastSeen) {
+			t.Fatalf("Expected the activity to be recorded for its source, got %v", lastSeen)
+		}
+	})
+}
+
+func TestRecordActivityTimestamps(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	w := httptest.NewRecorder()
+	requireAdmin(scopeActivity, activityHandler)(w, adminJSONRequest("POST", "/activity", `{"timestamp": "`+future+`"}`))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for a future timestamp, got %d", w.Code)
+	}
+
+	// Activity older than the timeout is recorded but doesn't keep the machine online
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	w = httptest.NewRecorder()
+	requireAdmin(scopeActivity, activityHandler)(w, adminJSONRequest("POST", "/activity", `{"timestamp": "`+past+`"}`))
+	var body struct {
+		TimerReset bool `json:"timer_reset"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if body.TimerReset {
+		t.Fatal("Old activity should not reset the timer")
+	}
+}
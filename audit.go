@@ -0,0 +1,64 @@
+package main
+
+import (
+	"log/slog"
+	"net"
+	"net/http"
+)
+
+// auditLog records every admin request, it logs at Info regardless of LOG_LEVEL
+var auditLog = slog.Default()
+
+// statusRecorder remembers the status code a handler responded with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	if s.status == 0 {
+		s.status = status
+	}
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Write(p []byte) (int, error) {
+	if s.status == 0 {
+		s.status = http.StatusOK
+	}
+	return s.ResponseWriter.Write(p)
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer to flush and extend deadlines
+func (s *statusRecorder) Unwrap() http.ResponseWriter {
+	return s.ResponseWriter
+}
+
+// audit writes the audit line for an admin request once it has been handled
+func audit(r *http.Request, identity string, status int) {
+	clientIP, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		clientIP = r.RemoteAddr
+	}
+
+	// Handlers that write nothing respond with a 200
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	result := "ok"
+	switch {
+	case status == http.StatusUnauthorized:
+		result = "unauthorized"
+	case status >= 400:
+		result = "failed"
+	}
+
+	auditLog.Info("Admin action",
+		"action", r.Method+" "+r.URL.Path,
+		"query", r.URL.RawQuery,
+		"identity", identity,
+		"client_ip", clientIP,
+		"status", status,
+		"result", result)
+}
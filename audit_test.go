@@ -0,0 +1,42 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminActionsAreAudited(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	// The default logger only shows errors, audit lines must still come through
+	var buf bytes.Buffer
+	auditLog = newLogger(&buf, slog.LevelInfo).With("audit", true)
+	updateConfig(func(cfg *Config) { cfg.LibOpsKeepOnline = true })
+
+	req := adminJSONRequest("PUT", "/timeout", `{"timeout": "15m"}`)
+	req.RemoteAddr = "203.0.113.7:51234"
+	requireAdmin(scopeTimeout, timeoutHandler)(httptest.NewRecorder(), req)
+
+	req = httptest.NewRequest("POST", "/suspend", nil)
+	req.RemoteAddr = "198.51.100.2:40000"
+	requireAdmin(scopeSuspend, suspendHandler)(httptest.NewRecorder(), req)
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 audit lines, got %d:\n%s", len(lines), buf.String())
+	}
+	for _, want := range []string{`action="PUT /timeout"`, "identity=admin", "client_ip=203.0.113.7", "status=200", "result=ok", "audit=true", "instance=test-instance"} {
+		if !strings.Contains(lines[0], want) {
+			t.Fatalf("Expected %s in %q", want, lines[0])
+		}
+	}
+	for _, want := range []string{`action="POST /suspend"`, "client_ip=198.51.100.2", "status=401", "result=unauthorized"} {
+		if !strings.Contains(lines[1], want) {
+			t.Fatalf("Expected %s in %q", want, lines[1])
+		}
+	}
+}
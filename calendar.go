@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// calendarEvent is one VEVENT from CALENDAR_ICS_URL
+type calendarEvent struct {
+	Summary string
+	Start   time.Time
+	End     time.Time
+	// AllDay is set for events given as dates rather than times
+	AllDay bool
+	// Rule repeats the event from Start, skipping the starts in Except
+	Rule   *recurrenceRule
+	Except []time.Time
+}
+
+// recurrenceRule is the supported subset of an RRULE
+type recurrenceRule struct {
+	Freq     string
+	Interval int
+	// Count and Until bound the occurrences, Count includes the first one
+	Count int
+	Until time.Time
+	// ByDay lists the weekdays of a weekly rule
+	ByDay []time.Weekday
+}
+
+// maxOccurrences bounds how far a recurring event is expanded, about 27 years of a daily event
+const maxOccurrences = 10000
+
+// maxCalendarBytes bounds the calendar we are willing to download
+const maxCalendarBytes = 10 << 20
+
+var (
+	calendarClient = &http.Client{Timeout: 30 * time.Second}
+
+	calendarMu sync.Mutex
+	// calendarEvents is the last calendar fetched, kept for CALENDAR_REFRESH and past a failed refresh
+	calendarEvents    []calendarEvent
+	calendarFetchedAt time.Time
+)
+
+// calendarActivity keeps the machine online during keep-online events in CALENDAR_ICS_URL,
+// those whose summary starts with CALENDAR_KEEP_ONLINE_PREFIX
+// An event under way is activity happening now, and the end of the last one is when activity stopped,
+// so the inactivity timeout counts from the end of the window
+func calendarActivity(ctx context.Context) (time.Time, error) {
+	cfg := config()
+
+	events, err := cachedCalendar(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	now := time.Now()
+	var last time.Time
+	for _, event := range events {
+		if !strings.HasPrefix(strings.ToLower(event.Summary), strings.ToLower(cfg.CalendarKeepOnlinePrefix)) {
+			continue
+		}
+		event, ok := event.latestOccurrence(now)
+		if !ok {
+			continue
+		}
+		if !event.Start.After(now) && now.Before(event.End) {
+			return now, nil
+		}
+		if !event.End.After(now) && event.End.After(last) {
+			last = event.End
+		}
+	}
+	return last, nil
+}
+
+// cachedCalendar fetches CALENDAR_ICS_URL at most once per CALENDAR_REFRESH
+// If a refresh fails the calendar we already have is used, so a flaky calendar server doesn't end a keep-online window
+func cachedCalendar(ctx context.Context) ([]calendarEvent, error) {
+	cfg := config()
+
+	calendarMu.Lock()
+	defer calendarMu.Unlock()
+
+	if !calendarFetchedAt.IsZero() && time.Since(calendarFetchedAt) < cfg.CalendarRefresh {
+		return calendarEvents, nil
+	}
+
+	events, err := fetchCalendar(ctx, cfg.CalendarICSURL)
+	if err != nil {
+		if calendarFetchedAt.IsZero() {
+			return nil, err
+		}
+		slog.Warn("Failed to refresh calendar, using the last one fetched",
+			"fetched_at", calendarFetchedAt,
+			"error", err)
+		return calendarEvents, nil
+	}
+	calendarEvents, calendarFetchedAt = events, time.Now()
+	return events, nil
+}
+
+// fetchCalendar downloads the calendar at url
+// Its errors leave the URL out, a calendar's secret address is as good as a password
+func fetchCalendar(ctx context.Context, url string) ([]calendarEvent, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid calendar URL: %w", withoutURL(err))
+	}
+	resp, err := calendarClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("calendar request to %s failed: %w", req.URL.Host, withoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("calendar request failed: %s", resp.Status)
+	}
+	return parseICS(io.LimitReader(resp.Body, maxCalendarBytes))
+}
+
+// latestOccurrence returns the occurrence of e that started last at or before now
+// All occurrences last as long as the first, so it is the only one that can be under way or have ended last
+func (e calendarEvent) latestOccurrence(now time.Time) (calendarEvent, bool) {
+	if e.Start.After(now) {
+		return calendarEvent{}, false
+	}
+	if e.Rule == nil {
+		return e, true
+	}
+
+	duration := e.End.Sub(e.Start)
+	latest, found := calendarEvent{}, false
+	e.Rule.each(e.Start, func(start time.Time) bool {
+		if start.After(now) {
+			return false
+		}
+		if !slices.ContainsFunc(e.Except, start.Equal) {
+			latest = calendarEvent{Summary: e.Summary, Start: start, End: start.Add(duration), AllDay: e.AllDay}
+			found = true
+		}
+		return true
+	})
+	return latest, found
+}
+
+// each calls yield with the starts of the occurrences in order, beginning with start, until yield returns false
+// or the rule runs out
+func (r *recurrenceRule) each(start time.Time, yield func(time.Time) bool) {
+	interval := max(r.Interval, 1)
+	emitted := 0
+	emit := func(at time.Time) bool {
+		if at.Before(start) {
+			return true
+		}
+		if (r.Count > 0 && emitted >= r.Count) || (!r.Until.IsZero() && at.After(r.Until)) || emitted >= maxOccurrences {
+			return false
+		}
+		emitted++
+		return yield(at)
+	}
+
+	// Months and years that don't have start's day, like February 30th, are skipped rather than moved
+	for period := 0; emitted < maxOccurrences; period++ {
+		switch r.Freq {
+		case "DAILY":
+			if !emit(start.AddDate(0, 0, period*interval)) {
+				return
+			}
+		case "WEEKLY":
+			if len(r.ByDay) == 0 {
+				if !emit(start.AddDate(0, 0, 7*period*interval)) {
+					return
+				}
+				continue
+			}
+			// Weeks start on Monday
+			monday := start.AddDate(0, 0, -((int(start.Weekday())+6)%7)+7*period*interval)
+			for day := range 7 {
+				at := monday.AddDate(0, 0, day)
+				if slices.Contains(r.ByDay, at.Weekday()) && !emit(at) {
+					return
+				}
+			}
+		case "MONTHLY":
+			if at := start.AddDate(0, period*interval, 0); at.Day() == start.Day() && !emit(at) {
+				return
+			}
+		case "YEARLY":
+			if at := start.AddDate(period*interval, 0, 0); at.Day() == start.Day() && !emit(at) {
+				return
+			}
+		default:
+			return
+		}
+	}
+}
+
+// icsWeekdays maps the two letter days of BYDAY
+var icsWeekdays = map[string]time.Weekday{
+	"SU": time.Sunday, "MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday,
+	"TH": time.Thursday, "FR": time.Friday, "SA": time.Saturday,
+}
+
+// parseRRULE reads the FREQ, INTERVAL, COUNT, UNTIL and weekly BYDAY parts of a recurrence rule
+// Anything else would change which occurrences there are, so it is an error rather than ignored
+func parseRRULE(value string) (*recurrenceRule, error) {
+	rule := &recurrenceRule{Interval: 1}
+	for part := range strings.SplitSeq(value, ";") {
+		name, arg, _ := strings.Cut(part, "=")
+		var err error
+		switch strings.ToUpper(name) {
+		case "FREQ":
+			rule.Freq = strings.ToUpper(arg)
+			if !slices.Contains([]string{"DAILY", "WEEKLY", "MONTHLY", "YEARLY"}, rule.Freq) {
+				return nil, fmt.Errorf("unsupported FREQ %q", arg)
+			}
+		case "INTERVAL":
+			rule.Interval, err = strconv.Atoi(arg)
+		case "COUNT":
+			rule.Count, err = strconv.Atoi(arg)
+		case "UNTIL":
+			rule.Until, err = parseICSTime(arg, "")
+		case "BYDAY":
+			for day := range strings.SplitSeq(arg, ",") {
+				weekday, ok := icsWeekdays[strings.ToUpper(day)]
+				if !ok {
+					return nil, fmt.Errorf("unsupported BYDAY %q", day)
+				}
+				rule.ByDay = append(rule.ByDay, weekday)
+			}
+		case "WKST":
+			// Only matters for weekly rules with an interval, where we assume Monday like most calendars
+		default:
+			return nil, fmt.Errorf("unsupported rule part %s", name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if rule.Freq == "" {
+		return nil, fmt.Errorf("no FREQ")
+	}
+	if len(rule.ByDay) > 0 && rule.Freq != "WEEKLY" {
+		return nil, fmt.Errorf("BYDAY is only supported with FREQ=WEEKLY")
+	}
+	return rule, nil
+}
+
+// parseICS reads the events of an iCalendar file
+// Recurring events are expanded from their RRULE and EXDATEs, an RRULE we can't follow leaves just the first occurrence
+func parseICS(r io.Reader) ([]calendarEvent, error) {
+	var (
+		events  []calendarEvent
+		current *calendarEvent
+		lines   []string
+	)
+
+	// Long lines are folded onto continuation lines starting with a space or tab
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxCalendarBytes)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if len(lines) > 0 && (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read calendar: %w", err)
+	}
+
+	for _, line := range lines {
+		nameAndParams, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		name, params, _ := strings.Cut(nameAndParams, ";")
+
+		switch strings.ToUpper(name) {
+		case "BEGIN":
+			if strings.EqualFold(value, "VEVENT") {
+				current = &calendarEvent{}
+			}
+		case "END":
+			if strings.EqualFold(value, "VEVENT") && current != nil {
+				if current.Start.IsZero() {
+					return nil, fmt.Errorf("event %q has no DTSTART", current.Summary)
+				}
+				if current.End.IsZero() {
+					// An event without an end lasts the day when it is given as a date, and no time at all otherwise
+					current.End = current.Start
+					if current.AllDay {
+						current.End = current.Start.AddDate(0, 0, 1)
+					}
+				}
+				events = append(events, *current)
+				current = nil
+			}
+		case "SUMMARY":
+			if current != nil {
+				current.Summary = unescapeICS(value)
+			}
+		case "RRULE":
+			if current == nil {
+				continue
+			}
+			rule, err := parseRRULE(value)
+			if err != nil {
+				slog.Warn("Recurring calendar event only counts for its first occurrence",
+					"summary", current.Summary,
+					"error", err)
+				continue
+			}
+			current.Rule = rule
+		case "EXDATE":
+			if current == nil {
+				continue
+			}
+			for date := range strings.SplitSeq(value, ",") {
+				at, err := parseICSTime(date, params)
+				if err != nil {
+					return nil, fmt.Errorf("EXDATE of %q: %w", current.Summary, err)
+				}
+				current.Except = append(current.Except, at)
+			}
+		case "DTSTART", "DTEND":
+			if current == nil {
+				continue
+			}
+			at, err := parseICSTime(value, params)
+			if err != nil {
+				return nil, fmt.Errorf("%s of %q: %w", name, current.Summary, err)
+			}
+			if strings.EqualFold(name, "DTSTART") {
+				current.Start = at
+				current.AllDay = !strings.Contains(value, "T")
+			} else {
+				current.End = at
+			}
+		}
+	}
+
+	return events, nil
+}
+
+// parseICSTime reads a DATE-TIME in UTC, floating or with a TZID, or a whole-day DATE
+func parseICSTime(value, params string) (time.Time, error) {
+	location := time.Local
+	for _, param := range strings.Split(params, ";") {
+		if tzid, ok := strings.CutPrefix(param, "TZID="); ok {
+			loc, err := time.LoadLocation(strings.Trim(tzid, `"`))
+			if err != nil {
+				return time.Time{}, fmt.Errorf("unknown time zone %q", tzid)
+			}
+			location = loc
+		}
+	}
+
+	switch {
+	case strings.HasSuffix(value, "Z"):
+		return time.Parse("20060102T150405Z", value)
+	case strings.Contains(value, "T"):
+		return time.ParseInLocation("20060102T150405", value, location)
+	default:
+		return time.ParseInLocation("20060102", value, location)
+	}
+}
+
+// unescapeICS undoes the backslash escapes of an iCalendar TEXT value
+func unescapeICS(value string) string {
+	return strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`).Replace(value)
+}
+
+// resetCalendarCache forgets the fetched calendar
+func resetCalendarCache() {
+	calendarMu.Lock()
+	defer calendarMu.Unlock()
+
+	calendarEvents, calendarFetchedAt = nil, time.Time{}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseICS(t *testing.T) {
+	ics := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"BEGIN:VEVENT",
+		"SUMMARY:keep-online: quarterly",
+		"  close",
+		"DTSTART:20261016T090000Z",
+		"DTEND:20261016T170000Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"SUMMARY:Keep-online\\, Berlin office",
+		"DTSTART;TZID=Europe/Berlin:20261017T090000",
+		"DTEND;TZID=Europe/Berlin:20261017T120000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"SUMMARY:Holiday",
+		"DTSTART;VALUE=DATE:20261018",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	events, err := parseICS(strings.NewReader(ics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 {
+		t.Fatalf("Expected 3 events, got %+v", events)
+	}
+
+	if events[0].Summary != "keep-online: quarterly close" {
+		t.Fatalf("Expected the folded summary to be joined, got %q", events[0].Summary)
+	}
+	if want := time.Date(2026, 10, 16, 17, 0, 0, 0, time.UTC); !events[0].End.Equal(want) {
+		t.Fatalf("Expected the event to end at %v, got %v", want, events[0].End)
+	}
+
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Skip("no time zone database")
+	}
+	if events[1].Summary != "Keep-online, Berlin office" || !events[1].Start.Equal(time.Date(2026, 10, 17, 9, 0, 0, 0, berlin)) {
+		t.Fatalf("Unexpected event %+v", events[1])
+	}
+
+	if !events[2].AllDay || events[2].End.Sub(events[2].Start) != 24*time.Hour {
+		t.Fatalf("Expected an all-day event to last the day, got %+v", events[2])
+	}
+}
+
+func TestParseICSRejectsEventWithoutStart(t *testing.T) {
+	_, err := parseICS(strings.NewReader("BEGIN:VEVENT\nSUMMARY:keep-online\nEND:VEVENT\n"))
+	if err == nil {
+		t.Fatal("Expected an error for an event without DTSTART")
+	}
+}
+
+// useFakeCalendar serves events as an ICS file at CALENDAR_ICS_URL and counts the downloads
+func useFakeCalendar(t *testing.T, events ...calendarEvent) *atomic.Int32 {
+	t.Helper()
+
+	var fetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		w.Header().Set("Content-Type", "text/calendar")
+		fmt.Fprintln(w, "BEGIN:VCALENDAR")
+		for _, event := range events {
+			fmt.Fprintf(w, "BEGIN:VEVENT\nSUMMARY:%s\nDTSTART:%s\nDTEND:%s\nEND:VEVENT\n",
+				event.Summary, event.Start.UTC().Format("20060102T150405Z"), event.End.UTC().Format("20060102T150405Z"))
+		}
+		fmt.Fprintln(w, "END:VCALENDAR")
+	}))
+	t.Cleanup(server.Close)
+
+	updateConfig(func(cfg *Config) {
+		cfg.CalendarICSURL = server.URL
+		cfg.CalendarKeepOnlinePrefix = "keep-online"
+		cfg.CalendarRefresh = time.Minute
+	})
+	return &fetches
+}
+
+func TestCalendarActivity(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	now := time.Now().Truncate(time.Second)
+	ended := now.Add(-time.Hour)
+	fetches := useFakeCalendar(t,
+		calendarEvent{Summary: "keep-online release", Start: now.Add(-2 * time.Hour), End: ended},
+		calendarEvent{Summary: "team lunch", Start: now.Add(-time.Minute), End: now.Add(time.Hour)},
+		calendarEvent{Summary: "keep-online demo", Start: now.Add(time.Hour), End: now.Add(2 * time.Hour)},
+	)
+
+	// Only keep-online events count, and the one that ended is when activity stopped
+	lastActivity, err := calendarActivity(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !lastActivity.Equal(ended) {
+		t.Fatalf("Expected activity until the last keep-online event ended at %v, got %v", ended, lastActivity)
+	}
+
+	if _, err := calendarActivity(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if got := fetches.Load(); got != 1 {
+		t.Fatalf("Expected the calendar to be cached for CALENDAR_REFRESH, got %d fetches", got)
+	}
+}
+
+func TestCalendarActivityDuringEvent(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	now := time.Now()
+	useFakeCalendar(t, calendarEvent{Summary: "KEEP-ONLINE on-call", Start: now.Add(-time.Hour), End: now.Add(time.Hour)})
+
+	lastActivity, err := calendarActivity(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if time.Since(lastActivity) > time.Second {
+		t.Fatalf("Expected activity now during a keep-online event, got %v", lastActivity)
+	}
+}
+
+func TestCalendarKeptPastFailedRefresh(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	now := time.Now()
+	useFakeCalendar(t, calendarEvent{Summary: "keep-online", Start: now.Add(-time.Hour), End: now.Add(time.Hour)})
+	updateConfig(func(cfg *Config) { cfg.CalendarRefresh = 0 })
+
+	if _, err := calendarActivity(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+
+	updateConfig(func(cfg *Config) { cfg.CalendarICSURL = "http://127.0.0.1:0/calendar.ics" })
+	lastActivity, err := calendarActivity(t.Context())
+	if err != nil || time.Since(lastActivity) > time.Second {
+		t.Fatalf("Expected the last calendar to be kept, got %v, %v", lastActivity, err)
+	}
+
+	resetCalendarCache()
+	if _, err := calendarActivity(t.Context()); err == nil {
+		t.Fatal("Expected an error before any calendar was fetched")
+	}
+}
+
+func TestCalendarErrorHidesURL(t *testing.T) {
+	_, err := fetchCalendar(t.Context(), "http://127.0.0.1:0/private/s3cret/basic.ics")
+	if err == nil {
+		t.Fatal("Expected the request to fail")
+	}
+	if strings.Contains(err.Error(), "s3cret") {
+		t.Fatalf("Expected the calendar URL to be left out of %q", err)
+	}
+}
+
+func TestParseICSRecurringEvents(t *testing.T) {
+	ics := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"SUMMARY:keep-online standup",
+		"DTSTART:20261005T090000Z",
+		"DTEND:20261005T100000Z",
+		"RRULE:FREQ=WEEKLY;BYDAY=MO,WE,FR;UNTIL=20261231T000000Z",
+		"EXDATE:20261014T090000Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"SUMMARY:keep-online first monday",
+		"DTSTART:20261005T090000Z",
+		"RRULE:FREQ=MONTHLY;BYDAY=1MO",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+
+	events, err := parseICS(strings.NewReader(ics))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events[0].Rule == nil || events[1].Rule != nil {
+		t.Fatalf("Expected only the weekly rule to be followed, got %+v", events)
+	}
+
+	tests := []struct {
+		now       time.Time
+		wantStart time.Time
+	}{
+		// Under way on a Friday
+		{time.Date(2026, 10, 16, 9, 30, 0, 0, time.UTC), time.Date(2026, 10, 16, 9, 0, 0, 0, time.UTC)},
+		// Tuesday, Monday's was the last
+		{time.Date(2026, 10, 13, 12, 0, 0, 0, time.UTC), time.Date(2026, 10, 12, 9, 0, 0, 0, time.UTC)},
+		// Wednesday the 14th is excluded, so Monday's still was
+		{time.Date(2026, 10, 14, 9, 30, 0, 0, time.UTC), time.Date(2026, 10, 12, 9, 0, 0, 0, time.UTC)},
+		// Past UNTIL, the last was Wednesday the 30th of December
+		{time.Date(2027, 3, 1, 0, 0, 0, 0, time.UTC), time.Date(2026, 12, 30, 9, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		occurrence, ok := events[0].latestOccurrence(tt.now)
+		if !ok || !occurrence.Start.Equal(tt.wantStart) || occurrence.End.Sub(occurrence.Start) != time.Hour {
+			t.Fatalf("At %v expected the occurrence starting %v, got %+v", tt.now, tt.wantStart, occurrence)
+		}
+	}
+
+	if _, ok := events[0].latestOccurrence(time.Date(2026, 10, 1, 0, 0, 0, 0, time.UTC)); ok {
+		t.Fatal("Expected no occurrence before the first")
+	}
+}
+
+func TestRecurrenceCountAndMonthEnds(t *testing.T) {
+	start := time.Date(2026, 1, 31, 9, 0, 0, 0, time.UTC)
+	rule := &recurrenceRule{Freq: "MONTHLY", Interval: 1, Count: 4}
+
+	var starts []time.Time
+	rule.each(start, func(at time.Time) bool {
+		starts = append(starts, at)
+		return true
+	})
+
+	// Months without a 31st are skipped
+	want := []time.Time{start, start.AddDate(0, 2, 0), start.AddDate(0, 4, 0), start.AddDate(0, 6, 0)}
+	if !slices.EqualFunc(starts, want, time.Time.Equal) {
+		t.Fatalf("Expected %v, got %v", want, starts)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	suspendModeEnforce = "enforce"
+	suspendModeWarn    = "warn"
+)
+
+var canaryPromoted sync.Once
+
+// effectiveSuspendMode returns the mode currently in force
+// SUSPEND_MODE=warn only logs what it would have done until CANARY_DURATION has passed, then enforces
+func effectiveSuspendMode(now time.Time) string {
+	cfg := config()
+
+	if cfg.SuspendMode != suspendModeWarn {
+		return suspendModeEnforce
+	}
+
+	tracker.mu.RLock()
+	canaryEnds := tracker.startedAt.Add(cfg.CanaryDuration)
+	tracker.mu.RUnlock()
+
+	if now.Before(canaryEnds) {
+		return suspendModeWarn
+	}
+
+	canaryPromoted.Do(func() {
+		slog.Info("Canary period is over, suspends are now enforced",
+			"canary_duration_seconds", int(cfg.CanaryDuration.Seconds()))
+	})
+	return suspendModeEnforce
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestCanaryModeWarnsThenEnforces(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		updateConfig(func(cfg *Config) {
+			cfg.SuspendMode = suspendModeWarn
+			cfg.CanaryDuration = 5 * time.Minute
+		})
+
+		resetShutdownTimer()
+		time.Sleep(config().InactivityTimeout + time.Second)
+
+		if mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should not be called during the canary period")
+		}
+		if mode := currentStatus().SuspendMode; mode != suspendModeWarn {
+			t.Fatalf("Expected suspend mode %s, got %s", suspendModeWarn, mode)
+		}
+
+		tracker.mu.RLock()
+		last := tracker.decisions[len(tracker.decisions)-1]
+		tracker.mu.RUnlock()
+		if last.Action != "would_suspend" {
+			t.Fatalf("Expected a would_suspend decision, got %s", last.Action)
+		}
+
+		// The timer keeps going round until the canary period is over
+		time.Sleep(config().CanaryDuration)
+		if !mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should be enforced after the canary period")
+		}
+	})
+}
+
+func TestDefaultSuspendModeEnforces(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	if mode := effectiveSuspendMode(time.Now()); mode != suspendModeEnforce {
+		t.Fatalf("Expected suspend mode %s, got %s", suspendModeEnforce, mode)
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Exit codes, shared by the server and the one-shot commands
+const (
+	exitOK             = 0
+	exitSuspendFailed  = 1
+	exitUsage          = 2
+	exitConfigError    = 3
+	exitSuspendTimeout = 4
+)
+
+const usage = `Usage: lightsout [command]
+
+Without a command lightsout runs the HTTP server and suspends the instance after inactivity.
+
+Commands:
+  suspend    Suspend the configured instance once and exit
+
+Exit codes:
+  0  success, or the server was stopped by a signal
+  1  the suspend API call failed
+  2  usage error
+  3  invalid or missing configuration
+  4  timed out before the suspend went through
+`
+
+// runCommand runs a one-shot subcommand and returns the process exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "suspend":
+		return runSuspendCommand()
+	case "help", "-h", "--help":
+		fmt.Fprint(os.Stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+}
+
+func runSuspendCommand() int {
+	if missing := missingGCPConfig(config()); len(missing) > 0 {
+		slog.Error("Missing GCP configuration, cannot suspend", "missing", missing)
+		return exitConfigError
+	}
+
+	_, outcome, err := suspendMachine(context.Background())
+	if err != nil {
+		slog.Error("Failed to suspend instance", "error", err)
+		return suspendExitCode(err)
+	}
+
+	slog.Info("Suspend request completed successfully", "outcome", outcome)
+	return exitOK
+}
+
+// suspendExitCode maps the result of a suspend to the process exit code
+func suspendExitCode(err error) int {
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.Is(err, context.DeadlineExceeded):
+		return exitSuspendTimeout
+	default:
+		return exitSuspendFailed
+	}
+}
+
+// logExitCodes documents the exit codes at startup so supervisors can be configured from the logs
+func logExitCodes() {
+	slog.Info("Exit codes",
+		"ok", exitOK,
+		"suspend_failed", exitSuspendFailed,
+		"usage", exitUsage,
+		"config_error", exitConfigError,
+		"suspend_timeout", exitSuspendTimeout)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestRunCommandUnknown(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	if code := runCommand([]string{"resume"}); code != 2 {
+		t.Fatalf("Expected exit code 2 for an unknown command, got %d", code)
+	}
+}
+
+func TestSuspendCommandMissingConfig(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) { cfg.GCEInstance = "" })
+	if code := runCommand([]string{"suspend"}); code != exitConfigError {
+		t.Fatalf("Expected exit code %d without GCP config, got %d", exitConfigError, code)
+	}
+}
+
+func TestSuspendCommand(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			writeComputeJSON(w, compute.Operation{Name: "op-1", Status: "RUNNING"})
+			return
+		}
+		writeComputeJSON(w, compute.Instance{Name: "test-instance", Status: "RUNNING"})
+	}))
+
+	if code := runCommand([]string{"suspend"}); code != 0 {
+		t.Fatalf("Expected exit code 0, got %d", code)
+	}
+}
+
+func TestSuspendCommandAPIError(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		writeComputeJSON(w, map[string]any{"error": map[string]any{"code": 403, "message": "Forbidden"}})
+	}))
+
+	if code := runCommand([]string{"suspend"}); code != exitSuspendFailed {
+		t.Fatalf("Expected exit code 1 on API error, got %d", code)
+	}
+}
+
+func TestSuspendCommandTimeout(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	origSuspendTimeout := suspendTimeout
+	suspendTimeout = 50 * time.Millisecond
+	defer func() { suspendTimeout = origSuspendTimeout }()
+
+	useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+
+	if code := runCommand([]string{"suspend"}); code != exitSuspendTimeout {
+		t.Fatalf("Expected exit code %d on timeout, got %d", exitSuspendTimeout, code)
+	}
+}
+
+func TestFailedSuspendSetsServerExitCode(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+	defer exitCode.Store(exitOK)
+
+	suspendFunc = func(context.Context) error { return errors.New("failed to suspend instance: 403") }
+	suspendAndShutdown("test", nil)
+
+	if code := exitCode.Load(); code != exitSuspendFailed {
+		t.Fatalf("Expected exit code %d after a failed suspend, got %d", exitSuspendFailed, code)
+	}
+}
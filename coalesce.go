@@ -0,0 +1,115 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// suspendCall is one suspend shared by every trigger that fired while it was pending or running
+type suspendCall struct {
+	reasons []string
+	runner  *githubRunner
+	done    chan struct{}
+	err     error
+
+	// urgent is closed when a preemption joins, cutting the coalesce window short
+	urgent chan struct{}
+}
+
+var (
+	suspendCallMu  sync.Mutex
+	currentSuspend *suspendCall
+
+	suspendsCoalesced = &counter{
+		name: "lightsout_suspends_coalesced_total",
+		help: "Suspend triggers folded into a suspend that was already pending or running.",
+	}
+)
+
+// requestSuspend is how every trigger suspends the instance
+// The first trigger waits SUSPEND_COALESCE_WINDOW for others, then suspends once for all of them with a combined reason.
+// Triggers that arrive while that suspend is running wait for it and get its result rather than suspending again
+func requestSuspend(reason string, runner *githubRunner) error {
+	suspendCallMu.Lock()
+	if call := currentSuspend; call != nil {
+		if !slices.Contains(call.reasons, reason) {
+			call.reasons = append(call.reasons, reason)
+		}
+		if call.runner == nil {
+			call.runner = runner
+		}
+		if reason == reasonPreempted {
+			select {
+			case <-call.urgent:
+			default:
+				close(call.urgent)
+			}
+		}
+		suspendCallMu.Unlock()
+
+		suspendsCoalesced.inc()
+		slog.Info("Suspend already under way, joining it", "reason", reason)
+		<-call.done
+		return call.err
+	}
+
+	call := &suspendCall{reasons: []string{reason}, runner: runner, done: make(chan struct{}), urgent: make(chan struct{})}
+	currentSuspend = call
+	suspendCallMu.Unlock()
+
+	// A preemption can't wait, GCE only gives the instance about 30 seconds, so one that joins ends the window early
+	if window := config().SuspendCoalesceWindow; window > 0 && reason != reasonPreempted {
+		slog.Debug("Waiting for other suspend triggers", "reason", reason, "window", window)
+		select {
+		case <-time.After(window):
+		case <-call.urgent:
+			slog.Info("Preempted, suspending without waiting out the coalesce window")
+		}
+	}
+
+	// Reasons that arrive from here on still share the result, they are just too late to be named in it
+	suspendCallMu.Lock()
+	reason, runner = combinedSuspendReason(call.reasons), call.runner
+	suspendCallMu.Unlock()
+
+	call.err = suspendAndShutdown(reason, runner)
+
+	suspendCallMu.Lock()
+	currentSuspend = nil
+	suspendCallMu.Unlock()
+	close(call.done)
+
+	return call.err
+}
+
+// suspendUrgency ranks suspend reasons, someone asking for a suspend outranks the machine deciding it is idle
+// and a preemption outranks everything, the instance is going down either way
+func suspendUrgency(reason string) int {
+	switch {
+	case strings.HasPrefix(reason, reasonPreempted):
+		return 3
+	case strings.HasPrefix(reason, "manual suspend"):
+		return 2
+	case strings.HasPrefix(reason, "graceful shutdown"):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// combinedSuspendReason leads with the most urgent reason and lists the others after it,
+// e.g. "manual suspend (also inactivity timeout)"
+func combinedSuspendReason(reasons []string) string {
+	sorted := slices.Clone(reasons)
+	slices.SortStableFunc(sorted, func(a, b string) int {
+		return suspendUrgency(b) - suspendUrgency(a)
+	})
+
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	return sorted[0] + " (also " + strings.Join(sorted[1:], ", ") + ")"
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestSuspendTriggersCoalesced(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		updateConfig(func(cfg *Config) { cfg.SuspendCoalesceWindow = 2 * time.Second })
+
+		var attempts atomic.Int32
+		suspendFunc = func(context.Context) error {
+			attempts.Add(1)
+			return errors.New("backend error")
+		}
+		defer exitCode.Store(exitOK)
+		before := suspendsCoalesced.value.Load()
+
+		var wg sync.WaitGroup
+		errs := make([]error, 3)
+		wg.Go(func() { errs[0] = requestSuspend("inactivity timeout", nil) })
+		time.Sleep(time.Second)
+		wg.Go(func() { errs[1] = requestSuspend("manual suspend", nil) })
+		wg.Go(func() { errs[2] = requestSuspend("inactivity timeout", nil) })
+		wg.Wait()
+
+		if attempts.Load() != 1 {
+			t.Fatalf("Expected one suspend for all triggers, got %d", attempts.Load())
+		}
+		for i, err := range errs {
+			if err == nil || err.Error() != "backend error" {
+				t.Fatalf("Expected trigger %d to share the suspend's result, got %v", i, err)
+			}
+		}
+		if got := suspendsCoalesced.value.Load() - before; got != 2 {
+			t.Fatalf("Expected 2 coalesced triggers, got %d", got)
+		}
+
+		tracker.mu.RLock()
+		decisions := slices.Clone(tracker.decisions)
+		tracker.mu.RUnlock()
+		want := "manual suspend (also inactivity timeout)"
+		if !slices.ContainsFunc(decisions, func(d decision) bool { return d.Action == "suspend" && d.Reason == want }) {
+			t.Fatalf("Expected a suspend decision for %q, got %+v", want, decisions)
+		}
+
+		// Once it's done the next trigger suspends again
+		_ = requestSuspend("inactivity timeout", nil)
+		if attempts.Load() != 2 {
+			t.Fatalf("Expected a new suspend after the last one finished, got %d", attempts.Load())
+		}
+	})
+}
+
+func TestPreemptionJoinsPendingSuspend(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		updateConfig(func(cfg *Config) {
+			cfg.SuspendCoalesceWindow = 2 * time.Second
+		})
+
+		var attempts atomic.Int32
+		suspendFunc = func(context.Context) error {
+			attempts.Add(1)
+			return nil
+		}
+
+		var wg sync.WaitGroup
+		var timerErr error
+		wg.Go(func() { timerErr = requestSuspend("inactivity timeout", nil) })
+		time.Sleep(time.Second)
+		start := time.Now()
+		handlePreemption()
+		wg.Wait()
+
+		if waited := time.Since(start); waited > 0 {
+			t.Fatalf("Expected the preemption to cut the coalesce window short, it waited %s", waited)
+		}
+
+		if attempts.Load() != 0 || timerErr != nil {
+			t.Fatalf("Expected the preemption to take over without a suspend, got %d attempts and %v", attempts.Load(), timerErr)
+		}
+		select {
+		case <-serverShutdown:
+		default:
+			t.Fatal("Expected the servers to drain after a preemption")
+		}
+	})
+}
+
+func TestCombinedSuspendReason(t *testing.T) {
+	tests := []struct {
+		reasons []string
+		want    string
+	}{
+		{[]string{"inactivity timeout"}, "inactivity timeout"},
+		{[]string{"inactivity timeout", "graceful shutdown"}, "graceful shutdown (also inactivity timeout)"},
+		{[]string{"graceful shutdown", "inactivity timeout", "manual suspend"}, "manual suspend (also graceful shutdown, inactivity timeout)"},
+		{[]string{"manual suspend", "preempted"}, "preempted (also manual suspend)"},
+	}
+
+	for _, tt := range tests {
+		if got := combinedSuspendReason(tt.reasons); got != tt.want {
+			t.Fatalf("combinedSuspendReason(%v): expected %q, got %q", tt.reasons, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,760 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// currentConfig holds the active config
+// It is only ever replaced as a whole so readers can't see a Config that is half way through being updated
+var currentConfig atomic.Pointer[Config]
+
+// config returns the active config
+// Treat the result as read-only, and take it once when several fields need to agree with each other
+func config() *Config {
+	return currentConfig.Load()
+}
+
+// updateConfig applies change to a copy of the active config and swaps the copy in
+func updateConfig(change func(*Config)) {
+	for {
+		old := currentConfig.Load()
+		updated := *old
+		change(&updated)
+		if currentConfig.CompareAndSwap(old, &updated) {
+			return
+		}
+	}
+}
+
+// reloadConfig reads the config from the environment again, then re-applies CONTROL_FILE on top of it
+// This drops runtime changes made via PUT /timeout, while listeners, logging, activity sources and metrics
+// are set up once at startup and still need a restart to change
+func reloadConfig() error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	currentConfig.Store(cfg)
+
+	if cfg.ControlFile != "" {
+		if err := applyControlFile(); err != nil && !os.IsNotExist(err) {
+			slog.Error("Failed to apply control file", "path", cfg.ControlFile, "error", err)
+		}
+	}
+
+	if keepOnline() {
+		stopShutdownTimer()
+	} else {
+		resetShutdownTimer()
+	}
+
+	slog.Info("Config reloaded",
+		"inactivity_timeout", inactivityTimeout(),
+		"keep_online", keepOnline())
+	logEffectiveConfig(cfg)
+	return nil
+}
+
+// logEffectiveConfig reports every config field and the optional features they enable in one line,
+// so operators can check what was actually applied rather than what they meant to set
+func logEffectiveConfig(cfg *Config) {
+	sources := make([]string, 0, len(activitySources))
+	for _, source := range activitySources {
+		sources = append(sources, source.Name())
+	}
+
+	attrs := append(configAttrs(cfg),
+		slog.Any("features", enabledFeatures(cfg)),
+		slog.Any("activity_sources", sources))
+	slog.Info("config", attrs...)
+}
+
+// configAttrs returns a log attribute per config field, secrets only say whether they are set
+func configAttrs(cfg *Config) []any {
+	var attrs []any
+	reportConfig(cfg, func(name string, value any) {
+		attrs = append(attrs, slog.Any(name, value))
+	})
+	return attrs
+}
+
+// reportConfig calls fn with each config field's name and its value as it is safe to report, in field order
+// Secrets only say whether they are set
+func reportConfig(cfg *Config, fn func(name string, value any)) {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		value := v.Field(i)
+
+		switch {
+		case field.Tag.Get("report") == "secret":
+			redacted := ""
+			if !value.IsZero() {
+				redacted = "[redacted]"
+			}
+			fn(field.Name, redacted)
+		case value.Kind() == reflect.Pointer && value.IsNil():
+			fn(field.Name, nil)
+		case value.Kind() == reflect.Pointer:
+			fn(field.Name, value.Elem().Interface())
+		default:
+			fn(field.Name, value.Interface())
+		}
+	}
+}
+
+// enabledFeatures names the optional features cfg turns on
+func enabledFeatures(cfg *Config) []string {
+	var features []string
+	add := func(name string, enabled bool) {
+		if enabled {
+			features = append(features, name)
+		}
+	}
+
+	add("admin_api", len(adminTokens(cfg)) > 0)
+	add("github_runner_api", cfg.GitHubToken != "")
+	add("github_runner_status_file", cfg.GitHubRunnerStatusFile != "")
+	add("post_job_timeout", cfg.PostJobTimeout > 0)
+	add("armed_timeout", cfg.ArmedTimeout > 0)
+	add("timeout_schedule", cfg.TimeoutSchedule != nil)
+	add("instance_schedule", cfg.RespectInstanceSchedule)
+	add("canary", cfg.SuspendMode == suspendModeWarn)
+	add("ping_extend", cfg.PingMode == pingModeExtend)
+	add("healthcheck_activity", cfg.HealthcheckCountsAsActivity)
+	add("ping_concurrency_limit", cfg.MaxConcurrentPings > 0)
+	add("activity_scoring", cfg.ActivityScoring)
+	add("control_file", cfg.ControlFile != "")
+	add("calendar", cfg.CalendarICSURL != "")
+	add("state_file", cfg.StateFile != "")
+	add("warmup_grace", cfg.WarmupGrace)
+	add("pre_suspend_hook", cfg.PreSuspendHook != "")
+	add("stop_containers", len(cfg.StopContainers) > 0)
+	add("suspend_webhook", cfg.SuspendWebhookURL != "")
+	add("suspend_label", cfg.RecordSuspendLabel)
+	add("suspend_retry", cfg.SuspendRetryInterval > 0)
+	add("suspend_coalesce", cfg.SuspendCoalesceWindow > 0)
+	add("suspend_throttle", cfg.MaxSuspendsPerHour > 0)
+	add("suspend_lock", cfg.SuspendLock != "")
+	add("dependency_check", cfg.DependencyHealthURL != "")
+	add("heartbeat", cfg.HeartbeatURL != "")
+	add("keep_online_report", cfg.KeepOnlineLogInterval > 0)
+	add("reconcile", cfg.ReconcileInterval > 0)
+	add("statsd", cfg.StatsDAddr != "")
+	add("preemption_watch", cfg.WatchPreemption)
+	add("instance_list", cfg.InstanceListFile != "")
+	add("verify_suspend_state", cfg.VerifySuspendState)
+	add("fallback_to_stop", cfg.FallbackToStop)
+	add("auto_discover_zone", cfg.AutoDiscoverZone)
+	add("public_port", cfg.PublicPort != "")
+	add("h2c", cfg.HTTP2Cleartext)
+	add("http3", cfg.HTTP3)
+	add("pprof", cfg.EnablePprof)
+	return features
+}
+
+// watchReloadSignal reloads the config on SIGHUP until ctx is cancelled
+func watchReloadSignal(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			slog.Info("SIGHUP received, reloading config")
+			if err := reloadConfig(); err != nil {
+				slog.Error("Failed to reload config, keeping the current one", "error", err)
+			}
+		}
+	}
+}
+
+// Config is the effective configuration, fields tagged report:"secret" are redacted from the startup report
+type Config struct {
+	Port               string
+	InactivityTimeout  time.Duration
+	ArmedTimeout       time.Duration
+	LibOpsKeepOnline   bool
+	LogLevel           string
+	LogFormat          string
+	Provider           string
+	GoogleProjectID    string
+	GCEZone            string
+	GCEInstance        string
+	AutoDiscoverZone   bool
+	RecordSuspendLabel bool
+	TokenExpiryWarning time.Duration
+
+	InstanceListFile    string
+	InstanceListRefresh time.Duration
+
+	VerifySuspendState   bool
+	FallbackToStop       bool
+	PermissionCheck      bool
+	VerifySuspendTimeout time.Duration
+
+	GitHubToken            string `report:"secret"`
+	GitHubAPIURL           string
+	GitHubRepository       string
+	GitHubOrg              string
+	GitHubRunnerName       string
+	GitHubRunnerStatusFile string
+	GitHubRemoveRunner     bool
+	GitHubSuspendOnUnknown bool
+	FutureTimestamps       string
+	GHACheckCacheTTL       time.Duration
+	GHACheckTimeout        time.Duration
+	PostJobTimeout         time.Duration
+	PostJobPollInterval    time.Duration
+	GitHubRunnerContainers []string
+
+	StateFile   string
+	WarmupGrace bool
+
+	PublicPort     string
+	PrivateAddress string
+	HTTP2Cleartext bool
+	HTTP3          bool
+	EnablePprof    bool
+	TLSCertFile    string
+	TLSKeyFile     string
+
+	AdminToken           string       `report:"secret"`
+	Tokens               []adminToken `report:"secret"`
+	AdminMaxBodyBytes    int64
+	ManualSuspendDelay   time.Duration
+	DrainTimeout         time.Duration
+	ShutdownTimeout      time.Duration
+	MaxInactivityTimeout time.Duration
+	MinInactivityTimeout time.Duration
+
+	QueueDepthCommand string
+	QueueDepthURL     string
+
+	JobLockFile string
+	DeployLock  string
+
+	CalendarICSURL           string `report:"secret"`
+	CalendarKeepOnlinePrefix string
+	CalendarRefresh          time.Duration
+
+	HeartbeatURL      string `report:"secret"`
+	HeartbeatInterval time.Duration
+
+	KeepOnlineLogInterval time.Duration
+
+	ReconcileInterval time.Duration
+	InstanceCacheTTL  time.Duration
+
+	StatsDAddr     string
+	StatsDInterval time.Duration
+
+	WatchGPU         bool
+	WatchGCPCPU      bool
+	GCPCPUThreshold  float64
+	WatchSSHSessions bool
+
+	WatchNetThroughput     bool
+	NetInterface           string
+	NetThroughputThreshold int
+
+	WatchWorkdir bool
+	Workdir      string
+
+	WatchPreemption bool
+
+	ActivityScoring        bool
+	ActivityScoreHalfLife  time.Duration
+	ActivityScoreThreshold float64
+
+	ControlFile string
+
+	ActiveDurationBuckets []float64
+
+	SuspendMode    string
+	CanaryDuration time.Duration
+
+	SourcePolicy string
+
+	MaxSuspendsPerHour int
+
+	SuspendRetryInterval time.Duration
+	SuspendRetryAttempts int
+
+	SuspendLock    string
+	SuspendLockTTL time.Duration
+
+	RespectInstanceSchedule bool
+	InstanceScheduleRefresh time.Duration
+
+	PreSuspendHook string
+	HookTimeout    time.Duration
+
+	ShutdownAbortOn []string
+
+	SuspendCoalesceWindow time.Duration
+
+	DependencyHealthURL string
+
+	SuspendWebhookURL string `report:"secret"`
+	WebhookSecret     string `report:"secret"`
+
+	StopContainers        []string
+	StopContainersTimeout time.Duration
+
+	IgnorePingUserAgents []string
+	// PingSources are the source names a POST /ping batch may report
+	PingSources        []string
+	PingThreshold      int
+	MaxConcurrentPings int
+	// HealthcheckCountsAsActivity makes /healthcheck count like a /ping
+	HealthcheckCountsAsActivity bool
+	PingThresholdWindow         time.Duration
+	WaitTimeout                 time.Duration
+
+	PingMode            string
+	PingExtendIncrement time.Duration
+	PingExtendCap       time.Duration
+
+	TimeoutSchedule *timeoutSchedule
+}
+
+// loadConfig reads the configuration from the environment
+// Invalid values fall back to their defaults with a warning, unless STRICT_CONFIG is set in which case they are returned as an error
+func loadConfig() (*Config, error) {
+	l := &configLoader{}
+	l.strict = l.bool("STRICT_CONFIG", false)
+
+	cfg := &Config{
+		Port:               getEnv("PORT", "8808"),
+		InactivityTimeout:  l.duration("INACTIVITY_TIMEOUT", 90) * time.Second,
+		ArmedTimeout:       l.duration("ARMED_TIMEOUT", 0) * time.Second,
+		LogLevel:           getEnv("LOG_LEVEL", "INFO"),
+		LogFormat:          l.oneOf("LOG_FORMAT", logFormatText, logFormatText, logFormatGCP),
+		GoogleProjectID:    getEnv("GCP_PROJECT", ""),
+		GCEZone:            getEnv("GCP_ZONE", ""),
+		GCEInstance:        getEnv("GCP_INSTANCE_NAME", ""),
+		AutoDiscoverZone:   l.bool("AUTO_DISCOVER_ZONE", false),
+		RecordSuspendLabel: l.bool("RECORD_SUSPEND_LABEL", false),
+		TokenExpiryWarning: l.duration("TOKEN_EXPIRY_WARNING", int(defaultTokenExpiryWarning.Seconds())) * time.Second,
+		LibOpsKeepOnline:   l.bool("LIBOPS_KEEP_ONLINE", false),
+
+		InstanceListFile:    getEnv("INSTANCE_LIST_FILE", ""),
+		InstanceListRefresh: l.duration("INSTANCE_LIST_REFRESH", 60) * time.Second,
+
+		VerifySuspendState:   l.bool("VERIFY_SUSPEND_STATE", false),
+		FallbackToStop:       l.bool("FALLBACK_TO_STOP", false),
+		PermissionCheck:      l.bool("PERMISSION_CHECK", true),
+		VerifySuspendTimeout: l.duration("VERIFY_SUSPEND_TIMEOUT", 120) * time.Second,
+
+		GitHubToken:            l.secret("GITHUB_TOKEN"),
+		GitHubAPIURL:           getEnv("GITHUB_API_URL", "https://api.github.com"),
+		GitHubRepository:       getEnv("GITHUB_REPOSITORY", ""),
+		GitHubOrg:              getEnv("GITHUB_ORG", ""),
+		GitHubRunnerName:       getEnv("GITHUB_RUNNER_NAME", hostname()),
+		GitHubRunnerStatusFile: getEnv("GITHUB_RUNNER_STATUS_FILE", ""),
+		GitHubRemoveRunner:     l.bool("GITHUB_REMOVE_RUNNER", false),
+		GitHubSuspendOnUnknown: l.bool("GITHUB_SUSPEND_ON_UNKNOWN", false),
+		FutureTimestamps:       l.oneOf("FUTURE_TIMESTAMPS", futureTimestampsNow, futureTimestampsNow, futureTimestampsIgnore),
+		GHACheckCacheTTL:       l.duration("GHA_CHECK_CACHE_TTL", 0) * time.Second,
+		GHACheckTimeout:        l.duration("GHA_CHECK_TIMEOUT", 10) * time.Second,
+		PostJobTimeout:         l.duration("POST_JOB_TIMEOUT", 0) * time.Second,
+		PostJobPollInterval:    l.duration("POST_JOB_POLL_INTERVAL", 15) * time.Second,
+		GitHubRunnerContainers: getListEnvDefault("GITHUB_RUNNER_CONTAINERS", "github-actions-runner"),
+
+		StateFile:   getEnv("STATE_FILE", ""),
+		WarmupGrace: l.bool("WARMUP_GRACE", false),
+
+		PublicPort:     getEnv("PUBLIC_PORT", ""),
+		PrivateAddress: getEnv("PRIVATE_ADDRESS", ""),
+		HTTP2Cleartext: l.bool("HTTP2_CLEARTEXT", false),
+		HTTP3:          l.bool("HTTP3", false),
+		EnablePprof:    l.bool("ENABLE_PPROF", false),
+		TLSCertFile:    getEnv("TLS_CERT_FILE", ""),
+		TLSKeyFile:     getEnv("TLS_KEY_FILE", ""),
+
+		AdminToken:           l.secret("ADMIN_TOKEN"),
+		Tokens:               l.adminTokens("TOKENS"),
+		AdminMaxBodyBytes:    int64(l.int("ADMIN_MAX_BODY_BYTES", 4096)),
+		ManualSuspendDelay:   l.duration("MANUAL_SUSPEND_DELAY", 30) * time.Second,
+		DrainTimeout:         l.duration("DRAIN_TIMEOUT", 600) * time.Second,
+		ShutdownTimeout:      l.duration("SHUTDOWN_TIMEOUT", 10) * time.Second,
+		MaxInactivityTimeout: l.duration("MAX_INACTIVITY_TIMEOUT", 86400) * time.Second,
+
+		QueueDepthCommand: getEnv("QUEUE_DEPTH_COMMAND", ""),
+		QueueDepthURL:     getEnv("QUEUE_DEPTH_URL", ""),
+
+		JobLockFile: getEnv("JOB_LOCK_FILE", ""),
+		DeployLock:  getEnv("DEPLOY_LOCK", ""),
+
+		CalendarICSURL:           l.secret("CALENDAR_ICS_URL"),
+		CalendarKeepOnlinePrefix: getEnv("CALENDAR_KEEP_ONLINE_PREFIX", "keep-online"),
+		CalendarRefresh:          l.duration("CALENDAR_REFRESH", 300) * time.Second,
+
+		HeartbeatURL:      l.secret("HEARTBEAT_URL"),
+		HeartbeatInterval: l.duration("HEARTBEAT_INTERVAL", 60) * time.Second,
+
+		KeepOnlineLogInterval: l.duration("KEEP_ONLINE_LOG_INTERVAL", 0) * time.Second,
+
+		ReconcileInterval: l.duration("RECONCILE_INTERVAL", 0) * time.Second,
+		InstanceCacheTTL:  l.duration("INSTANCE_CACHE_TTL", 10) * time.Second,
+
+		StatsDAddr:     getEnv("STATSD_ADDR", ""),
+		StatsDInterval: l.duration("STATSD_INTERVAL", 10) * time.Second,
+
+		WatchGPU:         l.bool("WATCH_GPU", false),
+		WatchGCPCPU:      l.bool("WATCH_GCP_CPU", false),
+		GCPCPUThreshold:  l.float("GCP_CPU_THRESHOLD", 10),
+		WatchSSHSessions: l.bool("WATCH_SSH_SESSIONS", false),
+
+		WatchNetThroughput:     l.bool("WATCH_NET_THROUGHPUT", false),
+		NetInterface:           getEnv("NET_INTERFACE", ""),
+		NetThroughputThreshold: l.int("NET_THROUGHPUT_THRESHOLD", 102400),
+
+		WatchWorkdir: l.bool("WATCH_WORKDIR", false),
+		Workdir:      getEnv("WORKDIR", ""),
+
+		WatchPreemption: l.bool("WATCH_PREEMPTION", false),
+
+		ActivityScoring:        l.bool("ACTIVITY_SCORING", false),
+		ActivityScoreHalfLife:  l.duration("ACTIVITY_SCORE_HALF_LIFE", 300) * time.Second,
+		ActivityScoreThreshold: l.float("ACTIVITY_SCORE_THRESHOLD", 1),
+
+		ControlFile: getEnv("CONTROL_FILE", ""),
+
+		ActiveDurationBuckets: l.buckets("ACTIVE_DURATION_BUCKETS", "300,900,1800,3600,7200,14400,28800,86400"),
+
+		SuspendMode:    l.oneOf("SUSPEND_MODE", suspendModeEnforce, suspendModeEnforce, suspendModeWarn),
+		CanaryDuration: l.duration("CANARY_DURATION", 86400) * time.Second,
+
+		SourcePolicy: l.oneOf("SOURCE_POLICY", sourcePolicyAll, sourcePolicyAll, sourcePolicyAnyIdle),
+
+		MaxSuspendsPerHour: l.int("MAX_SUSPENDS_PER_HOUR", 0),
+
+		SuspendRetryInterval: l.duration("SUSPEND_RETRY_INTERVAL", 0) * time.Second,
+		SuspendRetryAttempts: l.int("SUSPEND_RETRY_ATTEMPTS", 3),
+
+		SuspendLock:    getEnv("SUSPEND_LOCK", ""),
+		SuspendLockTTL: l.duration("SUSPEND_LOCK_TTL", 300) * time.Second,
+
+		RespectInstanceSchedule: l.bool("RESPECT_INSTANCE_SCHEDULE", false),
+		InstanceScheduleRefresh: l.duration("INSTANCE_SCHEDULE_REFRESH", 600) * time.Second,
+
+		PreSuspendHook: getEnv("PRE_SUSPEND_HOOK", ""),
+		HookTimeout:    l.duration("HOOK_TIMEOUT", 60) * time.Second,
+
+		ShutdownAbortOn: l.subsetOf("SHUTDOWN_ABORT_ON", abortableStages...),
+
+		SuspendCoalesceWindow: l.duration("SUSPEND_COALESCE_WINDOW", 0) * time.Second,
+
+		DependencyHealthURL: getEnv("DEPENDENCY_HEALTH_URL", ""),
+
+		SuspendWebhookURL: l.secret("SUSPEND_WEBHOOK_URL"),
+		WebhookSecret:     l.secret("WEBHOOK_SECRET"),
+
+		StopContainers:        getListEnv("STOP_CONTAINERS"),
+		StopContainersTimeout: l.duration("STOP_CONTAINERS_TIMEOUT", 30) * time.Second,
+
+		IgnorePingUserAgents:        getListEnv("IGNORE_PING_USER_AGENTS"),
+		PingSources:                 getListEnv("PING_SOURCES"),
+		PingThreshold:               l.int("PING_THRESHOLD", 1),
+		HealthcheckCountsAsActivity: l.bool("HEALTHCHECK_COUNTS_AS_ACTIVITY", false),
+		PingThresholdWindow:         l.duration("PING_THRESHOLD_WINDOW", 60) * time.Second,
+		WaitTimeout:                 l.duration("WAIT_TIMEOUT", 300) * time.Second,
+
+		PingMode:            l.oneOf("PING_MODE", pingModeReset, pingModeReset, pingModeExtend),
+		PingExtendIncrement: l.duration("PING_EXTEND_INCREMENT", 120) * time.Second,
+		PingExtendCap:       l.duration("PING_EXTEND_CAP", 0) * time.Second,
+
+		TimeoutSchedule: l.timeoutSchedule("TIMEOUT_SCHEDULE"),
+	}
+
+	l.instanceSelfLink(cfg, "GCP_INSTANCE_SELF_LINK")
+	cfg.Provider = l.oneOf("PROVIDER", defaultProvider(cfg), providerGCP, providerNoop)
+
+	// QUIC always runs over TLS
+	if cfg.HTTP3 && (cfg.TLSCertFile == "" || cfg.TLSKeyFile == "") {
+		l.invalid("HTTP3", false, fmt.Errorf("HTTP3: needs TLS_CERT_FILE and TLS_KEY_FILE"))
+		cfg.HTTP3 = false
+	}
+
+	// A public port is only for the healthcheck, so the control surface must not share every interface with it
+	if cfg.PublicPort != "" {
+		if cfg.PrivateAddress == "" {
+			cfg.PrivateAddress = net.JoinHostPort("127.0.0.1", cfg.Port)
+		}
+		if _, port, err := net.SplitHostPort(cfg.PrivateAddress); err == nil && port == cfg.PublicPort {
+			return nil, fmt.Errorf("PUBLIC_PORT: %s is also the port of the private listener %s", cfg.PublicPort, cfg.PrivateAddress)
+		}
+	}
+
+	cfg.MinInactivityTimeout = l.duration("MIN_INACTIVITY_TIMEOUT", 5) * time.Second
+	if cfg.MinInactivityTimeout <= 0 {
+		l.invalid("MIN_INACTIVITY_TIMEOUT", 5, fmt.Errorf("MIN_INACTIVITY_TIMEOUT: must be positive"))
+		cfg.MinInactivityTimeout = 5 * time.Second
+	}
+	// A zero or tiny timeout would suspend again right after every resume
+	if cfg.InactivityTimeout < cfg.MinInactivityTimeout {
+		l.invalid("INACTIVITY_TIMEOUT", cfg.MinInactivityTimeout.Seconds(),
+			fmt.Errorf("INACTIVITY_TIMEOUT: %s is below the minimum of %s", cfg.InactivityTimeout, cfg.MinInactivityTimeout))
+		cfg.InactivityTimeout = cfg.MinInactivityTimeout
+	}
+	if cfg.TimeoutSchedule != nil {
+		for _, timeout := range cfg.TimeoutSchedule {
+			if timeout != 0 && timeout < cfg.MinInactivityTimeout {
+				l.invalid("TIMEOUT_SCHEDULE", "",
+					fmt.Errorf("TIMEOUT_SCHEDULE: %s is below the minimum of %s", timeout, cfg.MinInactivityTimeout))
+				cfg.TimeoutSchedule = nil
+				break
+			}
+		}
+	}
+
+	if l.strict && len(l.errs) > 0 {
+		return nil, errors.Join(l.errs...)
+	}
+
+	return cfg, nil
+}
+
+// configLoader collects invalid values while loading the config so they can all be reported at once
+type configLoader struct {
+	strict bool
+	errs   []error
+}
+
+func (l *configLoader) duration(key string, defaultSeconds int) time.Duration {
+	d, err := getDurationEnv(key, defaultSeconds)
+	if err != nil {
+		l.invalid(key, defaultSeconds, err)
+	}
+	return d
+}
+
+func (l *configLoader) int(key string, defaultValue int) int {
+	i, err := getIntEnv(key, defaultValue)
+	if err != nil {
+		l.invalid(key, defaultValue, err)
+	}
+	return i
+}
+
+func (l *configLoader) float(key string, defaultValue float64) float64 {
+	f, err := getFloatEnv(key, defaultValue)
+	if err != nil {
+		l.invalid(key, defaultValue, err)
+	}
+	return f
+}
+
+func (l *configLoader) bool(key string, defaultValue bool) bool {
+	b, err := getBoolEnv(key, defaultValue)
+	if err != nil {
+		l.invalid(key, defaultValue, err)
+	}
+	return b
+}
+
+func (l *configLoader) buckets(key string, defaultValue string) []float64 {
+	buckets, err := parseBuckets(getEnv(key, defaultValue))
+	if err != nil {
+		l.invalid(key, defaultValue, fmt.Errorf("%s: %v", key, err))
+		buckets, _ = parseBuckets(defaultValue)
+	}
+	return buckets
+}
+
+func (l *configLoader) oneOf(key string, defaultValue string, allowed ...string) string {
+	value := strings.ToLower(getEnv(key, defaultValue))
+	if !slices.Contains(allowed, value) {
+		l.invalid(key, defaultValue, fmt.Errorf("%s: %q must be one of %s", key, value, strings.Join(allowed, ", ")))
+		return defaultValue
+	}
+	return value
+}
+
+// subsetOf reads a comma separated list whose entries must all be in allowed, dropping the ones that aren't
+func (l *configLoader) subsetOf(key string, allowed ...string) []string {
+	var values []string
+	for _, value := range getListEnv(key) {
+		value = strings.ToLower(value)
+		if !slices.Contains(allowed, value) {
+			l.invalid(key, "", fmt.Errorf("%s: %q must be one of %s", key, value, strings.Join(allowed, ", ")))
+			continue
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+func (l *configLoader) timeoutSchedule(key string) *timeoutSchedule {
+	schedule, err := parseTimeoutSchedule(getEnv(key, ""))
+	if err != nil {
+		l.invalid(key, "", fmt.Errorf("%s: %v", key, err))
+		return nil
+	}
+	return schedule
+}
+
+func (l *configLoader) adminTokens(key string) []adminToken {
+	tokens, err := parseAdminTokens(l.secret(key))
+	if err != nil {
+		// Don't echo the value back, it holds secrets
+		l.invalid(key, "", fmt.Errorf("%s: %v", key, err))
+		return nil
+	}
+	return tokens
+}
+
+// instanceSelfLink fills in the project, zone and instance name from a self-link
+// Any of GCP_PROJECT, GCP_ZONE and GCP_INSTANCE_NAME that are also set must agree with it
+func (l *configLoader) instanceSelfLink(cfg *Config, key string) {
+	link := getEnv(key, "")
+	if link == "" {
+		return
+	}
+
+	project, zone, name, err := parseInstanceSelfLink(link)
+	if err != nil {
+		l.invalid(key, "", fmt.Errorf("%s: %v", key, err))
+		return
+	}
+
+	for _, field := range []struct {
+		key   string
+		value *string
+		want  string
+	}{
+		{"GCP_PROJECT", &cfg.GoogleProjectID, project},
+		{"GCP_ZONE", &cfg.GCEZone, zone},
+		{"GCP_INSTANCE_NAME", &cfg.GCEInstance, name},
+	} {
+		if *field.value != "" && *field.value != field.want {
+			l.invalid(key, "", fmt.Errorf("%s: %s is %q but the self-link says %q", key, field.key, *field.value, field.want))
+			return
+		}
+	}
+
+	cfg.GoogleProjectID, cfg.GCEZone, cfg.GCEInstance = project, zone, name
+}
+
+// secret reads key, resolving it through Secret Manager if it is an sm:// reference
+func (l *configLoader) secret(key string) string {
+	value, err := resolveSecret(getEnv(key, ""))
+	if err != nil {
+		l.invalid(key, "", fmt.Errorf("%s: %w", key, err))
+		return ""
+	}
+	return value
+}
+
+func (l *configLoader) invalid(key string, defaultValue any, err error) {
+	l.errs = append(l.errs, err)
+	if !l.strict {
+		slog.Warn("Invalid config value, using default", "key", key, "default", defaultValue, "error", err)
+	}
+}
+
+func getEnv(key, defaultValue string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return defaultValue
+}
+
+func getDurationEnv(key string, defaultSeconds int) (time.Duration, error) {
+	if value := getEnv(key, ""); value != "" {
+		seconds, err := strconv.Atoi(value)
+		if err != nil {
+			return time.Duration(defaultSeconds), fmt.Errorf("%s: %q is not a whole number of seconds", key, value)
+		}
+		return time.Duration(seconds), nil
+	}
+	return time.Duration(defaultSeconds), nil
+}
+
+func getIntEnv(key string, defaultValue int) (int, error) {
+	if value := getEnv(key, ""); value != "" {
+		i, err := strconv.Atoi(value)
+		if err != nil {
+			return defaultValue, fmt.Errorf("%s: %q is not a whole number", key, value)
+		}
+		return i, nil
+	}
+	return defaultValue, nil
+}
+
+func getFloatEnv(key string, defaultValue float64) (float64, error) {
+	if value := getEnv(key, ""); value != "" {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return defaultValue, fmt.Errorf("%s: %q is not a number", key, value)
+		}
+		return f, nil
+	}
+	return defaultValue, nil
+}
+
+func getBoolEnv(key string, defaultValue bool) (bool, error) {
+	if value := getEnv(key, ""); value != "" {
+		b, ok := parseBool(value)
+		if !ok {
+			return defaultValue, fmt.Errorf("%s: %q is not a boolean", key, value)
+		}
+		return b, nil
+	}
+	return defaultValue, nil
+}
+
+// getListEnv splits a comma separated value, dropping blank entries
+func getListEnv(key string) []string {
+	return getListEnvDefault(key, "")
+}
+
+// getListEnvDefault is getListEnv with a comma separated default for when key is unset
+func getListEnvDefault(key, defaultValue string) []string {
+	var list []string
+	for item := range strings.SplitSeq(getEnv(key, defaultValue), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// parseBool is a more forgiving strconv.ParseBool that also accepts yes/no and on/off in any case
+func parseBool(value string) (bool, bool) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "1", "t", "true", "y", "yes", "on":
+		return true, true
+	case "0", "f", "false", "n", "no", "off":
+		return false, true
+	}
+	return false, false
+}
+
+func hostname() string {
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return name
+}
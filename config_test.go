@@ -0,0 +1,305 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestInvalidConfigFallsBackToDefaults(t *testing.T) {
+	t.Setenv("INACTIVITY_TIMEOUT", "ten")
+	t.Setenv("GITHUB_REMOVE_RUNNER", "sometimes")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Non-strict config should not fail: %v", err)
+	}
+	if cfg.InactivityTimeout != 90*time.Second {
+		t.Fatalf("Expected default timeout of 90s, got %s", cfg.InactivityTimeout)
+	}
+	if cfg.GitHubRemoveRunner {
+		t.Fatal("Expected GITHUB_REMOVE_RUNNER to fall back to false")
+	}
+}
+
+func TestStrictConfigFailsFast(t *testing.T) {
+	t.Setenv("STRICT_CONFIG", "true")
+	t.Setenv("INACTIVITY_TIMEOUT", "ten")
+	t.Setenv("GITHUB_REMOVE_RUNNER", "sometimes")
+
+	_, err := loadConfig()
+	if err == nil {
+		t.Fatal("Expected strict config to reject invalid values")
+	}
+	for _, key := range []string{"INACTIVITY_TIMEOUT", "GITHUB_REMOVE_RUNNER"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Fatalf("Expected error to mention %s, got %v", key, err)
+		}
+	}
+}
+
+func TestPublicPortKeepsPrivateListenerOnLoopback(t *testing.T) {
+	t.Setenv("PORT", "8808")
+	t.Setenv("PUBLIC_PORT", "8080")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("loadConfig: %v", err)
+	}
+	if cfg.PrivateAddress != "127.0.0.1:8808" {
+		t.Fatalf("Expected the private listener on loopback with PUBLIC_PORT, got %q", cfg.PrivateAddress)
+	}
+
+	t.Setenv("PRIVATE_ADDRESS", "10.0.0.2:9000")
+	if cfg, err = loadConfig(); err != nil || cfg.PrivateAddress != "10.0.0.2:9000" {
+		t.Fatalf("Expected PRIVATE_ADDRESS to be kept, got %q, %v", cfg.PrivateAddress, err)
+	}
+
+	t.Setenv("PUBLIC_PORT", "9000")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "PUBLIC_PORT") {
+		t.Fatalf("Expected PUBLIC_PORT on the private listener's port to be rejected, got %v", err)
+	}
+}
+
+func TestStrictConfigAcceptsValidValues(t *testing.T) {
+	t.Setenv("STRICT_CONFIG", "true")
+	t.Setenv("INACTIVITY_TIMEOUT", "120")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Valid strict config should load: %v", err)
+	}
+	if cfg.InactivityTimeout != 120*time.Second {
+		t.Fatalf("Expected 120s timeout, got %s", cfg.InactivityTimeout)
+	}
+}
+
+func TestGetListEnv(t *testing.T) {
+	t.Setenv("IGNORE_PING_USER_AGENTS", " GoogleHC, ,kube-probe,")
+	got := getListEnv("IGNORE_PING_USER_AGENTS")
+	if len(got) != 2 || got[0] != "GoogleHC" || got[1] != "kube-probe" {
+		t.Fatalf("Unexpected list: %q", got)
+	}
+}
+
+func TestReloadConfigRacesTimerAndHandlers(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	t.Setenv("GCP_PROJECT", "test-project")
+	t.Setenv("GCP_ZONE", "test-zone")
+	t.Setenv("GCP_INSTANCE_NAME", "test-instance")
+	t.Setenv("INACTIVITY_TIMEOUT", "1")
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Go(func() {
+			if err := reloadConfig(); err != nil {
+				t.Errorf("reloadConfig: %v", err)
+			}
+		})
+		wg.Go(func() { setInactivityTimeout(time.Minute) })
+		wg.Go(func() { setKeepOnline(false) })
+		wg.Go(initiateShutdown)
+		wg.Go(func() { _ = currentStatus() })
+		wg.Go(func() {
+			pingHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/ping", nil))
+		})
+	}
+	wg.Wait()
+
+	// The last writer wins, but every field has to come from a single consistent Config
+	cfg := config()
+	if cfg.GCEInstance != "test-instance" || cfg.AdminMaxBodyBytes != 4096 {
+		t.Fatalf("Unexpected config after reloads: %+v", cfg)
+	}
+}
+
+func TestReloadConfigKeepsCurrentOnError(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	before := config()
+	t.Setenv("STRICT_CONFIG", "true")
+	t.Setenv("INACTIVITY_TIMEOUT", "soon")
+
+	if err := reloadConfig(); err == nil {
+		t.Fatal("Expected an invalid strict config to fail to reload")
+	}
+	if config() != before {
+		t.Fatal("A failed reload should keep the current config")
+	}
+}
+
+func TestSecretManagerReferences(t *testing.T) {
+	origAccessSecret := accessSecret
+	var calls int
+	accessSecret = func(ctx context.Context, name string) (string, error) {
+		calls++
+		switch name {
+		case "projects/p/secrets/admin-token/versions/latest":
+			return "from-secret-manager\n", nil
+		default:
+			return "", errors.New("secret not found")
+		}
+	}
+	defer func() {
+		accessSecret = origAccessSecret
+		clear(secretCache)
+	}()
+
+	t.Setenv("ADMIN_TOKEN", "sm://projects/p/secrets/admin-token/versions/latest")
+	t.Setenv("WEBHOOK_SECRET", "sm://projects/p/secrets/missing/versions/latest")
+	t.Setenv("GITHUB_TOKEN", "plain-token")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Non-strict config should not fail: %v", err)
+	}
+	if cfg.AdminToken != "from-secret-manager" {
+		t.Fatalf("Expected ADMIN_TOKEN to be resolved, got %q", cfg.AdminToken)
+	}
+	if cfg.WebhookSecret != "" {
+		t.Fatalf("Expected an unresolvable secret to be left empty, got %q", cfg.WebhookSecret)
+	}
+	if cfg.GitHubToken != "plain-token" {
+		t.Fatalf("Expected plain values to be used as is, got %q", cfg.GitHubToken)
+	}
+
+	// Reloading uses the cached value
+	calls = 0
+	if _, err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 1 {
+		t.Fatalf("Expected only the missing secret to be fetched again, got %d calls", calls)
+	}
+
+	t.Setenv("STRICT_CONFIG", "true")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "WEBHOOK_SECRET") {
+		t.Fatalf("Expected strict config to reject the missing secret, got %v", err)
+	}
+}
+
+func TestInactivityTimeoutFloor(t *testing.T) {
+	t.Setenv("INACTIVITY_TIMEOUT", "0")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatalf("Non-strict config should not fail: %v", err)
+	}
+	if cfg.InactivityTimeout != 5*time.Second {
+		t.Fatalf("Expected the timeout to be raised to the 5s floor, got %s", cfg.InactivityTimeout)
+	}
+
+	t.Setenv("MIN_INACTIVITY_TIMEOUT", "30")
+	t.Setenv("INACTIVITY_TIMEOUT", "10")
+	t.Setenv("TIMEOUT_SCHEDULE", "mon-fri:10m,sat-sun:10s")
+	cfg, err = loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.InactivityTimeout != 30*time.Second || cfg.TimeoutSchedule != nil {
+		t.Fatalf("Expected the timeout raised to 30s and the schedule dropped, got %s / %v", cfg.InactivityTimeout, cfg.TimeoutSchedule)
+	}
+
+	t.Setenv("STRICT_CONFIG", "true")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "INACTIVITY_TIMEOUT") {
+		t.Fatalf("Expected strict config to reject a timeout below the floor, got %v", err)
+	}
+}
+
+func TestInstanceSelfLink(t *testing.T) {
+	t.Setenv("GCP_INSTANCE_SELF_LINK", "https://www.googleapis.com/compute/v1/projects/my-project/zones/us-central1-a/instances/runner-1")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.GoogleProjectID != "my-project" || cfg.GCEZone != "us-central1-a" || cfg.GCEInstance != "runner-1" {
+		t.Fatalf("Expected the self-link to be split up, got %s / %s / %s", cfg.GoogleProjectID, cfg.GCEZone, cfg.GCEInstance)
+	}
+
+	t.Setenv("GCP_INSTANCE_SELF_LINK", "projects/my-project/zones/us-central1-b/instances/runner-1")
+	t.Setenv("GCP_ZONE", "us-central1-a")
+	t.Setenv("STRICT_CONFIG", "true")
+	if _, err := loadConfig(); err == nil || !strings.Contains(err.Error(), "GCP_ZONE") {
+		t.Fatalf("Expected a mismatched zone to be rejected, got %v", err)
+	}
+
+	t.Setenv("GCP_ZONE", "")
+	t.Setenv("GCP_INSTANCE_SELF_LINK", "projects/my-project/instances/runner-1")
+	if _, err := loadConfig(); err == nil {
+		t.Fatal("Expected a self-link without a zone to be rejected")
+	}
+}
+
+func TestConfigReportRedactsSecrets(t *testing.T) {
+	cfg := setupTestConfig()
+	cfg.GitHubToken = "ghp_secret"
+	cfg.HeartbeatURL = "https://hc.example.com/ping/secret-uuid"
+
+	values := make(map[string]any)
+	attrs := configAttrs(cfg)
+	for _, attr := range attrs {
+		a := attr.(slog.Attr)
+		values[a.Key] = a.Value.Any()
+	}
+
+	if values["AdminToken"] != "[redacted]" || values["GitHubToken"] != "[redacted]" || values["HeartbeatURL"] != "[redacted]" {
+		t.Fatalf("Expected set secrets to be redacted, got %v", values)
+	}
+	if values["WebhookSecret"] != "" {
+		t.Fatalf("Expected an unset secret to be empty, got %v", values["WebhookSecret"])
+	}
+	if values["GCEInstance"] != "test-instance" || values["InactivityTimeout"] != 90*time.Second {
+		t.Fatalf("Expected plain fields to be reported as is, got %v", values)
+	}
+	if len(attrs) != reflect.TypeFor[Config]().NumField() {
+		t.Fatalf("Expected one attribute per config field, got %d", len(attrs))
+	}
+
+	if features := enabledFeatures(cfg); !slices.Contains(features, "admin_api") || !slices.Contains(features, "heartbeat") {
+		t.Fatalf("Expected admin_api and heartbeat to be enabled, got %v", features)
+	}
+}
+
+func TestDefaultProvider(t *testing.T) {
+	for _, key := range []string{"GCP_PROJECT", "GCP_ZONE", "GCP_INSTANCE_NAME", "GCP_INSTANCE_SELF_LINK", "LIBOPS_KEEP_ONLINE", "PROVIDER"} {
+		t.Setenv(key, "")
+	}
+
+	tests := []struct {
+		env  map[string]string
+		want string
+	}{
+		{nil, providerNoop},
+		{map[string]string{"GCP_ZONE": "us-central1-a"}, providerGCP},
+		{map[string]string{"LIBOPS_KEEP_ONLINE": "true"}, providerGCP},
+		{map[string]string{"PROVIDER": "gcp"}, providerGCP},
+		{map[string]string{"GCP_PROJECT": "p", "GCP_ZONE": "z", "GCP_INSTANCE_NAME": "i", "PROVIDER": "noop"}, providerNoop},
+	}
+
+	for _, tt := range tests {
+		for key, value := range tt.env {
+			t.Setenv(key, value)
+		}
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Provider != tt.want {
+			t.Fatalf("%v: expected provider %s, got %s", tt.env, tt.want, cfg.Provider)
+		}
+		for key := range tt.env {
+			t.Setenv(key, "")
+		}
+	}
+}
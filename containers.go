@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// dockerCommand is swapped out in tests for a script that records its arguments
+var dockerCommand = "docker"
+
+// stopContainers stops STOP_CONTAINERS before suspending so services like the GitHub runner can
+// shut down cleanly and deregister, instead of being frozen mid-flight
+// The caller bounds ctx, see preSuspendStages
+func stopContainers(ctx context.Context) error {
+	cfg := config()
+	if len(cfg.StopContainers) == 0 {
+		return nil
+	}
+
+	grace := int(cfg.StopContainersTimeout.Seconds())
+
+	slog.Info("Stopping containers before suspend", "containers", cfg.StopContainers, "timeout_seconds", grace)
+
+	args := append([]string{"stop", "--time", strconv.Itoa(grace)}, cfg.StopContainers...)
+	output, err := exec.CommandContext(ctx, dockerCommand, args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("docker stop failed: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useFakeDocker points dockerCommand at a script that writes its arguments to the returned file
+func useFakeDocker(t *testing.T, exitCode int) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	argsFile := filepath.Join(dir, "args")
+	script := filepath.Join(dir, "docker")
+	content := "#!/bin/sh\necho \"$@\" > " + argsFile + "\necho 'docker says hi'\nexit " + strconv.Itoa(exitCode) + "\n"
+	if err := os.WriteFile(script, []byte(content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	origDockerCommand := dockerCommand
+	dockerCommand = script
+	t.Cleanup(func() { dockerCommand = origDockerCommand })
+
+	return argsFile
+}
+
+func TestStopContainers(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	argsFile := useFakeDocker(t, 0)
+	updateConfig(func(cfg *Config) {
+		cfg.StopContainers = []string{"github-actions-runner", "worker"}
+		cfg.StopContainersTimeout = 20 * time.Second
+	})
+
+	if err := stopContainers(t.Context()); err != nil {
+		t.Fatalf("stopContainers: %v", err)
+	}
+
+	args, err := os.ReadFile(argsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.TrimSpace(string(args)); got != "stop --time 20 github-actions-runner worker" {
+		t.Fatalf("Unexpected docker arguments %q", got)
+	}
+}
+
+func TestStopContainersFailure(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	useFakeDocker(t, 1)
+	updateConfig(func(cfg *Config) {
+		cfg.StopContainers = []string{"github-actions-runner"}
+		cfg.StopContainersTimeout = time.Second
+	})
+
+	err := stopContainers(t.Context())
+	if err == nil || !strings.Contains(err.Error(), "docker says hi") {
+		t.Fatalf("Expected the docker output in the error, got %v", err)
+	}
+}
+
+func TestStopContainersDisabled(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	origDockerCommand := dockerCommand
+	dockerCommand = "/nonexistent/docker"
+	defer func() { dockerCommand = origDockerCommand }()
+
+	if err := stopContainers(t.Context()); err != nil {
+		t.Fatalf("Expected nothing to do without STOP_CONTAINERS, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// controlSettings are the values a CONTROL_FILE can override
+// Fields left nil weren't present in the file and are left alone
+type controlSettings struct {
+	InactivityTimeout *time.Duration
+	KeepOnline        *bool
+}
+
+// parseControlFile reads KEY=VALUE lines, using the same keys and formats as the environment
+func parseControlFile(path string) (controlSettings, error) {
+	cfg := config()
+
+	var settings controlSettings
+
+	f, err := os.Open(path)
+	if err != nil {
+		return settings, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return settings, fmt.Errorf("line %d: expected KEY=VALUE", lineNumber)
+		}
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		switch key {
+		case "INACTIVITY_TIMEOUT":
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds <= 0 {
+				return settings, fmt.Errorf("line %d: %s: %q is not a positive number of seconds", lineNumber, key, value)
+			}
+			timeout := time.Duration(seconds) * time.Second
+			if timeout < cfg.MinInactivityTimeout {
+				return settings, fmt.Errorf("line %d: %s: %s is below the minimum of %s", lineNumber, key, timeout, cfg.MinInactivityTimeout)
+			}
+			if timeout > cfg.MaxInactivityTimeout {
+				return settings, fmt.Errorf("line %d: %s: %s exceeds the maximum of %s", lineNumber, key, timeout, cfg.MaxInactivityTimeout)
+			}
+			settings.InactivityTimeout = &timeout
+		case "LIBOPS_KEEP_ONLINE":
+			enabled, ok := parseBool(value)
+			if !ok {
+				return settings, fmt.Errorf("line %d: %s: %q is not a boolean", lineNumber, key, value)
+			}
+			settings.KeepOnline = &enabled
+		default:
+			return settings, fmt.Errorf("line %d: unsupported key %s", lineNumber, key)
+		}
+	}
+
+	return settings, scanner.Err()
+}
+
+// applyControlFile reads CONTROL_FILE and applies any settings that changed
+func applyControlFile() error {
+	settings, err := parseControlFile(config().ControlFile)
+	if err != nil {
+		return err
+	}
+
+	if settings.KeepOnline != nil && *settings.KeepOnline != keepOnline() {
+		slog.Info("Keep online changed by control file", "keep_online", *settings.KeepOnline)
+		setKeepOnline(*settings.KeepOnline)
+	}
+	if settings.InactivityTimeout != nil && *settings.InactivityTimeout != inactivityTimeout() {
+		slog.Info("Inactivity timeout changed by control file", "timeout_seconds", int(settings.InactivityTimeout.Seconds()))
+		setInactivityTimeout(*settings.InactivityTimeout)
+	}
+
+	return nil
+}
+
+// watchControlFile applies CONTROL_FILE at startup and again whenever it changes, until ctx is cancelled
+func watchControlFile(ctx context.Context) {
+	cfg := config()
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		slog.Error("Failed to watch control file", "path", cfg.ControlFile, "error", err)
+		return
+	}
+	defer watcher.Close()
+
+	// Watch the directory rather than the file so editors and config management
+	// that replace the file via rename are picked up too
+	if err := watcher.Add(filepath.Dir(cfg.ControlFile)); err != nil {
+		slog.Error("Failed to watch control file", "path", cfg.ControlFile, "error", err)
+		return
+	}
+
+	slog.Info("Watching control file", "path", cfg.ControlFile)
+	if err := applyControlFile(); err != nil && !os.IsNotExist(err) {
+		slog.Error("Failed to apply control file", "path", cfg.ControlFile, "error", err)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != filepath.Clean(cfg.ControlFile) || !(event.Has(fsnotify.Write) || event.Has(fsnotify.Create)) {
+				continue
+			}
+			if err := applyControlFile(); err != nil {
+				slog.Error("Failed to apply control file", "path", cfg.ControlFile, "error", err)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Warn("Control file watcher error", "error", err)
+		}
+	}
+}
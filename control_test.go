@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseControlFile(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	path := filepath.Join(t.TempDir(), "control")
+	if err := os.WriteFile(path, []byte("# managed by sidecar\nINACTIVITY_TIMEOUT=600\n\nLIBOPS_KEEP_ONLINE = yes\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	settings, err := parseControlFile(path)
+	if err != nil {
+		t.Fatalf("parseControlFile: %v", err)
+	}
+	if settings.InactivityTimeout == nil || *settings.InactivityTimeout != 10*time.Minute {
+		t.Fatalf("Expected a 10m timeout, got %v", settings.InactivityTimeout)
+	}
+	if settings.KeepOnline == nil || !*settings.KeepOnline {
+		t.Fatalf("Expected keep online, got %v", settings.KeepOnline)
+	}
+
+	for _, invalid := range []string{"INACTIVITY_TIMEOUT=0", "INACTIVITY_TIMEOUT=7200", "LIBOPS_KEEP_ONLINE=maybe", "PORT=9000", "garbage"} {
+		if err := os.WriteFile(path, []byte(invalid+"\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parseControlFile(path); err == nil {
+			t.Fatalf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestWatchControlFileAppliesChanges(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) { cfg.ControlFile = filepath.Join(t.TempDir(), "control") })
+	if err := os.WriteFile(config().ControlFile, []byte("INACTIVITY_TIMEOUT=120\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchControlFile(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	waitFor := func(desc string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("Timed out waiting for %s", desc)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	waitFor("initial timeout", func() bool { return inactivityTimeout() == 2*time.Minute })
+
+	if err := os.WriteFile(config().ControlFile, []byte("INACTIVITY_TIMEOUT=300\nLIBOPS_KEEP_ONLINE=true\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("updated timeout", func() bool { return inactivityTimeout() == 5*time.Minute })
+	waitFor("keep online", keepOnline)
+
+	shutdownMutex.Lock()
+	timerRunning := shutdownTimer != nil
+	shutdownMutex.Unlock()
+	if timerRunning {
+		t.Fatal("Shutdown timer should be stopped while kept online")
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// defaultTokenExpiryWarning is TOKEN_EXPIRY_WARNING's default, GCE hands out tokens with at least 5 minutes left
+const defaultTokenExpiryWarning = 2 * time.Minute
+
+var (
+	tokenRefreshes = &counter{
+		name: "lightsout_token_refreshes_total",
+		help: "GCP access tokens fetched because the cached one was about to expire.",
+	}
+	tokenRefreshFailures = &counter{
+		name: "lightsout_token_refresh_failures_total",
+		help: "GCP access token refreshes that failed.",
+	}
+
+	tokenExpiryMu sync.Mutex
+	// tokenExpiry is when the most recently fetched access token expires
+	tokenExpiry time.Time
+)
+
+// watchedTokenSource sits under the token cache, so it only sees the refreshes
+// It counts them and warns when a fresh token is already close to expiring, the odd auth states a long suspend can leave behind
+type watchedTokenSource struct {
+	source oauth2.TokenSource
+}
+
+func (s watchedTokenSource) Token() (*oauth2.Token, error) {
+	token, err := s.source.Token()
+	if err != nil {
+		tokenRefreshFailures.inc()
+		slog.Warn("Failed to refresh GCP access token", "error", err)
+		return nil, err
+	}
+
+	tokenRefreshes.inc()
+	if !token.Expiry.IsZero() {
+		tokenExpiryMu.Lock()
+		tokenExpiry = token.Expiry
+		tokenExpiryMu.Unlock()
+
+		// Secrets are fetched while the config is still being loaded
+		window := defaultTokenExpiryWarning
+		if cfg := config(); cfg != nil {
+			window = cfg.TokenExpiryWarning
+		}
+
+		remaining := time.Until(token.Expiry)
+		if remaining < window {
+			slog.Warn("Fresh GCP access token is close to expiring, API calls may soon fail with 401",
+				"expires_in_seconds", int(remaining.Seconds()),
+				"warning_seconds", int(window.Seconds()))
+		} else {
+			slog.Debug("Refreshed GCP access token", "expires_in_seconds", int(remaining.Seconds()))
+		}
+	}
+
+	return token, nil
+}
+
+// findCredentials returns Application Default Credentials whose token refreshes are watched
+func findCredentials(ctx context.Context, scopes ...string) (*google.Credentials, error) {
+	creds, err := google.FindDefaultCredentials(ctx, scopes...)
+	if err != nil {
+		return nil, err
+	}
+
+	creds.TokenSource = oauth2.ReuseTokenSource(nil, watchedTokenSource{source: creds.TokenSource})
+	return creds, nil
+}
+
+func tokenExpiryTimestamp() float64 {
+	tokenExpiryMu.Lock()
+	defer tokenExpiryMu.Unlock()
+
+	if tokenExpiry.IsZero() {
+		return 0
+	}
+	return float64(tokenExpiry.Unix())
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+type tokenSourceFunc func() (*oauth2.Token, error)
+
+func (f tokenSourceFunc) Token() (*oauth2.Token, error) {
+	return f()
+}
+
+func TestWatchedTokenSourceCountsRefreshes(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	expiry := time.Now().Add(time.Hour)
+	fetched := 0
+	source := oauth2.ReuseTokenSource(nil, watchedTokenSource{source: tokenSourceFunc(func() (*oauth2.Token, error) {
+		fetched++
+		return &oauth2.Token{AccessToken: "token", Expiry: expiry}, nil
+	})})
+	before := tokenRefreshes.value.Load()
+
+	for range 3 {
+		if _, err := source.Token(); err != nil {
+			t.Fatalf("Token: %v", err)
+		}
+	}
+
+	if fetched != 1 {
+		t.Fatalf("Expected the cached token to be reused, fetched %d times", fetched)
+	}
+	if got := tokenRefreshes.value.Load() - before; got != 1 {
+		t.Fatalf("Expected 1 refresh counted, got %d", got)
+	}
+	if got := tokenExpiryTimestamp(); got != float64(expiry.Unix()) {
+		t.Fatalf("Expected token expiry %d, got %v", expiry.Unix(), got)
+	}
+}
+
+func TestWatchedTokenSourceCountsFailures(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	source := watchedTokenSource{source: tokenSourceFunc(func() (*oauth2.Token, error) {
+		return nil, errors.New("metadata server unavailable")
+	})}
+	before := tokenRefreshFailures.value.Load()
+
+	if _, err := source.Token(); err == nil {
+		t.Fatal("Expected the refresh error to be returned")
+	}
+	if got := tokenRefreshFailures.value.Load() - before; got != 1 {
+		t.Fatalf("Expected 1 failure counted, got %d", got)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// version is set at build time with -ldflags "-X main.version=...", otherwise it comes from the build info
+var version string
+
+// buildVersion returns the version of this binary, falling back to the VCS revision go build recorded
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+	return info.Main.Version
+}
+
+// debugBundle is everything needed to triage a "machine won't suspend" report in one document
+type debugBundle struct {
+	GeneratedAt time.Time      `json:"generated_at"`
+	Version     string         `json:"version"`
+	GoVersion   string         `json:"go_version"`
+	Status      statusResponse `json:"status"`
+	Config      map[string]any `json:"config"`
+	Features    []string       `json:"features"`
+	Sources     []sourceStatus `json:"sources"`
+	Decisions   []decision     `json:"decisions"`
+	Metrics     []string       `json:"metrics"`
+}
+
+// debugBundleHandler returns a debug bundle for attaching to bug reports
+// Secrets in the config are redacted the same way as in the startup log
+func debugBundleHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	cfg := config()
+	bundle := debugBundle{
+		GeneratedAt: time.Now(),
+		Version:     buildVersion(),
+		GoVersion:   runtime.Version(),
+		Status:      currentStatus(),
+		Config:      make(map[string]any),
+		Features:    enabledFeatures(cfg),
+		Sources:     currentSources(ctx),
+	}
+
+	reportConfig(cfg, func(name string, value any) {
+		// Durations read better as 5m0s than as nanoseconds
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+		bundle.Config[name] = value
+	})
+
+	tracker.mu.RLock()
+	bundle.Decisions = append([]decision(nil), tracker.decisions...)
+	tracker.mu.RUnlock()
+
+	var metrics strings.Builder
+	writeMetrics(&metrics)
+	bundle.Metrics = strings.Split(strings.TrimSuffix(metrics.String(), "\n"), "\n")
+
+	slog.Info("Debug bundle requested", "remote_addr", r.RemoteAddr)
+	w.Header().Set("Content-Disposition", `attachment; filename="lightsout-bundle.json"`)
+	writeJSON(w, http.StatusOK, bundle)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugBundle(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) { cfg.GitHubToken = "ghp_supersecret" })
+	recordDecision("skip", "recent activity")
+
+	w := httptest.NewRecorder()
+	requireAdmin(scopeDebug, debugBundleHandler)(w, adminRequest("GET", "/debug/bundle"))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "ghp_supersecret") || strings.Contains(w.Body.String(), "test-admin-token") {
+		t.Fatal("Debug bundle leaked a secret")
+	}
+
+	var bundle debugBundle
+	if err := json.NewDecoder(w.Body).Decode(&bundle); err != nil {
+		t.Fatalf("Failed to decode the bundle: %v", err)
+	}
+	if bundle.Config["GitHubToken"] != "[redacted]" || bundle.Config["GCEInstance"] != "test-instance" {
+		t.Fatalf("Unexpected config in the bundle: %v", bundle.Config)
+	}
+	if bundle.Config["MinInactivityTimeout"] != "5s" {
+		t.Fatalf("Expected durations to be readable, got %v", bundle.Config["MinInactivityTimeout"])
+	}
+	if len(bundle.Decisions) == 0 || bundle.Decisions[len(bundle.Decisions)-1].Reason != "recent activity" {
+		t.Fatalf("Expected the recent decisions, got %+v", bundle.Decisions)
+	}
+	if bundle.Version == "" || len(bundle.Metrics) == 0 || len(bundle.Sources) == 0 {
+		t.Fatalf("Expected version, metrics and sources, got %+v", bundle)
+	}
+}
+
+func TestDebugBundleRequiresAdmin(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	w := httptest.NewRecorder()
+	requireAdmin(scopeDebug, debugBundleHandler)(w, httptest.NewRequest("GET", "/debug/bundle", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401, got %d", w.Code)
+	}
+}
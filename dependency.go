@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+var dependencyClient = &http.Client{Timeout: 10 * time.Second}
+
+// dependencyStatus describes the result of the DEPENDENCY_HEALTH_URL check
+type dependencyStatus struct {
+	Healthy bool
+	Detail  string
+}
+
+// checkDependency asks DEPENDENCY_HEALTH_URL whether the workload's dependency is up
+// Any 2xx is healthy; other statuses and errors reaching it are not
+func checkDependency(ctx context.Context) dependencyStatus {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, config().DependencyHealthURL, nil)
+	if err != nil {
+		return dependencyStatus{Detail: err.Error()}
+	}
+
+	resp, err := dependencyClient.Do(req)
+	if err != nil {
+		return dependencyStatus{Detail: err.Error()}
+	}
+	defer resp.Body.Close()
+
+	return dependencyStatus{
+		Healthy: resp.StatusCode >= 200 && resp.StatusCode < 300,
+		Detail:  resp.Status,
+	}
+}
+
+// dependencyDown reports whether DEPENDENCY_HEALTH_URL is configured and says the dependency is unhealthy
+func dependencyDown() (bool, string) {
+	if config().DependencyHealthURL == "" {
+		return false, ""
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	status := checkDependency(ctx)
+	if !status.Healthy {
+		slog.Warn("Dependency is unhealthy", "url", config().DependencyHealthURL, "status", status.Detail)
+	}
+	return !status.Healthy, fmt.Sprintf("dependency %s", status.Detail)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// useFakeDependency answers DEPENDENCY_HEALTH_URL with status without going through the network
+func useFakeDependency(t *testing.T, status int) {
+	t.Helper()
+
+	origClient := dependencyClient
+	dependencyClient = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		return &http.Response{
+			StatusCode: status,
+			Status:     http.StatusText(status),
+			Body:       io.NopCloser(strings.NewReader("")),
+			Request:    r,
+		}, nil
+	})}
+	t.Cleanup(func() { dependencyClient = origClient })
+
+	updateConfig(func(cfg *Config) { cfg.DependencyHealthURL = "http://db.internal/health" })
+}
+
+func TestDependencyDownSkipsArmedTimeout(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		useFakeDependency(t, http.StatusServiceUnavailable)
+		updateConfig(func(cfg *Config) { cfg.ArmedTimeout = 10 * time.Minute })
+		resetShutdownTimer()
+
+		time.Sleep(config().InactivityTimeout + time.Second)
+		if !mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should not wait for the armed timeout while the dependency is down")
+		}
+
+		tracker.mu.RLock()
+		last := tracker.decisions[len(tracker.decisions)-1]
+		tracker.mu.RUnlock()
+		if !strings.Contains(last.Reason, "Service Unavailable") {
+			t.Fatalf("Expected the dependency status in the decision, got %q", last.Reason)
+		}
+	})
+}
+
+func TestHealthyDependencyKeepsArmedTimeout(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		useFakeDependency(t, http.StatusOK)
+		updateConfig(func(cfg *Config) { cfg.ArmedTimeout = 10 * time.Minute })
+		resetShutdownTimer()
+
+		time.Sleep(config().InactivityTimeout + time.Second)
+		if mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should wait for the armed timeout while the dependency is healthy")
+		}
+		if currentStatus().ArmedAt == nil {
+			t.Fatal("Expected the shutdown to be armed")
+		}
+	})
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+)
+
+var (
+	storageServiceMu     sync.Mutex
+	cachedStorageService *storage.Service
+	// newStorageService is swapped out in tests to point at a fake storage API
+	newStorageService = createStorageService
+)
+
+func createStorageService(ctx context.Context) (*storage.Service, error) {
+	// Same Application Default Credentials as the compute service, SUSPEND_LOCK needs to write its lock objects
+	scope := storage.DevstorageReadOnlyScope
+	if config().SuspendLock != "" {
+		scope = storage.DevstorageReadWriteScope
+	}
+	creds, err := findCredentials(ctx, scope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find default credentials: %w", err)
+	}
+
+	service, err := storage.NewService(ctx, option.WithCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create storage service: %w", err)
+	}
+
+	return service, nil
+}
+
+// getStorageService returns the shared storage service, creating it on first use
+func getStorageService(ctx context.Context) (*storage.Service, error) {
+	storageServiceMu.Lock()
+	defer storageServiceMu.Unlock()
+
+	if cachedStorageService != nil {
+		return cachedStorageService, nil
+	}
+
+	service, err := newStorageService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cachedStorageService = service
+
+	return service, nil
+}
+
+// deployLocked reports whether DEPLOY_LOCK, a gs://bucket/object or a local path, currently exists
+func deployLocked(ctx context.Context) (bool, error) {
+	lock := config().DeployLock
+
+	path, ok := strings.CutPrefix(lock, "gs://")
+	if !ok {
+		_, err := os.Stat(lock)
+		if errors.Is(err, fs.ErrNotExist) {
+			return false, nil
+		}
+		return err == nil, err
+	}
+
+	bucket, object, ok := strings.Cut(path, "/")
+	if !ok || bucket == "" || object == "" {
+		return false, fmt.Errorf("deploy lock %q should look like gs://bucket/object", lock)
+	}
+
+	service, err := getStorageService(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	_, err = service.Objects.Get(bucket, object).Context(ctx).Do()
+	if isNotFoundError(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// deployLockActivity treats DEPLOY_LOCK existing as activity happening right now
+// If we can't tell whether it exists we assume a deploy is running, suspending mid-deploy is worse than staying up a bit longer
+func deployLockActivity(ctx context.Context) (time.Time, error) {
+	locked, err := deployLocked(ctx)
+	if err != nil {
+		slog.Warn("Could not check the deploy lock, assuming it is held", "lock", config().DeployLock, "error", err)
+		return time.Now(), nil
+	}
+	if locked {
+		return time.Now(), nil
+	}
+	return time.Time{}, nil
+}
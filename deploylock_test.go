@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/api/option"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// useFakeStorageAPI points the storage service at handler
+func useFakeStorageAPI(t *testing.T, handler http.Handler) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	origNewStorageService := newStorageService
+	newStorageService = func(ctx context.Context) (*storage.Service, error) {
+		return storage.NewService(ctx,
+			option.WithEndpoint(server.URL+"/storage/v1/"),
+			option.WithoutAuthentication())
+	}
+	cachedStorageService = nil
+
+	t.Cleanup(func() {
+		newStorageService = origNewStorageService
+		cachedStorageService = nil
+	})
+}
+
+func TestDeployLockFile(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) { cfg.DeployLock = filepath.Join(t.TempDir(), "deploy.lock") })
+
+	if lastActivity, err := deployLockActivity(context.Background()); err != nil || !lastActivity.IsZero() {
+		t.Fatalf("Expected no activity without the lock, got %v / %v", lastActivity, err)
+	}
+
+	if err := os.WriteFile(config().DeployLock, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if lastActivity, err := deployLockActivity(context.Background()); err != nil || lastActivity.IsZero() {
+		t.Fatalf("Expected activity while the lock exists, got %v / %v", lastActivity, err)
+	}
+}
+
+func TestDeployLockGCSObject(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	var status atomic.Int32
+	useFakeStorageAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/b/deploys/o/web/lock") && !strings.HasSuffix(r.URL.RawPath, "/b/deploys/o/web%2Flock") {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		if code := int(status.Load()); code != http.StatusOK {
+			w.WriteHeader(code)
+			return
+		}
+		writeComputeJSON(w, storage.Object{Bucket: "deploys", Name: "web/lock"})
+	}))
+	updateConfig(func(cfg *Config) { cfg.DeployLock = "gs://deploys/web/lock" })
+
+	tests := []struct {
+		status int
+		active bool
+	}{
+		{http.StatusOK, true},
+		{http.StatusNotFound, false},
+		// Errors count as locked to be safe
+		{http.StatusForbidden, true},
+	}
+
+	for _, tt := range tests {
+		status.Store(int32(tt.status))
+		lastActivity, err := deployLockActivity(context.Background())
+		if err != nil || lastActivity.IsZero() == tt.active {
+			t.Fatalf("Status %d: expected active=%t, got %v / %v", tt.status, tt.active, lastActivity, err)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultSourceDisable is how long POST /sources/{name}/disable lasts without a duration
+	defaultSourceDisable = 10 * time.Minute
+	// maxSourceDisable keeps a forgotten disable from outliving the debugging session it was for
+	maxSourceDisable = 24 * time.Hour
+)
+
+// disabledSource is an activity source left out of the suspend decision until Until
+type disabledSource struct {
+	Name  string    `json:"name"`
+	Until time.Time `json:"until"`
+}
+
+var (
+	disabledSourcesMu sync.Mutex
+	// disabledSources holds the timer that re-enables each disabled source
+	disabledSources = map[string]*sourceDisable{}
+)
+
+type sourceDisable struct {
+	until time.Time
+	timer *time.Timer
+}
+
+// disableSource leaves name out of the suspend decision for duration, replacing any earlier disable of it
+func disableSource(name string, duration time.Duration) time.Time {
+	disabledSourcesMu.Lock()
+	defer disabledSourcesMu.Unlock()
+
+	if previous := disabledSources[name]; previous != nil {
+		previous.timer.Stop()
+	}
+	until := time.Now().Add(duration)
+	disabled := &sourceDisable{until: until}
+	disabled.timer = time.AfterFunc(duration, func() {
+		disabledSourcesMu.Lock()
+		current := disabledSources[name] == disabled
+		if current {
+			delete(disabledSources, name)
+		}
+		disabledSourcesMu.Unlock()
+
+		if current {
+			slog.Info("Activity source re-enabled", "source", name)
+		}
+	})
+	disabledSources[name] = disabled
+	return until
+}
+
+// enableSource ends a disable of name early, reporting whether it was disabled
+func enableSource(name string) bool {
+	disabledSourcesMu.Lock()
+	defer disabledSourcesMu.Unlock()
+
+	disabled := disabledSources[name]
+	if disabled == nil {
+		return false
+	}
+	disabled.timer.Stop()
+	delete(disabledSources, name)
+	return true
+}
+
+// sourceDisabledUntil returns when name is re-enabled, or the zero time if it isn't disabled
+func sourceDisabledUntil(name string) time.Time {
+	disabledSourcesMu.Lock()
+	defer disabledSourcesMu.Unlock()
+
+	if disabled := disabledSources[name]; disabled != nil && time.Now().Before(disabled.until) {
+		return disabled.until
+	}
+	return time.Time{}
+}
+
+// currentDisabledSources lists the disabled sources, by name
+func currentDisabledSources() []disabledSource {
+	disabledSourcesMu.Lock()
+	defer disabledSourcesMu.Unlock()
+
+	var sources []disabledSource
+	for name, disabled := range disabledSources {
+		sources = append(sources, disabledSource{Name: name, Until: disabled.until})
+	}
+	slices.SortFunc(sources, func(a, b disabledSource) int { return strings.Compare(a.Name, b.Name) })
+	return sources
+}
+
+// resetDisabledSources re-enables every source
+func resetDisabledSources() {
+	disabledSourcesMu.Lock()
+	defer disabledSourcesMu.Unlock()
+
+	for name, disabled := range disabledSources {
+		disabled.timer.Stop()
+		delete(disabledSources, name)
+	}
+}
+
+// knownSource reports whether name is one of the configured activity sources
+func knownSource(name string) bool {
+	return slices.ContainsFunc(activitySources, func(source ActivitySource) bool {
+		return source.Name() == name
+	})
+}
+
+// disableSourceHandler leaves an activity source out of the suspend decision for a while, e.g. to see whether it is
+// what keeps the machine online: POST /sources/github_actions/disable?duration=10m
+func disableSourceHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !knownSource(name) {
+		http.Error(w, "Unknown activity source "+name, http.StatusNotFound)
+		return
+	}
+
+	duration := defaultSourceDisable
+	if value := r.URL.Query().Get("duration"); value != "" {
+		var err error
+		duration, err = time.ParseDuration(value)
+		if err != nil {
+			http.Error(w, "Invalid duration: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if duration <= 0 || duration > maxSourceDisable {
+		http.Error(w, "Duration must be positive and at most "+maxSourceDisable.String(), http.StatusBadRequest)
+		return
+	}
+
+	until := disableSource(name, duration)
+	slog.Info("Activity source disabled",
+		"source", name,
+		"remote_addr", r.RemoteAddr,
+		"until", until)
+
+	writeJSON(w, http.StatusOK, disabledSource{Name: name, Until: until})
+}
+
+// enableSourceHandler ends a disable before its duration is up
+func enableSourceHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	if !knownSource(name) {
+		http.Error(w, "Unknown activity source "+name, http.StatusNotFound)
+		return
+	}
+
+	if enableSource(name) {
+		slog.Info("Activity source re-enabled", "source", name, "remote_addr", r.RemoteAddr)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"name": name, "disabled": false})
+}
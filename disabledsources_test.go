@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestDisableSource(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		activitySources = []ActivitySource{sourceFunc{name: "gpu", fn: func(context.Context) (time.Time, error) {
+			return time.Now(), nil
+		}}}
+		if _, _, ok := activeSource(t.Context(), time.Now()); !ok {
+			t.Fatal("Expected the busy source to keep the machine online")
+		}
+
+		w := httptest.NewRecorder()
+		req := adminRequest("POST", "/sources/gpu/disable?duration=10m")
+		req.SetPathValue("name", "gpu")
+		requireAdmin(scopeSources, disableSourceHandler)(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body)
+		}
+
+		if source, _, ok := activeSource(t.Context(), time.Now()); ok {
+			t.Fatalf("Expected the disabled source to be left out, got %s", source)
+		}
+		sources := currentSources(t.Context())
+		if gpu := sources[len(sources)-1]; gpu.DisabledUntil == nil || !gpu.Active {
+			t.Fatalf("Expected /sources to show the source disabled but still seeing activity, got %+v", gpu)
+		}
+		if disabled := currentStatus().DisabledSources; len(disabled) != 1 || disabled[0].Name != "gpu" {
+			t.Fatalf("Expected /status to list the disabled source, got %+v", disabled)
+		}
+
+		// It comes back on its own
+		time.Sleep(10*time.Minute + time.Second)
+		synctest.Wait()
+		if _, _, ok := activeSource(t.Context(), time.Now()); !ok {
+			t.Fatal("Expected the source to be re-enabled after the duration")
+		}
+		if disabled := currentDisabledSources(); len(disabled) != 0 {
+			t.Fatalf("Expected nothing disabled, got %+v", disabled)
+		}
+	})
+}
+
+func TestDisableSourceValidation(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	activitySources = []ActivitySource{sourceFunc{name: "gpu", fn: func(context.Context) (time.Time, error) {
+		return time.Time{}, nil
+	}}}
+
+	tests := []struct {
+		name     string
+		target   string
+		expected int
+	}{
+		{"gpu", "/sources/gpu/disable", http.StatusOK},
+		{"nope", "/sources/nope/disable", http.StatusNotFound},
+		{"gpu", "/sources/gpu/disable?duration=soon", http.StatusBadRequest},
+		{"gpu", "/sources/gpu/disable?duration=-1m", http.StatusBadRequest},
+		{"gpu", "/sources/gpu/disable?duration=48h", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		req := adminRequest("POST", tt.target)
+		req.SetPathValue("name", tt.name)
+		requireAdmin(scopeSources, disableSourceHandler)(w, req)
+		if w.Code != tt.expected {
+			t.Errorf("%s: expected %d, got %d", tt.target, tt.expected, w.Code)
+		}
+	}
+
+	var resp disabledSource
+	w := httptest.NewRecorder()
+	req := adminRequest("POST", "/sources/gpu/disable")
+	req.SetPathValue("name", "gpu")
+	requireAdmin(scopeSources, disableSourceHandler)(w, req)
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if remaining := time.Until(resp.Until); remaining < 9*time.Minute || remaining > defaultSourceDisable {
+		t.Fatalf("Expected the default duration, got %v", remaining)
+	}
+
+	req = adminRequest("POST", "/sources/gpu/enable")
+	req.SetPathValue("name", "gpu")
+	requireAdmin(scopeSources, enableSourceHandler)(httptest.NewRecorder(), req)
+	if !sourceDisabledUntil("gpu").IsZero() {
+		t.Fatal("Expected the source to be re-enabled early")
+	}
+}
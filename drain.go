@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// draining is set while POST /shutdown waits for in-flight work, it keeps the inactivity timer from restarting
+	draining atomic.Bool
+
+	// drainPollInterval is how often a drain re-checks for in-flight work
+	drainPollInterval = 5 * time.Second
+)
+
+// drainResult is the summary POST /shutdown returns once the drain is over
+type drainResult struct {
+	WaitedSeconds int      `json:"waited_seconds"`
+	WaitedFor     []string `json:"waited_for"`
+	TimedOut      bool     `json:"timed_out"`
+	Outcome       string   `json:"outcome"`
+	Error         string   `json:"error,omitempty"`
+}
+
+// inFlightWork returns the activity sources that are busy right now, and the GitHub runner when it is idle
+func inFlightWork(ctx context.Context) ([]string, *githubRunner) {
+	var busy []string
+	now := time.Now()
+
+	for _, source := range activitySources {
+		lastActivity, err := source.LastActivity(ctx)
+		if err != nil {
+			slog.Debug("Could not check activity source", "source", source.Name(), "error", err)
+			continue
+		}
+		if now.Sub(lastActivity) < drainPollInterval {
+			busy = append(busy, source.Name())
+		}
+	}
+
+	var runner *githubRunner
+	if config().GitHubToken != "" {
+		var err error
+		runner, err = checkGitHubRunnerIdle(ctx)
+		if errors.Is(err, errRunnerBusy) {
+			busy = append(busy, "github_runner")
+			runner = nil
+		} else if err != nil {
+			slog.Warn("Could not determine GitHub runner state", "error", err)
+		}
+	}
+
+	return busy, runner
+}
+
+// drain waits until nothing is in flight or DRAIN_TIMEOUT passes
+// waitedFor lists every source that held the drain up at some point
+func drain(ctx context.Context) (waitedFor []string, runner *githubRunner, timedOut bool) {
+	deadline := time.Now().Add(config().DrainTimeout)
+	waitedFor = []string{}
+
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		busy, idleRunner := inFlightWork(checkCtx)
+		cancel()
+
+		if len(busy) == 0 {
+			return waitedFor, idleRunner, false
+		}
+		for _, name := range busy {
+			if !slices.Contains(waitedFor, name) {
+				waitedFor = append(waitedFor, name)
+			}
+		}
+		if time.Now().After(deadline) {
+			return waitedFor, nil, true
+		}
+
+		slog.Info("Waiting for in-flight work before shutting down", "busy", busy)
+		select {
+		case <-ctx.Done():
+			return waitedFor, nil, true
+		case <-time.After(drainPollInterval):
+		}
+	}
+}
+
+// shutdownHandler stops timer resets, waits for in-flight work to finish and then suspends
+// Unlike POST /suspend it only responds once the suspend has been attempted
+func shutdownHandler(w http.ResponseWriter, r *http.Request) {
+	if !draining.CompareAndSwap(false, true) {
+		http.Error(w, "A shutdown is already draining", http.StatusConflict)
+		return
+	}
+
+	// The drain can easily outlast the server's write timeout
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(config().DrainTimeout + suspendTimeout + time.Minute))
+
+	slog.Info("Graceful shutdown requested, draining", "remote_addr", r.RemoteAddr, "drain_timeout", config().DrainTimeout)
+	stopShutdownTimer()
+	start := time.Now()
+
+	// Finish the drain even if the client goes away, by then we've already promised to suspend
+	waitedFor, runner, timedOut := drain(context.WithoutCancel(r.Context()))
+	result := drainResult{
+		WaitedSeconds: int(time.Since(start).Seconds()),
+		WaitedFor:     waitedFor,
+		TimedOut:      timedOut,
+	}
+
+	reason := "graceful shutdown"
+	if timedOut {
+		reason = "graceful shutdown (drain timed out)"
+		slog.Warn("Drain timed out, suspending anyway", "waited_for", waitedFor)
+	}
+
+	err := requestSuspend(reason, runner)
+	if errors.Is(err, errNoopProvider) || errors.Is(err, errReportOnly) {
+		// Nothing to suspend, go back to watching for inactivity
+		result.Outcome = "noop"
+		if errors.Is(err, errReportOnly) {
+			result.Outcome = "report_only"
+		}
+		draining.Store(false)
+		if !keepOnline() {
+			resetShutdownTimer()
+		}
+		writeJSON(w, http.StatusOK, result)
+		return
+	} else if err != nil {
+		result.Outcome = "failed"
+		result.Error = err.Error()
+
+		// We're staying up, go back to watching for inactivity
+		draining.Store(false)
+		if !keepOnline() {
+			resetShutdownTimer()
+		}
+		writeJSON(w, http.StatusInternalServerError, result)
+		return
+	}
+
+	result.Outcome = "suspended"
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestShutdownWaitsForInFlightWork(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		updateConfig(func(cfg *Config) { cfg.DrainTimeout = 10 * time.Minute })
+		busyUntil := time.Now().Add(time.Minute)
+		activitySources = []ActivitySource{sourceFunc{name: "queue", fn: func(context.Context) (time.Time, error) {
+			if time.Now().Before(busyUntil) {
+				return time.Now(), nil
+			}
+			return time.Time{}, nil
+		}}}
+		resetShutdownTimer()
+
+		w := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			requireAdmin(scopeShutdown, shutdownHandler)(w, adminRequest("POST", "/shutdown"))
+			close(done)
+		}()
+
+		synctest.Wait()
+		if !currentStatus().Draining {
+			t.Fatal("Expected /status to report the drain")
+		}
+
+		// Pings during the drain must not bring the inactivity timer back
+		pingHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/ping", nil))
+		shutdownMutex.Lock()
+		timerRunning := shutdownTimer != nil
+		shutdownMutex.Unlock()
+		if timerRunning {
+			t.Fatal("The inactivity timer should stay stopped while draining")
+		}
+
+		time.Sleep(30 * time.Second)
+		if mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should wait for the queue to drain")
+		}
+
+		<-done
+		if !mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should happen once the queue drained")
+		}
+
+		var result drainResult
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if w.Code != http.StatusOK || result.Outcome != "suspended" || result.TimedOut ||
+			len(result.WaitedFor) != 1 || result.WaitedFor[0] != "queue" || result.WaitedSeconds < 60 {
+			t.Fatalf("Unexpected result %d %+v", w.Code, result)
+		}
+	})
+}
+
+func TestShutdownDrainTimesOut(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		updateConfig(func(cfg *Config) { cfg.DrainTimeout = time.Minute })
+		activitySources = []ActivitySource{sourceFunc{name: "gpu", fn: func(context.Context) (time.Time, error) {
+			return time.Now(), nil
+		}}}
+
+		w := httptest.NewRecorder()
+		requireAdmin(scopeShutdown, shutdownHandler)(w, adminRequest("POST", "/shutdown"))
+
+		var result drainResult
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if !result.TimedOut || result.Outcome != "suspended" || !mockGCP.WasSuspendCalled() {
+			t.Fatalf("Expected the drain to time out and suspend anyway, got %+v", result)
+		}
+	})
+}
+
+func TestShutdownFailedSuspendResumesTimer(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+		defer exitCode.Store(exitOK)
+
+		suspendFunc = func(context.Context) error { return errors.New("failed to suspend instance: 500") }
+
+		w := httptest.NewRecorder()
+		requireAdmin(scopeShutdown, shutdownHandler)(w, adminRequest("POST", "/shutdown"))
+
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected status 500, got %d", w.Code)
+		}
+		if currentStatus().Draining {
+			t.Fatal("A failed shutdown should stop draining")
+		}
+		shutdownMutex.Lock()
+		timerRunning := shutdownTimer != nil
+		shutdownMutex.Unlock()
+		if !timerRunning {
+			t.Fatal("A failed shutdown should go back to watching for inactivity")
+		}
+	})
+}
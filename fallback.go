@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+// fallbackStopPermission is what FALLBACK_TO_STOP needs on an instance on top of requiredSuspendPermissions
+const fallbackStopPermission = "compute.instances.stop"
+
+var suspendFallbackStops = &counter{
+	name: "lightsout_suspend_fallback_stops_total",
+	help: "Instances stopped instead of suspended because their machine type doesn't support suspend.",
+}
+
+// isSuspendUnsupported reports whether err is the API refusing to suspend this kind of instance,
+// e.g. one with GPUs, local SSDs or a machine type that can't be suspended
+func isSuspendUnsupported(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Code != http.StatusBadRequest {
+		return false
+	}
+	for _, item := range apiErr.Errors {
+		if item.Reason == "unsupportedOperation" {
+			return true
+		}
+	}
+	message := strings.ToLower(apiErr.Message)
+	return strings.Contains(message, "suspend") &&
+		(strings.Contains(message, "not supported") || strings.Contains(message, "unsupported"))
+}
+
+// suspendOrStop suspends the instance, or with FALLBACK_TO_STOP stops it when the API says it can't be suspended
+// It reports whether the instance was stopped rather than suspended
+func suspendOrStop(ctx context.Context, service *compute.Service, instance managedInstance) (*compute.Operation, bool, error) {
+	operation, err := service.Instances.Suspend(instance.Project, instance.Zone, instance.Name).Context(ctx).Do()
+	if err == nil || !config().FallbackToStop || !isSuspendUnsupported(err) {
+		return operation, false, err
+	}
+
+	// A stopped instance loses its memory, but that beats it running forever
+	slog.Warn("Suspend isn't supported for this instance, stopping it instead",
+		"instance", instance.String(),
+		"error", err)
+	suspendFallbackStops.inc()
+	recordDecision("stop", "suspend unsupported for "+instance.Name+", falling back to stop")
+
+	operation, err = service.Instances.Stop(instance.Project, instance.Zone, instance.Name).Context(ctx).Do()
+	return operation, true, err
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+)
+
+func TestIsSuspendUnsupported(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"machine type", &googleapi.Error{Code: 400, Message: "Suspend is not supported for machine type a2-highgpu-1g."}, true},
+		{"reason", &googleapi.Error{Code: 400, Errors: []googleapi.ErrorItem{{Reason: "unsupportedOperation"}}}, true},
+		{"other bad request", &googleapi.Error{Code: 400, Message: "Invalid value for field 'zone'"}, false},
+		{"quota", &googleapi.Error{Code: 403, Message: "Quota exceeded"}, false},
+		{"not an API error", errors.New("suspend not supported"), false},
+	}
+	for _, tt := range tests {
+		if got := isSuspendUnsupported(tt.err); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+// useUnsupportedSuspendAPI serves a RUNNING instance that can't be suspended and counts the stops
+func useUnsupportedSuspendAPI(t *testing.T) *atomic.Int32 {
+	t.Helper()
+
+	var stops atomic.Int32
+	useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/instances/test-instance"):
+			writeComputeJSON(w, compute.Instance{Name: "test-instance", Status: "RUNNING"})
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/instances/test-instance/suspend"):
+			w.WriteHeader(http.StatusBadRequest)
+			writeComputeJSON(w, map[string]any{"error": map[string]any{
+				"code":    400,
+				"message": "Suspend is not supported for instances with attached GPUs.",
+			}})
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/instances/test-instance/stop"):
+			stops.Add(1)
+			writeComputeJSON(w, compute.Operation{Name: "op-stop", Status: "RUNNING"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	return &stops
+}
+
+func TestFallbackToStop(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	stops := useUnsupportedSuspendAPI(t)
+	updateConfig(func(cfg *Config) { cfg.FallbackToStop = true })
+	before := suspendFallbackStops.value.Load()
+
+	_, outcome, err := suspendMachine(t.Context())
+	if err != nil {
+		t.Fatalf("Expected the stop to stand in for the suspend, got %v", err)
+	}
+	if outcome != stopRequested || stops.Load() != 1 {
+		t.Fatalf("Expected one stop, got outcome %s and %d stops", outcome, stops.Load())
+	}
+	if got := suspendFallbackStops.value.Load() - before; got != 1 {
+		t.Fatalf("Expected the fallback to be counted once, got %d", got)
+	}
+}
+
+func TestNoFallbackToStopByDefault(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	stops := useUnsupportedSuspendAPI(t)
+
+	if _, _, err := suspendMachine(t.Context()); err == nil || !isSuspendUnsupported(err) {
+		t.Fatalf("Expected the unsupported suspend error, got %v", err)
+	}
+	if stops.Load() != 0 {
+		t.Fatal("Should not stop the instance without FALLBACK_TO_STOP")
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+var (
+	computeServiceMu     sync.Mutex
+	cachedComputeService *compute.Service
+	// newComputeService is swapped out in tests to point at a fake compute API
+	newComputeService = createComputeService
+
+	errInstanceNotFound = errors.New("instance not found")
+	errMissingGCPConfig = errors.New("missing GCP configuration")
+
+	// suspendTimeout bounds how long a suspend, including its retry, may take
+	suspendTimeout = 2 * time.Minute
+)
+
+func createComputeService(ctx context.Context) (*compute.Service, error) {
+	// Use Application Default Credentials (ADC)
+	// This will automatically use:
+	// 1. GOOGLE_APPLICATION_CREDENTIALS environment variable
+	// 2. GCE metadata server (when running on GCE)
+	// 3. gcloud CLI credentials
+	// The credentials' token source caches the access token and refreshes it before it expires
+	creds, err := findCredentials(ctx, compute.ComputeScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find default credentials: %w", err)
+	}
+
+	service, err := compute.NewService(ctx, option.WithCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create compute service: %w", err)
+	}
+
+	return service, nil
+}
+
+// getComputeService returns the shared compute service, creating it on first use
+func getComputeService(ctx context.Context) (*compute.Service, error) {
+	computeServiceMu.Lock()
+	defer computeServiceMu.Unlock()
+
+	if cachedComputeService != nil {
+		return cachedComputeService, nil
+	}
+
+	service, err := newComputeService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cachedComputeService = service
+
+	return service, nil
+}
+
+// resetComputeService drops the shared compute service so the next call starts with fresh credentials
+func resetComputeService() {
+	computeServiceMu.Lock()
+	defer computeServiceMu.Unlock()
+
+	cachedComputeService = nil
+}
+
+// isAuthError reports whether err means our credentials were rejected
+func isAuthError(err error) bool {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		return apiErr.Code == http.StatusUnauthorized
+	}
+
+	var retrieveErr *oauth2.RetrieveError
+	return errors.As(err, &retrieveErr)
+}
+
+func isNotFoundError(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound
+}
+
+// findInstanceZone looks for GCP_INSTANCE_NAME across every zone in the project, for when the instance was moved
+// It returns an empty string if the instance isn't found in another zone or the search fails
+func findInstanceZone(ctx context.Context, service *compute.Service) string {
+	cfg := config()
+
+	var zone string
+	err := service.Instances.AggregatedList(cfg.GoogleProjectID).
+		Filter(fmt.Sprintf("name = %q", cfg.GCEInstance)).
+		Context(ctx).
+		Pages(ctx, func(list *compute.InstanceAggregatedList) error {
+			for scope, scoped := range list.Items {
+				for _, instance := range scoped.Instances {
+					if instance.Name == cfg.GCEInstance {
+						// Scopes look like "zones/us-central1-a"
+						zone = strings.TrimPrefix(scope, "zones/")
+					}
+				}
+			}
+			return nil
+		})
+	if err != nil {
+		slog.Warn("Could not search other zones for the instance", "error", err)
+		return ""
+	}
+
+	if zone == cfg.GCEZone {
+		return ""
+	}
+	return zone
+}
+
+// parseInstanceSelfLink splits an instance self-link or resource path, e.g.
+// https://www.googleapis.com/compute/v1/projects/p/zones/us-central1-a/instances/name, into its parts
+func parseInstanceSelfLink(link string) (project, zone, name string, err error) {
+	parts := strings.Split(strings.Trim(link, "/"), "/")
+	for i := 0; i+1 < len(parts); i++ {
+		switch parts[i] {
+		case "projects":
+			project = parts[i+1]
+		case "zones":
+			zone = parts[i+1]
+		case "instances":
+			name = parts[i+1]
+		}
+	}
+
+	if project == "" || zone == "" || name == "" {
+		return "", "", "", fmt.Errorf("unrecognized instance self-link %q", link)
+	}
+	return project, zone, name, nil
+}
+
+// suspendLabel records when lightsout last suspended the instance, as unix seconds since label values can't hold a timestamp
+const suspendLabel = "lightsout-last-suspend"
+
+// setSuspendLabel sets suspendLabel on instance, keeping its other labels
+// SetLabels needs the fingerprint of the labels it replaces, if they changed since instance was read we read them again and retry once
+func setSuspendLabel(ctx context.Context, service *compute.Service, instance *compute.Instance) error {
+	cfg := config()
+
+	for attempt := 0; ; attempt++ {
+		labels := make(map[string]string, len(instance.Labels)+1)
+		for k, v := range instance.Labels {
+			labels[k] = v
+		}
+		labels[suspendLabel] = strconv.FormatInt(time.Now().Unix(), 10)
+
+		_, err := service.Instances.SetLabels(cfg.GoogleProjectID, cfg.GCEZone, cfg.GCEInstance, &compute.InstancesSetLabelsRequest{
+			Labels:           labels,
+			LabelFingerprint: instance.LabelFingerprint,
+		}).Context(ctx).Do()
+		if err == nil || attempt > 0 || !isPreconditionFailed(err) {
+			return err
+		}
+
+		slog.Debug("Instance labels changed, retrying with the new fingerprint")
+		instance, err = service.Instances.Get(cfg.GoogleProjectID, cfg.GCEZone, cfg.GCEInstance).Context(ctx).Do()
+		if err != nil {
+			return fmt.Errorf("failed to get instance labels: %w", err)
+		}
+	}
+}
+
+// isPreconditionFailed reports whether err is a fingerprint mismatch
+func isPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed
+}
+
+func markInstanceNotFound() {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	tracker.instanceNotFound = true
+}
+
+func isInstanceNotFound() bool {
+	tracker.mu.RLock()
+	defer tracker.mu.RUnlock()
+
+	return tracker.instanceNotFound
+}
+
+// suspendOutcome describes what suspendMachine did when it didn't fail
+type suspendOutcome string
+
+const (
+	// suspendRequested means we issued the Suspend call
+	suspendRequested suspendOutcome = "suspend_requested"
+	// stopRequested means the instance can't be suspended and FALLBACK_TO_STOP had us stop it instead
+	stopRequested suspendOutcome = "stop_requested"
+	// suspendInProgress means the instance was already on its way down, which is the state we wanted anyway
+	suspendInProgress suspendOutcome = "suspend_in_progress"
+	// suspendNotRunning means the instance wasn't running so there was nothing to do
+	suspendNotRunning suspendOutcome = "not_running"
+)
+
+// inProgressStatuses are the instance statuses that mean a suspend or stop is already under way
+var inProgressStatuses = map[string]bool{
+	"SUSPENDING": true,
+	"STOPPING":   true,
+}
+
+func suspendMachine(ctx context.Context) (*compute.Instance, suspendOutcome, error) {
+	ctx, cancel := context.WithTimeout(ctx, suspendTimeout)
+	defer cancel()
+
+	instance, outcome, err := trySuspendMachine(ctx)
+	if isAuthError(err) {
+		// Long running processes can end up holding credentials that no longer work,
+		// start over with a new service once before giving up
+		slog.Warn("Credentials rejected, recreating compute service", "error", err)
+		resetComputeService()
+		instance, outcome, err = trySuspendMachine(ctx)
+	}
+
+	return instance, outcome, err
+}
+
+func trySuspendMachine(ctx context.Context) (*compute.Instance, suspendOutcome, error) {
+	cfg := config()
+
+	slog.Info("Checking if machine is suspended")
+
+	// Create compute service with default credentials
+	service, err := getComputeService(ctx)
+	if err != nil {
+		return nil, "", fmt.Errorf("createComputeService: %w", err)
+	}
+
+	// Get instance details
+	instance, err := service.Instances.Get(cfg.GoogleProjectID, cfg.GCEZone, cfg.GCEInstance).Context(ctx).Do()
+	if isNotFoundError(err) {
+		if zone := findInstanceZone(ctx, service); zone != "" && cfg.AutoDiscoverZone {
+			slog.Warn("Instance found in another zone, switching to it",
+				"configured_zone", cfg.GCEZone,
+				"actual_zone", zone)
+			updateConfig(func(c *Config) { c.GCEZone = zone })
+			setupLogging()
+			return trySuspendMachine(ctx)
+		} else if zone != "" {
+			slog.Error("Instance found in another zone, update GCP_ZONE or set AUTO_DISCOVER_ZONE=true",
+				"configured_zone", cfg.GCEZone,
+				"actual_zone", zone)
+		}
+		return nil, "", fmt.Errorf("%w: %v", errInstanceNotFound, err)
+	} else if err != nil {
+		return nil, "", fmt.Errorf("failed to get instance: %w", err)
+	}
+
+	switch {
+	case instance.Status == "RUNNING":
+		// If the machine is running, suspend it
+		slog.Info("Instance is RUNNING, suspending instance")
+
+		// With more than one controller, only the one holding the lock suspends, or touches the state and labels
+		lock, err := acquireSuspendLock(ctx, cfg.GCEInstance)
+		if err != nil {
+			return instance, "", err
+		}
+
+		// Flush our counters before the machine goes down, the write may not survive otherwise
+		if err := saveState(); err != nil {
+			slog.Error("Failed to save state snapshot", "error", err)
+		}
+
+		if cfg.RecordSuspendLabel {
+			if err := setSuspendLabel(ctx, service, instance); err != nil {
+				slog.Warn("Failed to record the suspend label, suspending anyway", "error", err)
+			}
+		}
+
+		observeSuspendDecisionLatency()
+		self := managedInstance{Project: cfg.GoogleProjectID, Zone: cfg.GCEZone, Name: cfg.GCEInstance}
+		operation, stopped, err := suspendOrStop(ctx, service, self)
+		lock.release(ctx)
+		if err != nil {
+			// Another suspend may have started between our Get and Suspend, in which case the API
+			// rejects ours but we end up where we wanted
+			current, getErr := service.Instances.Get(cfg.GoogleProjectID, cfg.GCEZone, cfg.GCEInstance).Context(ctx).Do()
+			if getErr == nil && inProgressStatuses[current.Status] {
+				slog.Info("Suspend already in progress", "status", current.Status)
+				return current, suspendInProgress, nil
+			}
+			return instance, "", fmt.Errorf("failed to suspend instance: %w", err)
+		}
+		// We'll be suspended before the operation finishes, so only the warnings it starts with are seen
+		if warnings := operationWarnings(operation); len(warnings) > 0 {
+			slog.Warn("Suspend accepted with warnings", "warnings", warnings)
+		}
+		if stopped {
+			return instance, stopRequested, nil
+		}
+		return instance, suspendRequested, nil
+	case inProgressStatuses[instance.Status]:
+		slog.Info("Suspend already in progress, nothing to do", "status", instance.Status)
+		return instance, suspendInProgress, nil
+	default:
+		slog.Info("Instance is not RUNNING, skipping suspension", "status", instance.Status)
+		return instance, suspendNotRunning, nil
+	}
+}
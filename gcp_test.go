@@ -0,0 +1,317 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	compute "google.golang.org/api/compute/v1"
+	"google.golang.org/api/option"
+)
+
+// useFakeComputeAPI points the compute service at handler and counts how many services get created
+func useFakeComputeAPI(t *testing.T, handler http.Handler) *atomic.Int32 {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	var created atomic.Int32
+	origNewComputeService := newComputeService
+	newComputeService = func(ctx context.Context) (*compute.Service, error) {
+		created.Add(1)
+		return compute.NewService(ctx,
+			option.WithEndpoint(server.URL+"/compute/v1/"),
+			option.WithoutAuthentication())
+	}
+	resetComputeService()
+
+	t.Cleanup(func() {
+		newComputeService = origNewComputeService
+		resetComputeService()
+	})
+
+	return &created
+}
+
+func writeComputeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func TestSuspendMachineRetriesOnUnauthorized(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	var gets, suspends atomic.Int32
+	created := useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/instances/test-instance"):
+			if gets.Add(1) == 1 {
+				w.WriteHeader(http.StatusUnauthorized)
+				writeComputeJSON(w, map[string]any{"error": map[string]any{"code": 401, "message": "Invalid Credentials"}})
+				return
+			}
+			writeComputeJSON(w, compute.Instance{Name: "test-instance", Status: "RUNNING"})
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/instances/test-instance/suspend"):
+			suspends.Add(1)
+			writeComputeJSON(w, compute.Operation{Name: "op-1", Status: "RUNNING"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	instance, outcome, err := suspendMachine(t.Context())
+	if err != nil {
+		t.Fatalf("suspendMachine: %v", err)
+	}
+	if instance.Status != "RUNNING" || outcome != suspendRequested {
+		t.Fatalf("Expected a suspend of a RUNNING instance, got %s / %s", instance.Status, outcome)
+	}
+	if created.Load() != 2 {
+		t.Fatalf("Expected the compute service to be recreated once, created %d", created.Load())
+	}
+	if suspends.Load() != 1 {
+		t.Fatalf("Expected one suspend call, got %d", suspends.Load())
+	}
+}
+
+func TestSuspendMachineGivesUpAfterSecondUnauthorized(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	created := useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		writeComputeJSON(w, map[string]any{"error": map[string]any{"code": 401, "message": "Invalid Credentials"}})
+	}))
+
+	if _, _, err := suspendMachine(t.Context()); !isAuthError(err) {
+		t.Fatalf("Expected an auth error, got %v", err)
+	}
+	if created.Load() != 2 {
+		t.Fatalf("Expected exactly one retry, created %d services", created.Load())
+	}
+}
+
+func TestSuspendMachineReusesComputeService(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	created := useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeComputeJSON(w, compute.Instance{Name: "test-instance", Status: "SUSPENDED"})
+	}))
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := suspendMachine(t.Context()); err != nil {
+			t.Fatalf("suspendMachine: %v", err)
+		}
+	}
+	if created.Load() != 1 {
+		t.Fatalf("Expected the compute service to be reused, created %d", created.Load())
+	}
+}
+
+func TestDeletedInstanceStopsTimer(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	suspendFunc = suspendInstance
+	useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		writeComputeJSON(w, map[string]any{"error": map[string]any{"code": 404, "message": "The resource was not found"}})
+	}))
+
+	suspendAndShutdown("inactivity timeout", nil)
+
+	if !currentStatus().InstanceNotFound {
+		t.Fatal("Expected /status to report the instance as not found")
+	}
+
+	shutdownMutex.Lock()
+	timerRunning := shutdownTimer != nil
+	shutdownMutex.Unlock()
+	if timerRunning {
+		t.Fatal("Shutdown timer should be stopped when the instance does not exist")
+	}
+
+	select {
+	case <-serverShutdown:
+		t.Fatal("Server should keep running so the state can be inspected")
+	default:
+	}
+
+	// Pings should not restart the timer either
+	pingHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/ping", nil))
+	shutdownMutex.Lock()
+	timerRunning = shutdownTimer != nil
+	shutdownMutex.Unlock()
+	if timerRunning {
+		t.Fatal("Pings should not restart the timer once the instance is gone")
+	}
+}
+
+func TestSuspendMachineAlreadyInProgress(t *testing.T) {
+	for _, status := range []string{"SUSPENDING", "STOPPING"} {
+		t.Run(status, func(t *testing.T) {
+			cleanup := setupTestEnvironment()
+			defer cleanup()
+
+			var suspends atomic.Int32
+			useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodPost {
+					suspends.Add(1)
+				}
+				writeComputeJSON(w, compute.Instance{Name: "test-instance", Status: status})
+			}))
+
+			_, outcome, err := suspendMachine(t.Context())
+			if err != nil {
+				t.Fatalf("Expected success while %s, got %v", status, err)
+			}
+			if outcome != suspendInProgress {
+				t.Fatalf("Expected outcome %s, got %s", suspendInProgress, outcome)
+			}
+			if suspends.Load() != 0 {
+				t.Fatal("Suspend should not be issued again while one is in progress")
+			}
+		})
+	}
+}
+
+func TestSuspendMachineRacesAnotherSuspend(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	var gets atomic.Int32
+	useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			w.WriteHeader(http.StatusBadRequest)
+			writeComputeJSON(w, map[string]any{"error": map[string]any{"code": 400, "message": "The resource is not ready"}})
+			return
+		}
+		status := "RUNNING"
+		if gets.Add(1) > 1 {
+			status = "SUSPENDING"
+		}
+		writeComputeJSON(w, compute.Instance{Name: "test-instance", Status: status})
+	}))
+
+	_, outcome, err := suspendMachine(t.Context())
+	if err != nil || outcome != suspendInProgress {
+		t.Fatalf("Expected an in-progress success, got %s / %v", outcome, err)
+	}
+}
+
+func movedInstanceAPI(suspends *atomic.Int32) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/aggregated/instances"):
+			writeComputeJSON(w, compute.InstanceAggregatedList{Items: map[string]compute.InstancesScopedList{
+				"zones/test-zone":  {},
+				"zones/other-zone": {Instances: []*compute.Instance{{Name: "test-instance", Status: "RUNNING"}}},
+			}})
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/zones/other-zone/instances/test-instance"):
+			writeComputeJSON(w, compute.Instance{Name: "test-instance", Status: "RUNNING"})
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/zones/other-zone/instances/test-instance/suspend"):
+			suspends.Add(1)
+			writeComputeJSON(w, compute.Operation{Name: "op-1", Status: "RUNNING"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			writeComputeJSON(w, map[string]any{"error": map[string]any{"code": 404, "message": "The resource was not found"}})
+		}
+	})
+}
+
+func TestMovedInstanceIsReported(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	var suspends atomic.Int32
+	useFakeComputeAPI(t, movedInstanceAPI(&suspends))
+
+	_, _, err := suspendMachine(t.Context())
+	if !errors.Is(err, errInstanceNotFound) {
+		t.Fatalf("Expected errInstanceNotFound without AUTO_DISCOVER_ZONE, got %v", err)
+	}
+	if suspends.Load() != 0 || config().GCEZone != "test-zone" {
+		t.Fatal("The zone should not change without AUTO_DISCOVER_ZONE")
+	}
+}
+
+func TestMovedInstanceAutoDiscoverZone(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+	defer setupTestLogging()
+
+	updateConfig(func(cfg *Config) { cfg.AutoDiscoverZone = true })
+	var suspends atomic.Int32
+	useFakeComputeAPI(t, movedInstanceAPI(&suspends))
+
+	_, outcome, err := suspendMachine(t.Context())
+	if err != nil {
+		t.Fatalf("suspendMachine: %v", err)
+	}
+	if outcome != suspendRequested || suspends.Load() != 1 {
+		t.Fatalf("Expected the instance to be suspended in its new zone, got %s", outcome)
+	}
+	if config().GCEZone != "other-zone" {
+		t.Fatalf("Expected GCP_ZONE to be corrected, got %s", config().GCEZone)
+	}
+}
+
+func TestSuspendRecordsLabel(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) { cfg.RecordSuspendLabel = true })
+
+	var setLabels, suspends atomic.Int32
+	var gotLabels compute.InstancesSetLabelsRequest
+	useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/instances/test-instance"):
+			// The labels changed between our first read and the SetLabels call
+			fingerprint := "old"
+			if setLabels.Load() > 0 {
+				fingerprint = "new"
+			}
+			writeComputeJSON(w, compute.Instance{
+				Name:             "test-instance",
+				Status:           "RUNNING",
+				Labels:           map[string]string{"team": "ci"},
+				LabelFingerprint: fingerprint,
+			})
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/setLabels"):
+			setLabels.Add(1)
+			var req compute.InstancesSetLabelsRequest
+			_ = json.NewDecoder(r.Body).Decode(&req)
+			if req.LabelFingerprint != "new" {
+				w.WriteHeader(http.StatusPreconditionFailed)
+				writeComputeJSON(w, map[string]any{"error": map[string]any{"code": 412, "message": "Labels fingerprint either invalid or resource labels have changed"}})
+				return
+			}
+			gotLabels = req
+			writeComputeJSON(w, compute.Operation{Name: "op-labels"})
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/suspend"):
+			suspends.Add(1)
+			writeComputeJSON(w, compute.Operation{Name: "op-1", Status: "RUNNING"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	if _, _, err := suspendMachine(t.Context()); err != nil {
+		t.Fatalf("suspendMachine: %v", err)
+	}
+	if setLabels.Load() != 2 || suspends.Load() != 1 {
+		t.Fatalf("Expected a retried SetLabels and one suspend, got %d / %d", setLabels.Load(), suspends.Load())
+	}
+	if gotLabels.Labels["team"] != "ci" || gotLabels.Labels[suspendLabel] == "" {
+		t.Fatalf("Expected the existing labels plus %s, got %v", suspendLabel, gotLabels.Labels)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// githubRunner is the subset of the GitHub self-hosted runner object we care about
+type githubRunner struct {
+	ID     int64  `json:"id"`
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Busy   bool   `json:"busy"`
+}
+
+type githubRunnerList struct {
+	TotalCount int            `json:"total_count"`
+	Runners    []githubRunner `json:"runners"`
+}
+
+var (
+	githubClient  = &http.Client{Timeout: 10 * time.Second}
+	errRunnerBusy = errors.New("runner is busy")
+)
+
+// githubRunnersURL returns the runners collection URL for the configured repository or organization
+func githubRunnersURL() (string, error) {
+	cfg := config()
+
+	base := strings.TrimRight(cfg.GitHubAPIURL, "/")
+	switch {
+	case cfg.GitHubRepository != "":
+		return base + "/repos/" + cfg.GitHubRepository + "/actions/runners", nil
+	case cfg.GitHubOrg != "":
+		return base + "/orgs/" + cfg.GitHubOrg + "/actions/runners", nil
+	default:
+		return "", fmt.Errorf("GITHUB_REPOSITORY or GITHUB_ORG must be set")
+	}
+}
+
+func githubRequest(ctx context.Context, method, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+config().GitHubToken)
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+
+	return githubClient.Do(req)
+}
+
+// getGitHubRunner looks up the configured runner by name via the GitHub API
+func getGitHubRunner(ctx context.Context) (*githubRunner, error) {
+	cfg := config()
+
+	runnersURL, err := githubRunnersURL()
+	if err != nil {
+		return nil, err
+	}
+
+	for page := 1; ; page++ {
+		resp, err := githubRequest(ctx, http.MethodGet, fmt.Sprintf("%s?per_page=100&page=%d", runnersURL, page))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list runners: %v", err)
+		}
+
+		var list githubRunnerList
+		err = func() error {
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("failed to list runners: %s", resp.Status)
+			}
+			return json.NewDecoder(resp.Body).Decode(&list)
+		}()
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range list.Runners {
+			if list.Runners[i].Name == cfg.GitHubRunnerName {
+				return &list.Runners[i], nil
+			}
+		}
+
+		if len(list.Runners) < 100 {
+			return nil, fmt.Errorf("runner %q not found", cfg.GitHubRunnerName)
+		}
+	}
+}
+
+// removeGitHubRunner deletes the runner registration so GitHub doesn't keep a stale entry while we're suspended
+func removeGitHubRunner(ctx context.Context, id int64) error {
+	runnersURL, err := githubRunnersURL()
+	if err != nil {
+		return err
+	}
+
+	resp, err := githubRequest(ctx, http.MethodDelete, fmt.Sprintf("%s/%d", runnersURL, id))
+	if err != nil {
+		return fmt.Errorf("failed to remove runner: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("failed to remove runner: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// checkGitHubRunnerIdle returns the runner when it is safe to suspend, or an error if the runner is busy
+// or its state could not be determined
+func checkGitHubRunnerIdle(ctx context.Context) (*githubRunner, error) {
+	runner, err := getGitHubRunner(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Debug("GitHub runner state",
+		"runner", runner.Name,
+		"status", runner.Status,
+		"busy", runner.Busy)
+
+	if runner.Busy {
+		return runner, errRunnerBusy
+	}
+
+	return runner, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func newGitHubTestServer(t *testing.T, busy bool, deleted *atomic.Bool) *httptest.Server {
+	t.Helper()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /repos/libops/test/actions/runners", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer test-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(githubRunnerList{
+			TotalCount: 2,
+			Runners: []githubRunner{
+				{ID: 1, Name: "other-runner", Status: "online", Busy: true},
+				{ID: 42, Name: "test-runner", Status: "online", Busy: busy},
+			},
+		})
+	})
+	mux.HandleFunc("DELETE /repos/libops/test/actions/runners/42", func(w http.ResponseWriter, r *http.Request) {
+		deleted.Store(true)
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func setupGitHubTestConfig(url string) {
+	updateConfig(func(cfg *Config) {
+		cfg.GitHubToken = "test-token"
+		cfg.GitHubAPIURL = url
+		cfg.GitHubRepository = "libops/test"
+		cfg.GitHubRunnerName = "test-runner"
+	})
+}
+
+func TestBusyGitHubRunnerSkipsSuspension(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	var deleted atomic.Bool
+	server := newGitHubTestServer(t, true, &deleted)
+	setupGitHubTestConfig(server.URL)
+	updateConfig(func(cfg *Config) { cfg.GitHubRemoveRunner = true })
+
+	initiateShutdown()
+
+	if mockGCP.WasSuspendCalled() {
+		t.Fatal("Suspension should not be called while the runner is busy")
+	}
+	if deleted.Load() {
+		t.Fatal("Busy runner registration should not be removed")
+	}
+
+	shutdownMutex.Lock()
+	timerRunning := shutdownTimer != nil
+	shutdownMutex.Unlock()
+	if !timerRunning {
+		t.Fatal("Shutdown timer should be reset when the runner is busy")
+	}
+}
+
+func TestIdleGitHubRunnerIsRemovedBeforeSuspension(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	var deleted atomic.Bool
+	server := newGitHubTestServer(t, false, &deleted)
+	setupGitHubTestConfig(server.URL)
+	updateConfig(func(cfg *Config) { cfg.GitHubRemoveRunner = true })
+
+	initiateShutdown()
+
+	if !deleted.Load() {
+		t.Fatal("Idle runner registration should be removed before suspension")
+	}
+	if !mockGCP.WasSuspendCalled() {
+		t.Fatal("Suspension should be called when the runner is idle")
+	}
+}
+
+func TestGitHubRunnerNotFound(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	var deleted atomic.Bool
+	server := newGitHubTestServer(t, false, &deleted)
+	setupGitHubTestConfig(server.URL)
+	updateConfig(func(cfg *Config) { cfg.GitHubRunnerName = "missing-runner" })
+
+	if _, err := getGitHubRunner(t.Context()); err == nil {
+		t.Fatal("Expected an error for an unknown runner")
+	}
+}
+
+func TestUnknownGitHubRunnerStateStaysOnline(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	var deleted atomic.Bool
+	server := newGitHubTestServer(t, false, &deleted)
+	setupGitHubTestConfig(server.URL)
+	updateConfig(func(cfg *Config) {
+		cfg.GitHubRunnerName = "missing-runner"
+	})
+
+	initiateShutdown()
+
+	if mockGCP.WasSuspendCalled() {
+		t.Fatal("Suspension should not be called when the runner state is unknown")
+	}
+	shutdownMutex.Lock()
+	timerRunning := shutdownTimer != nil
+	shutdownMutex.Unlock()
+	if !timerRunning {
+		t.Fatal("Shutdown timer should be reset when the runner state is unknown")
+	}
+
+	updateConfig(func(cfg *Config) {
+		cfg.GitHubSuspendOnUnknown = true
+	})
+
+	initiateShutdown()
+
+	if !mockGCP.WasSuspendCalled() {
+		t.Fatal("Suspension should be called with GITHUB_SUSPEND_ON_UNKNOWN")
+	}
+}
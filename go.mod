@@ -3,6 +3,8 @@ module github.com/libops/lightsout
 go 1.25.8
 
 require (
+	github.com/fsnotify/fsnotify v1.10.1
+	github.com/quic-go/quic-go v0.59.0
 	golang.org/x/oauth2 v0.36.0
 	google.golang.org/api v0.282.0
 )
@@ -19,6 +21,7 @@ require (
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/googleapis/enterprise-certificate-proxy v0.3.16 // indirect
 	github.com/googleapis/gax-go/v2 v2.22.0 // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
 	go.opentelemetry.io/auto/sdk v1.2.1 // indirect
 	go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp v0.67.0 // indirect
 	go.opentelemetry.io/otel v1.43.0 // indirect
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+type heartbeatPayload struct {
+	Project       string    `json:"project"`
+	Zone          string    `json:"zone"`
+	Instance      string    `json:"instance"`
+	Timestamp     time.Time `json:"timestamp"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	RequestCount  int64     `json:"request_count"`
+}
+
+var heartbeatClient = &http.Client{Timeout: 10 * time.Second}
+
+// runHeartbeat POSTs to HEARTBEAT_URL every HEARTBEAT_INTERVAL until ctx is cancelled
+// so an external watchdog can alert when lightsout itself stops running
+// Watchdog URLs usually embed their token, so only the host is ever logged
+func runHeartbeat(ctx context.Context) {
+	cfg := config()
+
+	slog.Info("Starting heartbeat",
+		"host", urlHost(cfg.HeartbeatURL),
+		"interval_seconds", int(cfg.HeartbeatInterval.Seconds()))
+
+	ticker := time.NewTicker(cfg.HeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := sendHeartbeat(ctx); err != nil && ctx.Err() == nil {
+			slog.Warn("Failed to send heartbeat", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Debug("Heartbeat stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func sendHeartbeat(ctx context.Context) error {
+	cfg := config()
+
+	tracker.mu.RLock()
+	payload := heartbeatPayload{
+		Project:       cfg.GoogleProjectID,
+		Zone:          cfg.GCEZone,
+		Instance:      cfg.GCEInstance,
+		Timestamp:     time.Now(),
+		UptimeSeconds: int64(time.Since(tracker.startedAt).Seconds()),
+		RequestCount:  tracker.requestCount,
+	}
+	tracker.mu.RUnlock()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, cfg.HeartbeatURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("invalid heartbeat URL: %w", withoutURL(err))
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := heartbeatClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("heartbeat to %s failed: %w", req.URL.Host, withoutURL(err))
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("heartbeat rejected: %s", resp.Status)
+	}
+
+	slog.Debug("Heartbeat sent")
+	return nil
+}
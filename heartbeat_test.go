@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatPostsUntilCancelled(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	beats := make(chan heartbeatPayload, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload heartbeatPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		beats <- payload
+	}))
+	defer server.Close()
+
+	updateConfig(func(cfg *Config) {
+		cfg.HeartbeatURL = server.URL
+		cfg.HeartbeatInterval = 10 * time.Millisecond
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		runHeartbeat(ctx)
+		close(done)
+	}()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case payload := <-beats:
+			if payload.Instance != "test-instance" {
+				t.Fatalf("Expected instance test-instance, got %q", payload.Instance)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for heartbeat")
+		}
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Heartbeat did not stop after cancellation")
+	}
+}
+
+func TestHeartbeatErrorHidesURL(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) {
+		cfg.HeartbeatURL = "http://127.0.0.1:0/ping/s3cret-check-uuid"
+	})
+
+	err := sendHeartbeat(t.Context())
+	if err == nil {
+		t.Fatal("Expected the heartbeat to fail")
+	}
+	if strings.Contains(err.Error(), "s3cret") {
+		t.Fatalf("Expected the heartbeat URL to be left out of %q", err)
+	}
+	if got := urlHost(config().HeartbeatURL); got != "127.0.0.1:0" {
+		t.Fatalf("Expected only the host to be logged, got %q", got)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+const hookPreSuspend = "pre_suspend"
+
+// hookCommand returns the shell command configured for the named hook
+func hookCommand(name string) (string, bool) {
+	switch name {
+	case hookPreSuspend:
+		return config().PreSuspendHook, true
+	}
+	return "", false
+}
+
+// runHook runs a hook command with its combined output written to out, bounded by HOOK_TIMEOUT
+func runHook(ctx context.Context, name, command string, out io.Writer) error {
+	cfg := config()
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.HookTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Env = append(os.Environ(), "LIGHTSOUT_HOOK="+name)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	// Don't wait forever on grandchildren that kept the output pipe open
+	cmd.WaitDelay = time.Second
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return fmt.Errorf("hook %s timed out after %s", name, cfg.HookTimeout)
+		}
+		return fmt.Errorf("hook %s failed: %w", name, err)
+	}
+	return nil
+}
+
+// runPreSuspendHook runs PRE_SUSPEND_HOOK and logs its output
+// Whether a failure blocks the suspend is up to SHUTDOWN_ABORT_ON
+func runPreSuspendHook(ctx context.Context) error {
+	var output bytes.Buffer
+	if err := runHook(ctx, hookPreSuspend, config().PreSuspendHook, &output); err != nil {
+		slog.Error("Pre-suspend hook failed", "error", err, "output", output.String())
+		return err
+	}
+	slog.Info("Pre-suspend hook finished", "output", output.String())
+	return nil
+}
+
+// flushWriter flushes after every write so hook output reaches the client as it is produced
+type flushWriter struct {
+	w  io.Writer
+	rc *http.ResponseController
+}
+
+func (f *flushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if err == nil {
+		_ = f.rc.Flush()
+	}
+	return n, err
+}
+
+// testHookHandler runs a configured hook on demand and streams its output back
+func testHookHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	command, ok := hookCommand(name)
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown hook %q", name), http.StatusBadRequest)
+		return
+	}
+	if command == "" {
+		http.Error(w, fmt.Sprintf("Hook %q is not configured", name), http.StatusNotFound)
+		return
+	}
+
+	slog.Info("Running hook on request", "hook", name)
+
+	// The hook may run longer than the server's write timeout
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Now().Add(config().HookTimeout + 5*time.Second))
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	// The status is already sent by the time the hook finishes, so the result goes in the last line
+	if err := runHook(r.Context(), name, command, &flushWriter{w: w, rc: rc}); err != nil {
+		slog.Warn("Hook test failed", "hook", name, "error", err)
+		fmt.Fprintf(w, "\nlightsout: %v\n", err)
+		return
+	}
+	fmt.Fprintf(w, "\nlightsout: hook %s succeeded\n", name)
+}
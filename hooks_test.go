@@ -0,0 +1,75 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTestHookStreamsOutput(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) {
+		cfg.PreSuspendHook = `echo "flushing caches for $LIGHTSOUT_HOOK"; echo oops >&2`
+		cfg.HookTimeout = 5 * time.Second
+	})
+
+	w := httptest.NewRecorder()
+	requireAdmin(scopeTestHook, testHookHandler)(w, adminRequest("POST", "/test-hook?name=pre_suspend"))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+	body := w.Body.String()
+	for _, want := range []string{"flushing caches for pre_suspend", "oops", "hook pre_suspend succeeded"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("Expected %q in the response, got %q", want, body)
+		}
+	}
+}
+
+func TestTestHookReportsFailureAndTimeout(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) { cfg.HookTimeout = 200 * time.Millisecond })
+
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"exit 3", "exit status 3"},
+		{"sleep 10", "timed out"},
+	}
+
+	for _, tt := range tests {
+		updateConfig(func(cfg *Config) { cfg.PreSuspendHook = tt.command })
+
+		w := httptest.NewRecorder()
+		requireAdmin(scopeTestHook, testHookHandler)(w, adminRequest("POST", "/test-hook?name=pre_suspend"))
+
+		if !strings.Contains(w.Body.String(), tt.want) {
+			t.Fatalf("%s: expected %q in the response, got %q", tt.command, tt.want, w.Body.String())
+		}
+	}
+}
+
+func TestTestHookUnknownOrUnconfigured(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	w := httptest.NewRecorder()
+	requireAdmin(scopeTestHook, testHookHandler)(w, adminRequest("POST", "/test-hook?name=post_suspend"))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("Expected status 400 for an unknown hook, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	requireAdmin(scopeTestHook, testHookHandler)(w, adminRequest("POST", "/test-hook?name=pre_suspend"))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status 404 for an unconfigured hook, got %d", w.Code)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// http3Listener serves the private mux over QUIC next to the TCP listener, for clients on lossy networks
+type http3Listener struct {
+	server *http3.Server
+	conn   net.PacketConn
+}
+
+// startHTTP3 serves handler over HTTP/3 on the UDP side of addr, using TLS_CERT_FILE and TLS_KEY_FILE
+// The socket is bound before returning so a port conflict fails startup like it does for the TCP listeners
+func startHTTP3(addr string, handler http.Handler) (*http3Listener, error) {
+	cfg := config()
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+
+	conn, err := net.ListenPacket("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on udp %s: %w", addr, err)
+	}
+
+	l := &http3Listener{
+		server: &http3.Server{
+			Addr:    addr,
+			Handler: handler,
+			TLSConfig: http3.ConfigureTLSConfig(&tls.Config{
+				Certificates: []tls.Certificate{cert},
+				MinVersion:   tls.VersionTLS13,
+			}),
+			IdleTimeout: 120 * time.Second,
+		},
+		conn: conn,
+	}
+
+	go func() {
+		slog.Info("HTTP/3 server starting", "addr", conn.LocalAddr().String())
+		if err := l.server.Serve(conn); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("HTTP/3 server error", "addr", addr, "error", err)
+		}
+	}()
+
+	return l, nil
+}
+
+func (l *http3Listener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+// Shutdown waits for in-flight requests like http.Server.Shutdown, then releases the socket the server doesn't own
+func (l *http3Listener) Shutdown(ctx context.Context) error {
+	err := l.server.Shutdown(ctx)
+	_ = l.conn.Close()
+	return err
+}
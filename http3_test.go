@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+// writeTestCertificate writes a self-signed certificate for localhost and returns its cert and key paths
+func writeTestCertificate(t *testing.T) (string, string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestHTTP3ServesPing(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	certFile, keyFile := writeTestCertificate(t)
+	updateConfig(func(cfg *Config) { cfg.TLSCertFile, cfg.TLSKeyFile = certFile, keyFile })
+
+	listener, err := startHTTP3("127.0.0.1:0", newMux())
+	if err != nil {
+		t.Fatalf("startHTTP3: %v", err)
+	}
+	defer listener.Shutdown(t.Context())
+
+	transport := &http3.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	defer transport.Close()
+	client := &http.Client{Transport: transport, Timeout: 5 * time.Second}
+
+	resp, err := client.Get("https://" + listener.Addr().String() + "/ping")
+	if err != nil {
+		t.Fatalf("GET /ping over HTTP/3: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.ProtoMajor != 3 {
+		t.Fatalf("Expected a 200 over HTTP/3, got %d over %s: %s", resp.StatusCode, resp.Proto, body)
+	}
+	if currentStatus().RequestCount != 1 {
+		t.Fatalf("Expected the ping to be counted, got %d", currentStatus().RequestCount)
+	}
+}
+
+func TestHTTP3NeedsCertificate(t *testing.T) {
+	t.Setenv("HTTP3", "true")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.HTTP3 {
+		t.Fatal("Expected HTTP3 to be turned off without a certificate")
+	}
+
+	t.Setenv("STRICT_CONFIG", "true")
+	if _, err := loadConfig(); err == nil {
+		t.Fatal("Expected HTTP3 without a certificate to be rejected with STRICT_CONFIG")
+	}
+}
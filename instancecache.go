@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// instanceRead is one Instances.Get of this instance, shared by every caller that wanted it while it ran
+type instanceRead struct {
+	done      chan struct{}
+	instance  *compute.Instance
+	fetchedAt time.Time
+	err       error
+}
+
+var (
+	instanceCacheMu sync.Mutex
+	// instanceCache is the last successful read, reused for INSTANCE_CACHE_TTL
+	instanceCache *instanceRead
+	// instanceInFlight is the read under way, if any
+	instanceInFlight *instanceRead
+)
+
+// readInstance returns this instance as GCP reports it and how old that report is
+// A read younger than INSTANCE_CACHE_TTL is reused, and concurrent callers share a single Instances.Get,
+// so dashboards polling the endpoints that show live state don't run into API rate limits.
+// The suspend path always reads the instance itself, it can't act on stale state
+func readInstance(ctx context.Context) (*compute.Instance, time.Duration, error) {
+	ttl := config().InstanceCacheTTL
+
+	instanceCacheMu.Lock()
+	if cached := instanceCache; cached != nil && ttl > 0 && time.Since(cached.fetchedAt) < ttl {
+		instanceCacheMu.Unlock()
+		return cached.instance, time.Since(cached.fetchedAt), nil
+	}
+	read := instanceInFlight
+	if read == nil {
+		read = &instanceRead{done: make(chan struct{})}
+		instanceInFlight = read
+		go fetchInstance(read)
+	}
+	instanceCacheMu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	case <-read.done:
+	}
+	if read.err != nil {
+		return nil, 0, read.err
+	}
+	return read.instance, time.Since(read.fetchedAt), nil
+}
+
+// fetchInstance runs read and publishes its result
+// It doesn't use any one caller's context, a caller giving up shouldn't fail the others waiting on the same read
+func fetchInstance(read *instanceRead) {
+	cfg := config()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	service, err := getComputeService(ctx)
+	if err == nil {
+		read.instance, err = service.Instances.Get(cfg.GoogleProjectID, cfg.GCEZone, cfg.GCEInstance).Context(ctx).Do()
+	}
+	read.err = err
+	read.fetchedAt = time.Now()
+
+	instanceCacheMu.Lock()
+	instanceInFlight = nil
+	// Failures aren't cached, the next caller tries again
+	if err == nil {
+		instanceCache = read
+	}
+	instanceCacheMu.Unlock()
+
+	close(read.done)
+}
+
+// resetInstanceCache forgets the cached read
+func resetInstanceCache() {
+	instanceCacheMu.Lock()
+	defer instanceCacheMu.Unlock()
+
+	instanceCache = nil
+}
+
+type instanceResponse struct {
+	Name               string            `json:"name"`
+	Zone               string            `json:"zone"`
+	Status             string            `json:"status"`
+	MachineType        string            `json:"machine_type"`
+	Labels             map[string]string `json:"labels,omitempty"`
+	LastStartTimestamp string            `json:"last_start_timestamp,omitempty"`
+	LastSuspendedAt    string            `json:"last_suspended_timestamp,omitempty"`
+	CacheAgeSeconds    float64           `json:"cache_age_seconds"`
+}
+
+// instanceHandler reports the instance as GCP sees it, through the shared instance cache
+func instanceHandler(w http.ResponseWriter, r *http.Request) {
+	cfg := config()
+	if cfg.Provider == providerNoop || len(missingGCPConfig(cfg)) > 0 {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": "no GCP instance configured"})
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), 30*time.Second)
+	defer cancel()
+
+	instance, age, err := readInstance(ctx)
+	if err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, context.DeadlineExceeded) {
+			status = http.StatusGatewayTimeout
+		}
+		writeJSON(w, status, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, instanceResponse{
+		Name:               instance.Name,
+		Zone:               cfg.GCEZone,
+		Status:             instance.Status,
+		MachineType:        instance.MachineType,
+		Labels:             instance.Labels,
+		LastStartTimestamp: instance.LastStartTimestamp,
+		LastSuspendedAt:    instance.LastSuspendedTimestamp,
+		CacheAgeSeconds:    age.Seconds(),
+	})
+}
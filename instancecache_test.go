@@ -0,0 +1,113 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// useFakeInstanceGets serves this instance as RUNNING after delay and counts the reads
+func useFakeInstanceGets(t *testing.T, delay time.Duration) *atomic.Int32 {
+	t.Helper()
+
+	var gets atomic.Int32
+	useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, "/instances/test-instance") {
+			http.NotFound(w, r)
+			return
+		}
+		gets.Add(1)
+		time.Sleep(delay)
+		writeComputeJSON(w, &compute.Instance{Name: "test-instance", Status: "RUNNING"})
+	}))
+	return &gets
+}
+
+func TestReadInstanceSharesConcurrentReads(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	gets := useFakeInstanceGets(t, 100*time.Millisecond)
+	updateConfig(func(cfg *Config) { cfg.InstanceCacheTTL = 0 })
+
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Go(func() {
+			instance, _, err := readInstance(t.Context())
+			if err != nil || instance.Status != "RUNNING" {
+				t.Errorf("Expected the instance, got %v, %v", instance, err)
+			}
+		})
+	}
+	wg.Wait()
+
+	if got := gets.Load(); got != 1 {
+		t.Fatalf("Expected concurrent reads to share one Instances.Get, got %d", got)
+	}
+
+	// Without a TTL nothing is kept once the read is done
+	if _, _, err := readInstance(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if got := gets.Load(); got != 2 {
+		t.Fatalf("Expected a fresh read with INSTANCE_CACHE_TTL=0, got %d reads", got)
+	}
+}
+
+func TestReadInstanceCachesForTTL(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	gets := useFakeInstanceGets(t, 0)
+	updateConfig(func(cfg *Config) { cfg.InstanceCacheTTL = time.Minute })
+
+	if _, _, err := readInstance(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	_, age, err := readInstance(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gets.Load(); got != 1 {
+		t.Fatalf("Expected the second read to come from the cache, got %d reads", got)
+	}
+	if age < 10*time.Millisecond {
+		t.Fatalf("Expected the cache age to be reported, got %v", age)
+	}
+}
+
+func TestInstanceEndpoint(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	useFakeInstanceGets(t, 0)
+
+	rec := httptest.NewRecorder()
+	instanceHandler(rec, httptest.NewRequest(http.MethodGet, "/instance", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+
+	var resp instanceResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Name != "test-instance" || resp.Status != "RUNNING" || resp.Zone != "test-zone" {
+		t.Fatalf("Unexpected response %+v", resp)
+	}
+
+	updateConfig(func(cfg *Config) { cfg.GCEInstance = "" })
+	rec = httptest.NewRecorder()
+	instanceHandler(rec, httptest.NewRequest(http.MethodGet, "/instance", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected 503 without an instance configured, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// managedInstance is another instance from INSTANCE_LIST_FILE that is suspended along with this one
+type managedInstance struct {
+	Project string
+	Zone    string
+	Name    string
+}
+
+func (i managedInstance) String() string {
+	return fmt.Sprintf("projects/%s/zones/%s/instances/%s", i.Project, i.Zone, i.Name)
+}
+
+var (
+	managedInstancesMu sync.Mutex
+	managedInstances   []managedInstance
+)
+
+// parseInstanceList reads one instance per line, as a self-link, a resource path or a bare name in GCP_PROJECT and GCP_ZONE
+// Blank lines and lines starting with # are skipped
+func parseInstanceList(cfg *Config, content string) ([]managedInstance, error) {
+	var instances []managedInstance
+
+	scanner := bufio.NewScanner(strings.NewReader(content))
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+
+		instance := managedInstance{Project: cfg.GoogleProjectID, Zone: cfg.GCEZone, Name: entry}
+		if strings.Contains(entry, "/") {
+			project, zone, name, err := parseInstanceSelfLink(entry)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+			instance = managedInstance{Project: project, Zone: zone, Name: name}
+		} else if cfg.GoogleProjectID == "" || cfg.GCEZone == "" {
+			return nil, fmt.Errorf("line %d: %q needs GCP_PROJECT and GCP_ZONE, or use a self-link", line, entry)
+		}
+
+		// This instance is suspended by the usual path, last, since suspending it stops us
+		if instance.Project == cfg.GoogleProjectID && instance.Zone == cfg.GCEZone && instance.Name == cfg.GCEInstance {
+			continue
+		}
+		if slices.Contains(instances, instance) {
+			continue
+		}
+		// Pings are scoped to an instance by name, so names have to be unique across zones and projects
+		if slices.ContainsFunc(instances, func(i managedInstance) bool { return i.Name == instance.Name }) {
+			return nil, fmt.Errorf("line %d: instance name %q is listed in two places", line, instance.Name)
+		}
+		instances = append(instances, instance)
+	}
+
+	return instances, scanner.Err()
+}
+
+// loadInstanceList re-reads INSTANCE_LIST_FILE, logging the instances that joined or left
+// A file that can't be read or parsed leaves the current list in place
+func loadInstanceList() error {
+	cfg := config()
+
+	data, err := os.ReadFile(cfg.InstanceListFile)
+	if err != nil {
+		return fmt.Errorf("failed to read instance list: %w", err)
+	}
+	instances, err := parseInstanceList(cfg, string(data))
+	if err != nil {
+		return fmt.Errorf("failed to parse instance list: %w", err)
+	}
+
+	managedInstancesMu.Lock()
+	previous := managedInstances
+	managedInstances = instances
+	managedInstancesMu.Unlock()
+	syncInstanceStates(instances)
+
+	for _, instance := range instances {
+		if !slices.Contains(previous, instance) {
+			slog.Info("Managing instance from the instance list", "instance", instance.String())
+		}
+	}
+	for _, instance := range previous {
+		if !slices.Contains(instances, instance) {
+			slog.Info("Instance removed from the instance list, no longer managing it", "instance", instance.String())
+		}
+	}
+
+	return nil
+}
+
+// currentManagedInstances returns the instances from INSTANCE_LIST_FILE as of the last refresh
+func currentManagedInstances() []managedInstance {
+	managedInstancesMu.Lock()
+	defer managedInstancesMu.Unlock()
+
+	return slices.Clone(managedInstances)
+}
+
+// watchInstanceList re-reads INSTANCE_LIST_FILE every INSTANCE_LIST_REFRESH until ctx is cancelled
+func watchInstanceList(ctx context.Context) {
+	cfg := config()
+
+	slog.Info("Watching instance list",
+		"path", cfg.InstanceListFile,
+		"refresh_seconds", int(cfg.InstanceListRefresh.Seconds()))
+
+	ticker := time.NewTicker(cfg.InstanceListRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := loadInstanceList(); err != nil {
+				slog.Error("Failed to refresh instance list", "path", cfg.InstanceListFile, "error", err)
+			}
+		}
+	}
+}
+
+// suspendManagedInstances suspends every running instance from INSTANCE_LIST_FILE, nothing is left to manage them once we are gone
+func suspendManagedInstances(ctx context.Context) error {
+	return suspendInstances(ctx, currentManagedInstances())
+}
+
+// suspendInstances suspends every running instance in instances and waits for the suspends to finish
+// Each is attempted even if another fails, and the failures are returned together
+func suspendInstances(ctx context.Context, instances []managedInstance) error {
+	if len(instances) == 0 {
+		return nil
+	}
+
+	service, err := getComputeService(ctx)
+	if err != nil {
+		return fmt.Errorf("createComputeService: %w", err)
+	}
+
+	type pendingOperation struct {
+		instance  managedInstance
+		operation *compute.Operation
+	}
+
+	var (
+		errs    []error
+		pending []pendingOperation
+	)
+	for _, instance := range instances {
+		current, err := service.Instances.Get(instance.Project, instance.Zone, instance.Name).Context(ctx).Do()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: failed to get instance: %w", instance, err))
+			continue
+		}
+		if current.Status != "RUNNING" {
+			slog.Debug("Managed instance is not RUNNING, skipping it", "instance", instance.String(), "status", current.Status)
+			continue
+		}
+
+		lock, err := acquireSuspendLock(ctx, instance.Name)
+		if errors.Is(err, errSuspendLocked) {
+			slog.Info("Another controller is suspending the instance, leaving it to them", "instance", instance.String(), "error", err)
+			continue
+		} else if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", instance, err))
+			continue
+		}
+		operation, _, err := suspendOrStop(ctx, service, instance)
+		lock.release(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: failed to suspend instance: %w", instance, err))
+			continue
+		}
+		pending = append(pending, pendingOperation{instance: instance, operation: operation})
+	}
+
+	// Every suspend is issued before waiting on any, so they run side by side
+	for _, p := range pending {
+		done, err := waitForOperation(ctx, zoneOperations{service: service}, p.instance.Project, p.instance.Zone, p.operation)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.instance, err))
+			continue
+		}
+		if warnings := operationWarnings(done); len(warnings) > 0 {
+			slog.Warn("Suspended managed instance with warnings", "instance", p.instance.String(), "warnings", warnings)
+		} else {
+			slog.Info("Suspended managed instance", "instance", p.instance.String())
+		}
+
+		if config().VerifySuspendState {
+			if err := verifySuspended(ctx, service, p.instance); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestParseInstanceList(t *testing.T) {
+	cfg := setupTestConfig()
+
+	instances, err := parseInstanceList(cfg, `
+# workers
+worker-1
+projects/other-project/zones/us-east1-b/instances/worker-2
+https://www.googleapis.com/compute/v1/projects/test-project/zones/test-zone/instances/test-instance
+worker-1
+`)
+	if err != nil {
+		t.Fatalf("parseInstanceList: %v", err)
+	}
+
+	want := []managedInstance{
+		{Project: "test-project", Zone: "test-zone", Name: "worker-1"},
+		{Project: "other-project", Zone: "us-east1-b", Name: "worker-2"},
+	}
+	if len(instances) != len(want) {
+		t.Fatalf("Expected %v without this instance or duplicates, got %v", want, instances)
+	}
+	for i := range want {
+		if instances[i] != want[i] {
+			t.Fatalf("Expected %v, got %v", want, instances)
+		}
+	}
+
+	if _, err := parseInstanceList(cfg, "worker-1\nprojects/p/zones/z/instances/worker-1\n"); err == nil {
+		t.Fatal("Expected an error for a name listed in two zones")
+	}
+	if _, err := parseInstanceList(cfg, "projects/p/instances/missing-zone\n"); err == nil {
+		t.Fatal("Expected an error for an incomplete resource path")
+	}
+}
+
+func TestInstanceListRefresh(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+	defer func() { managedInstances = nil }()
+	defer syncInstanceStates(nil)
+
+	updateConfig(func(cfg *Config) { cfg.InstanceListFile = filepath.Join(t.TempDir(), "instances") })
+	write := func(content string) {
+		if err := os.WriteFile(config().InstanceListFile, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("worker-1\nworker-2\n")
+	if err := loadInstanceList(); err != nil {
+		t.Fatalf("loadInstanceList: %v", err)
+	}
+	if got := currentManagedInstances(); len(got) != 2 {
+		t.Fatalf("Expected 2 managed instances, got %v", got)
+	}
+
+	write("worker-2\n")
+	if err := loadInstanceList(); err != nil {
+		t.Fatalf("loadInstanceList: %v", err)
+	}
+	if got := currentManagedInstances(); len(got) != 1 || got[0].Name != "worker-2" {
+		t.Fatalf("Expected only worker-2 to be managed, got %v", got)
+	}
+
+	// A broken file keeps the last good list
+	write("projects/broken\n")
+	if err := loadInstanceList(); err == nil {
+		t.Fatal("Expected an error for a broken instance list")
+	}
+	if got := currentManagedInstances(); len(got) != 1 {
+		t.Fatalf("Expected the previous list to be kept, got %v", got)
+	}
+}
+
+func TestSuspendManagedInstances(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+	defer func() { managedInstances = nil }()
+	defer syncInstanceStates(nil)
+
+	managedInstances = []managedInstance{
+		{Project: "test-project", Zone: "test-zone", Name: "running"},
+		{Project: "test-project", Zone: "test-zone", Name: "stopped"},
+		{Project: "test-project", Zone: "test-zone", Name: "broken"},
+	}
+
+	var mu sync.Mutex
+	var suspended []string
+	useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := path.Base(strings.TrimSuffix(r.URL.Path, "/suspend"))
+		switch {
+		case name == "broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		case strings.HasSuffix(r.URL.Path, "/suspend"):
+			mu.Lock()
+			suspended = append(suspended, name)
+			mu.Unlock()
+			writeComputeJSON(w, compute.Operation{Name: "op", Status: "DONE"})
+		case name == "running":
+			writeComputeJSON(w, compute.Instance{Name: name, Status: "RUNNING"})
+		default:
+			writeComputeJSON(w, compute.Instance{Name: name, Status: "TERMINATED"})
+		}
+	}))
+
+	err := suspendManagedInstances(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Fatalf("Expected the broken instance's error, got %v", err)
+	}
+	if len(suspended) != 1 || suspended[0] != "running" {
+		t.Fatalf("Expected only the running instance to be suspended, got %v", suspended)
+	}
+}
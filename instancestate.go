@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// instanceState is the inactivity state of one INSTANCE_LIST_FILE instance
+// Pings scoped to it with /ping?instance=<name> keep it online, and it is suspended on its own once its timer lapses
+type instanceState struct {
+	instance managedInstance
+	tracker  *ActivityTracker
+	timer    *time.Timer
+}
+
+// managedInstanceStatus is what /status reports for each instance from INSTANCE_LIST_FILE
+type managedInstanceStatus struct {
+	Name         string    `json:"name"`
+	Zone         string    `json:"zone"`
+	RequestCount int64     `json:"request_count"`
+	LastPing     time.Time `json:"last_ping"`
+}
+
+var (
+	instanceStatesMu sync.Mutex
+	// instanceStates holds each managed instance's state by name, the machine lightsout runs on keeps using tracker
+	instanceStates = make(map[string]*instanceState)
+)
+
+// syncInstanceStates starts tracking the instances that joined the list and forgets those that left it
+// Instances already tracked keep their state, so a refresh of the list doesn't restart their timers
+func syncInstanceStates(instances []managedInstance) {
+	instanceStatesMu.Lock()
+	defer instanceStatesMu.Unlock()
+
+	for _, instance := range instances {
+		if state, ok := instanceStates[instance.Name]; ok {
+			state.instance = instance
+			continue
+		}
+
+		now := time.Now()
+		state := &instanceState{
+			instance: instance,
+			tracker: &ActivityTracker{
+				lastPing:     now,
+				lastActivity: now,
+				startedAt:    now,
+			},
+		}
+		instanceStates[instance.Name] = state
+		state.resetTimer()
+	}
+
+	for name, state := range instanceStates {
+		if !slices.ContainsFunc(instances, func(i managedInstance) bool { return i.Name == name }) {
+			if state.timer != nil {
+				state.timer.Stop()
+			}
+			delete(instanceStates, name)
+		}
+	}
+}
+
+// resetTimer restarts the instance's inactivity timer, the caller must hold instanceStatesMu
+func (s *instanceState) resetTimer() {
+	if s.timer != nil {
+		s.timer.Stop()
+	}
+
+	name := s.instance.Name
+	s.timer = time.AfterFunc(inactivityTimeout(), func() { suspendIdleInstance(name) })
+}
+
+// suspendIdleInstance suspends a managed instance whose own inactivity timer lapsed
+func suspendIdleInstance(name string) {
+	instanceStatesMu.Lock()
+	state, ok := instanceStates[name]
+	if !ok {
+		// Removed from the list while the timer was firing
+		instanceStatesMu.Unlock()
+		return
+	}
+	instance := state.instance
+	state.tracker.mu.RLock()
+	idle := time.Since(state.tracker.lastPing)
+	state.tracker.mu.RUnlock()
+	instanceStatesMu.Unlock()
+
+	// A ping may have raced the timer, it has already started a new one
+	if idle < inactivityTimeout() {
+		return
+	}
+	// Check again after another window, keep-online may be switched off by then
+	if keepOnline() {
+		instanceStatesMu.Lock()
+		if instanceStates[name] == state {
+			state.resetTimer()
+		}
+		instanceStatesMu.Unlock()
+		return
+	}
+
+	slog.Info("Instance inactivity timeout reached, suspending it",
+		"instance", instance.String(),
+		"idle_seconds", int(idle.Seconds()))
+	recordDecision("suspend", "inactivity timeout for "+name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), suspendTimeout)
+	defer cancel()
+
+	if err := suspendInstances(ctx, []managedInstance{instance}); err != nil {
+		slog.Error("Failed to suspend instance", "instance", instance.String(), "error", err)
+	}
+}
+
+// pingInstance records a ping scoped to a managed instance, restarting its timer rather than ours
+// It reports false if no instance of that name is managed
+func pingInstance(name string) bool {
+	instanceStatesMu.Lock()
+	defer instanceStatesMu.Unlock()
+
+	state, ok := instanceStates[name]
+	if !ok {
+		return false
+	}
+
+	now := time.Now()
+	state.tracker.mu.Lock()
+	state.tracker.lastPing = now
+	state.tracker.lastActivity = now
+	state.tracker.requestCount++
+	state.tracker.mu.Unlock()
+
+	state.resetTimer()
+	return true
+}
+
+// instancePingHandler serves /ping?instance=<name>, a ping for this machine's own name falls through to the usual tracker
+func instancePingHandler(w http.ResponseWriter, r *http.Request, name string) {
+	if !pingInstance(name) {
+		http.Error(w, "Unknown instance "+name, http.StatusNotFound)
+		return
+	}
+
+	slog.Info("Ping request received",
+		"remote_addr", r.RemoteAddr,
+		"user_agent", r.UserAgent(),
+		"instance", name,
+		"timer_reset", true)
+
+	writePingResult(w, r, pingResult{Counted: true})
+}
+
+// managedInstanceStatuses returns the state of every managed instance, sorted by name
+func managedInstanceStatuses() []managedInstanceStatus {
+	instanceStatesMu.Lock()
+	defer instanceStatesMu.Unlock()
+
+	statuses := make([]managedInstanceStatus, 0, len(instanceStates))
+	for _, state := range instanceStates {
+		state.tracker.mu.RLock()
+		statuses = append(statuses, managedInstanceStatus{
+			Name:         state.instance.Name,
+			Zone:         state.instance.Zone,
+			RequestCount: state.tracker.requestCount,
+			LastPing:     state.tracker.lastPing,
+		})
+		state.tracker.mu.RUnlock()
+	}
+	slices.SortFunc(statuses, func(a, b managedInstanceStatus) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+	return statuses
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestPingScopedToInstance(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+	defer syncInstanceStates(nil)
+
+	syncInstanceStates([]managedInstance{{Project: "test-project", Zone: "test-zone", Name: "worker-1"}})
+
+	w := httptest.NewRecorder()
+	pingHandler(w, httptest.NewRequest("GET", "/ping?instance=worker-1", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d", w.Code)
+	}
+
+	statuses := managedInstanceStatuses()
+	if len(statuses) != 1 || statuses[0].RequestCount != 1 {
+		t.Fatalf("Expected the ping to count for worker-1, got %+v", statuses)
+	}
+	if currentStatus().RequestCount != 0 {
+		t.Fatal("A ping for another instance should not count for this one")
+	}
+
+	w = httptest.NewRecorder()
+	pingHandler(w, httptest.NewRequest("GET", "/ping?instance=unknown", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status 404 for an unknown instance, got %d", w.Code)
+	}
+
+	// Our own name is the usual ping
+	w = httptest.NewRecorder()
+	pingHandler(w, httptest.NewRequest("GET", "/ping?instance=test-instance", nil))
+	if w.Code != http.StatusOK || currentStatus().RequestCount != 1 {
+		t.Fatalf("Expected a ping for this instance to count, got %d with %d requests", w.Code, currentStatus().RequestCount)
+	}
+}
+
+func TestSyncInstanceStatesKeepsExistingState(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+	defer syncInstanceStates(nil)
+
+	worker1 := managedInstance{Project: "test-project", Zone: "test-zone", Name: "worker-1"}
+	worker2 := managedInstance{Project: "test-project", Zone: "test-zone", Name: "worker-2"}
+
+	syncInstanceStates([]managedInstance{worker1, worker2})
+	pingInstance("worker-1")
+
+	syncInstanceStates([]managedInstance{worker1})
+	statuses := managedInstanceStatuses()
+	if len(statuses) != 1 || statuses[0].Name != "worker-1" || statuses[0].RequestCount != 1 {
+		t.Fatalf("Expected worker-1 to keep its state and worker-2 to be dropped, got %+v", statuses)
+	}
+	if pingInstance("worker-2") {
+		t.Fatal("A removed instance should no longer accept pings")
+	}
+}
+
+func TestIdleInstanceSuspendedOnItsOwn(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+	defer syncInstanceStates(nil)
+
+	var suspended atomic.Value
+	suspended.Store("")
+	useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if name, ok := strings.CutSuffix(r.URL.Path, "/suspend"); ok {
+			suspended.Store(name[strings.LastIndex(name, "/")+1:])
+			writeComputeJSON(w, compute.Operation{Name: "op", Status: "DONE"})
+			return
+		}
+		writeComputeJSON(w, compute.Instance{Status: "RUNNING"})
+	}))
+
+	syncInstanceStates([]managedInstance{
+		{Project: "test-project", Zone: "test-zone", Name: "idle"},
+		{Project: "test-project", Zone: "test-zone", Name: "busy"},
+	})
+
+	// busy was just pinged, so its timer lapsing early is ignored
+	suspendIdleInstance("busy")
+	if got := suspended.Load(); got != "" {
+		t.Fatalf("Expected no suspend for a recently pinged instance, got %q", got)
+	}
+
+	instanceStatesMu.Lock()
+	instanceStates["idle"].tracker.lastPing = time.Now().Add(-time.Hour)
+	instanceStatesMu.Unlock()
+
+	suspendIdleInstance("idle")
+	if got := suspended.Load(); got != "idle" {
+		t.Fatalf("Expected the idle instance to be suspended, got %q", got)
+	}
+	if mockGCP.WasSuspendCalled() {
+		t.Fatal("This instance should not be suspended along with an idle managed one")
+	}
+}
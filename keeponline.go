@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+var keepOnlineReports = &counter{
+	name: "lightsout_keep_online_reports_total",
+	help: "KEEP_ONLINE_LOG_INTERVAL reports that keep-online is deliberately stopping suspends.",
+}
+
+// runKeepOnlineReport logs every KEEP_ONLINE_LOG_INTERVAL while keep-online is on until ctx is cancelled,
+// so a machine that is pinned on purpose can be told apart from one whose timer broke
+func runKeepOnlineReport(ctx context.Context) {
+	interval := config().KeepOnlineLogInterval
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reportKeepOnline()
+		}
+	}
+}
+
+func reportKeepOnline() {
+	if !keepOnline() {
+		return
+	}
+
+	tracker.mu.RLock()
+	uptime := time.Since(tracker.startedAt)
+	idle := time.Since(tracker.lastActivity)
+	tracker.mu.RUnlock()
+
+	keepOnlineReports.inc()
+	slog.Info("Keep-online is active, this machine will not be suspended",
+		"uptime_seconds", int(uptime.Seconds()),
+		"idle_seconds", int(idle.Seconds()))
+}
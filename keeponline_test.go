@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestKeepOnlineReport(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		updateConfig(func(cfg *Config) { cfg.KeepOnlineLogInterval = time.Hour })
+		before := keepOnlineReports.value.Load()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go runKeepOnlineReport(ctx)
+
+		// Nothing to report while the timer is running
+		time.Sleep(time.Hour + time.Second)
+		synctest.Wait()
+		if got := keepOnlineReports.value.Load() - before; got != 0 {
+			t.Fatalf("Expected no reports without keep-online, got %d", got)
+		}
+
+		setKeepOnline(true)
+		time.Sleep(2 * time.Hour)
+		synctest.Wait()
+		if got := keepOnlineReports.value.Load() - before; got != 2 {
+			t.Fatalf("Expected a report per interval while kept online, got %d", got)
+		}
+	})
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+var (
+	suspendDecisionMu sync.Mutex
+	// suspendDecidedAt is when the timer behind the suspend under way fired, zero when no timer-driven suspend is under way
+	suspendDecidedAt time.Time
+
+	suspendDecisionLatency = newHistogram("lightsout_suspend_decision_latency_seconds",
+		"Seconds between a timer deciding to suspend and Instances.Suspend being called, spent on activity checks, hooks and other preflight work.",
+		[]float64{0.5, 1, 2, 5, 10, 30, 60, 120, 300})
+)
+
+// markSuspendDecision notes that the timer that fired at firedAt is going ahead with a suspend
+func markSuspendDecision(firedAt time.Time) {
+	suspendDecisionMu.Lock()
+	defer suspendDecisionMu.Unlock()
+
+	suspendDecidedAt = firedAt
+}
+
+// clearSuspendDecision forgets the mark once the suspend it was for is over, however it went
+func clearSuspendDecision() {
+	markSuspendDecision(time.Time{})
+}
+
+// observeSuspendDecisionLatency is called right before Instances.Suspend and records how long it took to get there
+// since the timer fired. Suspends no timer asked for, like a manual one, have nothing to measure
+func observeSuspendDecisionLatency() {
+	suspendDecisionMu.Lock()
+	firedAt := suspendDecidedAt
+	suspendDecidedAt = time.Time{}
+	suspendDecisionMu.Unlock()
+
+	if firedAt.IsZero() {
+		return
+	}
+
+	latency := time.Since(firedAt)
+	suspendDecisionLatency.observe(latency.Seconds())
+	slog.Info("Issuing suspend", "decision_latency_ms", latency.Milliseconds())
+
+	seconds := latency.Seconds()
+	recordDecisionLatency("suspend_issued", "Instances.Suspend called", &seconds)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestSuspendDecisionLatency(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	origLatency := suspendDecisionLatency
+	suspendDecisionLatency = newHistogram("lightsout_suspend_decision_latency_seconds", "test", []float64{1, 5})
+	defer func() { suspendDecisionLatency = origLatency }()
+
+	useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && strings.HasSuffix(r.URL.Path, "/instances/test-instance"):
+			writeComputeJSON(w, compute.Instance{Name: "test-instance", Status: "RUNNING"})
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/instances/test-instance/suspend"):
+			writeComputeJSON(w, compute.Operation{Name: "op-1", Status: "RUNNING"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	// A suspend no timer asked for has nothing to measure
+	if _, _, err := suspendMachine(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if suspendDecisionLatency.count != 0 {
+		t.Fatalf("Expected no latency without a timer, got %d observations", suspendDecisionLatency.count)
+	}
+
+	markSuspendDecision(time.Now().Add(-3 * time.Second))
+	if _, _, err := suspendMachine(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if suspendDecisionLatency.count != 1 || suspendDecisionLatency.sum < 3 {
+		t.Fatalf("Expected one observation of about 3s, got %d totalling %v", suspendDecisionLatency.count, suspendDecisionLatency.sum)
+	}
+
+	tracker.mu.RLock()
+	last := tracker.decisions[len(tracker.decisions)-1]
+	tracker.mu.RUnlock()
+	if last.Action != "suspend_issued" || last.LatencySeconds == nil || *last.LatencySeconds < 3 {
+		t.Fatalf("Expected a suspend_issued decision with the latency, got %+v", last)
+	}
+
+	// The mark is used up by the suspend it was for
+	if _, _, err := suspendMachine(t.Context()); err != nil {
+		t.Fatal(err)
+	}
+	if suspendDecisionLatency.count != 1 {
+		t.Fatalf("Expected the mark to be used once, got %d observations", suspendDecisionLatency.count)
+	}
+}
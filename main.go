@@ -2,126 +2,411 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
+	"mime"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
 	"os/signal"
-	"strconv"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
-
-	"golang.org/x/oauth2/google"
-	compute "google.golang.org/api/compute/v1"
-	"google.golang.org/api/option"
 )
 
-type Config struct {
-	Port              string
-	InactivityTimeout time.Duration
-	LibOpsKeepOnline  string
-	LogLevel          string
-	GoogleProjectID   string
-	GCEZone           string
-	GCEInstance       string
-}
-
 type ActivityTracker struct {
 	mu           sync.RWMutex
 	requestCount int64
 	lastPing     time.Time
+	// lastActivity is the most recent activity seen from pings or any activity source, the one baseline they all feed
+	lastActivity time.Time
+	score        activityScore
+	startedAt    time.Time
+	decisions    []decision
+	// resumedAt is when lightsout started or last saw the instance resume, lightsout_active_duration_seconds counts from it
+	resumedAt time.Time
+	// sourceLastSeen is the most recent activity each activity source has reported
+	sourceLastSeen map[string]time.Time
+	// instanceNotFound is set once the GCP API reports the instance no longer exists
+	instanceNotFound bool
+	// stopSchedule is set when GCP stops the instance on a schedule and we shouldn't suspend it ourselves
+	stopSchedule *instanceStopSchedule
+	// lastSuspendError is the most recent failed suspend, cleared by the next successful one
+	lastSuspendError *suspendError
+	// recentSuspends holds when each suspend in the last hour was attempted, for MAX_SUSPENDS_PER_HOUR
+	recentSuspends []time.Time
+	// recentPings holds the pings inside PING_THRESHOLD_WINDOW while PING_THRESHOLD is above 1
+	recentPings []time.Time
+	// suspendRetries counts the SUSPEND_RETRY_ATTEMPTS used so far
+	suspendRetries int
+	// warmupGrace is set after a resume with WARMUP_GRACE until the first timeout has been skipped
+	warmupGrace bool
+	// lastReconcile is what the last RECONCILE_INTERVAL check of the instance's status found
+	lastReconcile *reconcileResult
 }
 
 var (
-	config         *Config
 	tracker        *ActivityTracker
 	shutdownTimer  *time.Timer
 	shutdownMutex  sync.Mutex
 	serverShutdown = make(chan struct{})
+	// shutdownArmedAt is when the first phase of a two-phase timeout elapsed, zero when not armed
+	shutdownArmedAt time.Time
+	// shutdownTimerDue is when shutdownTimer fires, zero when it isn't running
+	shutdownTimerDue time.Time
+	// shutdownGeneration changes on every reset so a timer that fired late can tell it was superseded
+	shutdownGeneration uint64
 	// Dependency injection for testing - initialize later to avoid cycle
-	suspendFunc func() error
+	suspendFunc     func(ctx context.Context) error
+	activitySources []ActivitySource
+	// routes lists the registered endpoints so unknown paths can point operators at them
+	routes []string
+
+	activeDuration    *histogram
+	suspendsThrottled = &counter{
+		name: "lightsout_suspends_throttled_total",
+		help: "Suspends skipped because MAX_SUSPENDS_PER_HOUR was reached.",
+	}
+
+	// pingsInFlight counts the pings being handled, for MAX_CONCURRENT_PINGS
+	pingsInFlight atomic.Int64
+	pingsShed     = &counter{
+		name: "lightsout_pings_shed_total",
+		help: "Pings turned away with 429 because MAX_CONCURRENT_PINGS were already being handled.",
+	}
+
+	// ready is set once every server is listening, and cleared again on shutdown
+	ready atomic.Bool
+	// exitCode is what the server exits with once it shuts down, non-zero when the suspend that triggered it failed
+	exitCode atomic.Int32
 )
 
 func init() {
-	config = loadConfig()
+	cfg, err := loadConfig()
+	if err != nil {
+		slog.Error("Invalid configuration", "error", err)
+		os.Exit(exitConfigError)
+	}
+	currentConfig.Store(cfg)
 	tracker = &ActivityTracker{
-		lastPing: time.Now(),
+		lastPing:     time.Now(),
+		lastActivity: time.Now(),
+		startedAt:    time.Now(),
+		resumedAt:    time.Now(),
 	}
 	setupLogging()
 	// Initialize suspendFunc to avoid initialization cycle
 	suspendFunc = suspendInstance
+	activitySources = buildActivitySources()
+
+	activeDuration = newHistogram("lightsout_active_duration_seconds",
+		"Seconds between lightsout starting or the instance resuming and suspending the instance.",
+		config().ActiveDurationBuckets)
+	register(activeDuration)
+	register(suspendDecisionLatency)
+	register(&gaugeFunc{
+		name: "lightsout_suspend_last_error_timestamp_seconds",
+		help: "Unix time of the last failed suspend, 0 once a suspend succeeds.",
+		fn:   lastSuspendErrorTimestamp,
+	})
+	register(suspendsSucceeded)
+	register(suspendFailures)
+	register(suspendsThrottled)
+	register(pingsShed)
+	register(suspendRetries)
+	register(tokenRefreshes)
+	register(keepOnlineReports)
+	register(operationsWithWarnings)
+	register(tokenRefreshFailures)
+	register(preemptions)
+	register(suspendsCoalesced)
+	register(suspendStateMismatches)
+	register(suspendFallbackStops)
+	register(&gaugeFunc{
+		name: "lightsout_token_expiry_timestamp_seconds",
+		help: "Unix time the current GCP access token expires, 0 before one is fetched.",
+		fn:   tokenExpiryTimestamp,
+	})
+	registerTrackerMetrics()
 }
 
-func loadConfig() *Config {
-	return &Config{
-		Port:              getEnv("PORT", "8808"),
-		InactivityTimeout: getDurationEnv("INACTIVITY_TIMEOUT", 90) * time.Second,
-		LogLevel:          getEnv("LOG_LEVEL", "INFO"),
-		GoogleProjectID:   getEnv("GCP_PROJECT", ""),
-		GCEZone:           getEnv("GCP_ZONE", ""),
-		GCEInstance:       getEnv("GCP_INSTANCE_NAME", ""),
-		LibOpsKeepOnline:  getEnv("LIBOPS_KEEP_ONLINE", ""),
-	}
+// Log formats for LOG_FORMAT
+const (
+	logFormatText = "text"
+	logFormatGCP  = "gcp"
+)
+
+func setupLogging() {
+	slog.SetDefault(newLogger(os.Stdout, logLevel()))
+	// Admin actions are always logged, whatever LOG_LEVEL says
+	auditLog = newLogger(os.Stdout, slog.LevelInfo).With("audit", true)
 }
 
-func getEnv(key, defaultValue string) string {
-	if value := os.Getenv(key); value != "" {
-		return value
+func logLevel() slog.Level {
+	switch strings.ToUpper(config().LogLevel) {
+	case "DEBUG":
+		return slog.LevelDebug
+	case "WARN":
+		return slog.LevelWarn
+	case "ERROR":
+		return slog.LevelError
+	default:
+		return slog.LevelInfo
 	}
-	return defaultValue
 }
 
-func getDurationEnv(key string, defaultSeconds int) time.Duration {
-	if value := getEnv(key, ""); value != "" {
-		if seconds, err := strconv.Atoi(value); err == nil {
-			return time.Duration(seconds)
+// newLogger builds a logger tagged with the instance so logs from a fleet can be told apart
+func newLogger(w io.Writer, level slog.Level) *slog.Logger {
+	cfg := config()
+
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler = slog.NewTextHandler(w, opts)
+	if cfg.LogFormat == logFormatGCP {
+		opts.ReplaceAttr = gcpLogAttr
+		handler = slog.NewJSONHandler(w, opts)
+	}
+	logger := slog.New(handler)
+
+	var labels []any
+	for _, attr := range []slog.Attr{
+		slog.String("instance", cfg.GCEInstance),
+		slog.String("zone", cfg.GCEZone),
+		slog.String("project", cfg.GoogleProjectID),
+	} {
+		if attr.Value.String() != "" {
+			labels = append(labels, attr)
 		}
 	}
-	return time.Duration(defaultSeconds)
+
+	switch {
+	case len(labels) == 0:
+	case cfg.LogFormat == logFormatGCP:
+		// Cloud Logging turns this into entry labels that log-based metrics can filter and group on
+		logger = logger.With(slog.Group("logging.googleapis.com/labels", labels...))
+	default:
+		logger = logger.With(labels...)
+	}
+
+	return logger
 }
 
-func setupLogging() {
-	var level slog.Level
-	switch strings.ToUpper(config.LogLevel) {
-	case "DEBUG":
-		level = slog.LevelDebug
-	case "WARN":
-		level = slog.LevelWarn
-	case "ERROR":
-		level = slog.LevelError
+// gcpLogAttr renames slog's built-in fields to the ones Cloud Logging reads from JSON logs
+func gcpLogAttr(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+
+	switch a.Key {
+	case slog.LevelKey:
+		return slog.String("severity", gcpSeverity(a.Value.Any().(slog.Level)))
+	case slog.MessageKey:
+		a.Key = "message"
+	}
+	return a
+}
+
+// gcpSeverity maps a slog level to a Cloud Logging severity
+func gcpSeverity(level slog.Level) string {
+	switch {
+	case level < slog.LevelInfo:
+		return "DEBUG"
+	case level < slog.LevelWarn:
+		return "INFO"
+	case level < slog.LevelError:
+		return "WARNING"
 	default:
-		level = slog.LevelInfo
+		return "ERROR"
 	}
+}
 
-	opts := &slog.HandlerOptions{Level: level}
-	handler := slog.New(slog.NewTextHandler(os.Stdout, opts))
-	slog.SetDefault(handler)
+// missingGCPConfig returns the env vars that still need to be set before we can suspend
+func missingGCPConfig(cfg *Config) []string {
+	var missing []string
+	if cfg.GoogleProjectID == "" {
+		missing = append(missing, "GCP_PROJECT")
+	}
+	if cfg.GCEZone == "" {
+		missing = append(missing, "GCP_ZONE")
+	}
+	if cfg.GCEInstance == "" {
+		missing = append(missing, "GCP_INSTANCE_NAME")
+	}
+	return missing
 }
 
 func resetShutdownTimer() {
 	shutdownMutex.Lock()
 	defer shutdownMutex.Unlock()
 
+	timeout := inactivityTimeout()
+	startShutdownTimerLocked(timeout, shutdownDelay(timeout))
+}
+
+// extendShutdownTimer is what a ping does under PING_MODE=extend: it adds PING_EXTEND_INCREMENT to the time left,
+// up to PING_EXTEND_CAP, rather than starting a full window
+func extendShutdownTimer() {
+	cfg := config()
+
+	shutdownMutex.Lock()
+	defer shutdownMutex.Unlock()
+
+	timeout := inactivityTimeout()
+	limit := cfg.PingExtendCap
+	if limit <= 0 {
+		limit = timeout
+	}
+
+	// An armed shutdown is disarmed by activity, so the extension starts from nothing left
+	var remaining time.Duration
+	if !shutdownTimerDue.IsZero() && shutdownArmedAt.IsZero() {
+		remaining = max(time.Until(shutdownTimerDue), 0)
+	}
+	// Never take time away, the window may already be longer than the cap
+	startShutdownTimerLocked(timeout, max(remaining, min(remaining+cfg.PingExtendIncrement, limit)))
+}
+
+// startShutdownTimerLocked (re)starts the inactivity timer to fire after delay
+// Caller must hold shutdownMutex
+func startShutdownTimerLocked(timeout, delay time.Duration) {
+	// There is nothing left to suspend, or POST /shutdown is about to suspend
+	if isInstanceNotFound() || draining.Load() {
+		return
+	}
+
 	if shutdownTimer != nil {
 		shutdownTimer.Stop()
 	}
+	shutdownArmedAt = time.Time{}
+	shutdownGeneration++
+
+	// A fresh window gets a fresh set of SUSPEND_RETRY_ATTEMPTS
+	tracker.mu.Lock()
+	tracker.suspendRetries = 0
+	tracker.mu.Unlock()
+
+	generation := shutdownGeneration
+	shutdownTimerDue = time.Now().Add(delay)
+	shutdownTimer = time.AfterFunc(delay, func() {
+		// Give a freshly resumed machine one full window to receive work, even if it starts idle
+		if consumeWarmupGrace() {
+			slog.Info("Inactivity timeout reached, skipping it once after resume",
+				"timeout_seconds", int(timeout.Seconds()))
+			recordDecision("stay_online", "warmup grace after resume")
+			resetShutdownTimer()
+			return
+		}
+
+		if config().ArmedTimeout > 0 {
+			// No point waiting out the armed timeout for a workload that can't run anyway
+			if down, detail := dependencyDown(); down {
+				slog.Warn("Inactivity timeout reached and the dependency is down, skipping the armed timeout",
+					"dependency", detail)
+				initiateShutdown()
+				return
+			}
+			armShutdown(generation)
+			return
+		}
 
-	shutdownTimer = time.AfterFunc(config.InactivityTimeout, func() {
 		slog.Info("Inactivity timeout reached, initiating shutdown",
-			"timeout_seconds", int(config.InactivityTimeout.Seconds()))
+			"timeout_seconds", int(timeout.Seconds()))
+		initiateShutdown()
+	})
+
+	slog.Debug("Shutdown timer reset",
+		"timeout_seconds", int(timeout.Seconds()),
+		"delay_seconds", int(delay.Seconds()))
+}
+
+// armShutdown is the first phase of a two-phase timeout
+// Rather than suspending right away we wait ARMED_TIMEOUT more, and any activity in between disarms
+func armShutdown(generation uint64) {
+	cfg := config()
+
+	shutdownMutex.Lock()
+	defer shutdownMutex.Unlock()
+
+	// Activity came in while the timer was firing
+	if generation != shutdownGeneration {
+		return
+	}
+
+	armedTimeout := cfg.ArmedTimeout
+	shutdownArmedAt = time.Now()
+	shutdownTimerDue = shutdownArmedAt.Add(armedTimeout)
+	shutdownTimer = time.AfterFunc(armedTimeout, func() {
+		slog.Info("Armed timeout reached, initiating shutdown",
+			"armed_timeout_seconds", int(armedTimeout.Seconds()))
 		initiateShutdown()
 	})
 
-	slog.Debug("Shutdown timer reset", "timeout_seconds", int(config.InactivityTimeout.Seconds()))
+	slog.Warn("Inactivity timeout reached, shutdown armed",
+		"timeout_seconds", int(inactivityTimeout().Seconds()),
+		"armed_timeout_seconds", int(armedTimeout.Seconds()))
+	recordDecision("armed", "inactivity timeout")
+}
+
+// armedSince returns when the shutdown was armed, or the zero time
+func armedSince() time.Time {
+	shutdownMutex.Lock()
+	defer shutdownMutex.Unlock()
+
+	return shutdownArmedAt
+}
+
+// inactivityTimeout returns the current timeout, taken from TIMEOUT_SCHEDULE for today if it has one
+// It can be changed at runtime via PUT /timeout
+func inactivityTimeout() time.Duration {
+	cfg := config()
+
+	if timeout, ok := cfg.TimeoutSchedule.timeoutAt(time.Now()); ok {
+		return timeout
+	}
+	return cfg.InactivityTimeout
+}
+
+// setInactivityTimeout applies a new timeout and restarts the timer with it unless the machine is kept online
+// An explicit timeout replaces TIMEOUT_SCHEDULE until the config is reloaded
+func setInactivityTimeout(timeout time.Duration) {
+	updateConfig(func(c *Config) {
+		c.InactivityTimeout = timeout
+		c.TimeoutSchedule = nil
+	})
+
+	if !keepOnline() {
+		resetShutdownTimer()
+	}
+}
+
+// keepOnline reports whether auto-shutdown is disabled, which can be changed at runtime via CONTROL_FILE
+func keepOnline() bool {
+	return config().LibOpsKeepOnline
+}
+
+// setKeepOnline toggles auto-shutdown, stopping or starting the timer to match
+func setKeepOnline(enabled bool) {
+	updateConfig(func(c *Config) { c.LibOpsKeepOnline = enabled })
+
+	if enabled {
+		stopShutdownTimer()
+	} else {
+		resetShutdownTimer()
+	}
 }
 
 func stopShutdownTimer() {
 	shutdownMutex.Lock()
 	defer shutdownMutex.Unlock()
 
+	shutdownArmedAt = time.Time{}
+	shutdownTimerDue = time.Time{}
 	if shutdownTimer != nil {
 		shutdownTimer.Stop()
 		shutdownTimer = nil
@@ -129,138 +414,319 @@ func stopShutdownTimer() {
 	}
 }
 
-func getLastGitHubActionsActivity() (time.Time, error) {
-	cmd := exec.Command("docker", "logs", "--tail", "1", "github-actions-runner")
+// getLastGitHubActionsActivity reads the last log line of every GITHUB_RUNNER_CONTAINERS container at once
+// and returns the most recent activity among them. GHA_CHECK_TIMEOUT bounds all the reads together
+func getLastGitHubActionsActivity(ctx context.Context) (time.Time, error) {
+	cfg := config()
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.GHACheckTimeout)
+	defer cancel()
+
+	type logResult struct {
+		at  time.Time
+		err error
+	}
+	results := make(chan logResult, len(cfg.GitHubRunnerContainers))
+	for _, container := range cfg.GitHubRunnerContainers {
+		go func() {
+			at, err := containerLogActivity(ctx, container)
+			results <- logResult{at, err}
+		}()
+	}
+
+	var (
+		latest time.Time
+		errs   []error
+	)
+	for range cfg.GitHubRunnerContainers {
+		result := <-results
+		if result.err != nil {
+			errs = append(errs, result.err)
+		} else if result.at.After(latest) {
+			latest = result.at
+		}
+	}
+
+	if len(errs) == len(cfg.GitHubRunnerContainers) {
+		return time.Time{}, errors.Join(errs...)
+	} else if len(errs) > 0 {
+		// The containers we could read still count, one stuck runner shouldn't hide the others' activity
+		slog.Warn("Could not read some runner containers' logs", "error", errors.Join(errs...))
+	}
+	return latest, nil
+}
+
+// containerLogActivity reads the time of the last line the runner in container logged
+func containerLogActivity(ctx context.Context, container string) (time.Time, error) {
+	cmd := exec.CommandContext(ctx, dockerCommand, "logs", "--tail", "1", container)
+	// docker may leave the output pipe open after it is killed at the deadline
+	cmd.WaitDelay = time.Second
 	output, err := cmd.Output()
-	if err != nil {
-		return time.Time{}, fmt.Errorf("no github-actions-runner logs: %v", err)
+	if ctx.Err() != nil {
+		return time.Time{}, fmt.Errorf("%s logs: %w", container, ctx.Err())
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("no %s logs: %v", container, err)
 	}
 
 	line := strings.TrimSpace(string(output))
 	if line == "" {
-		return time.Time{}, fmt.Errorf("empty github-actions-runner logs")
+		return time.Time{}, fmt.Errorf("empty %s logs", container)
 	}
 
-	// Parse timestamp from the beginning of the log line
+	at, err := parseGitHubActionsTimestamp(line, time.Now())
+	if err == nil {
+		recordRunnerLogLine(line, at)
+	}
+	return at, err
+}
+
+// parseGitHubActionsTimestamp reads the time at the beginning of a runner log line, which has no date so today's is assumed
+func parseGitHubActionsTimestamp(line string, now time.Time) (time.Time, error) {
 	parts := strings.Split(line, ":")
 	if len(parts) >= 3 {
 		timeStr := parts[0] + ":" + parts[1] + ":" + parts[2]
 		if t, err := time.Parse("15:04:05", timeStr); err == nil {
 			// Add today's date
-			now := time.Now()
-			return time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC), nil
+			at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+			return futureActivity(at, now), nil
 		}
 	}
 
 	return time.Time{}, fmt.Errorf("could not parse github-actions timestamp")
 }
 
-func createComputeService(ctx context.Context) (*compute.Service, error) {
-	// Use Application Default Credentials (ADC)
-	// This will automatically use:
-	// 1. GOOGLE_APPLICATION_CREDENTIALS environment variable
-	// 2. GCE metadata server (when running on GCE)
-	// 3. gcloud CLI credentials
-	creds, err := google.FindDefaultCredentials(ctx, compute.ComputeScope)
-	if err != nil {
-		return nil, fmt.Errorf("failed to find default credentials: %w", err)
-	}
-
-	service, err := compute.NewService(ctx, option.WithCredentials(creds))
-	if err != nil {
-		return nil, fmt.Errorf("failed to create compute service: %w", err)
-	}
-
-	return service, nil
-}
-
-func suspendMachine() (*compute.Instance, error) {
-	ctx := context.Background()
-
-	slog.Info("Checking if machine is suspended",
-		"project", config.GoogleProjectID,
-		"zone", config.GCEZone,
-		"instance", config.GCEInstance)
+// What FUTURE_TIMESTAMPS does with activity from the future
+const (
+	// futureTimestampsNow counts it as activity happening right now
+	futureTimestampsNow = "now"
+	// futureTimestampsIgnore doesn't count it as activity at all
+	futureTimestampsIgnore = "ignore"
+)
 
-	// Create compute service with default credentials
-	service, err := createComputeService(ctx)
-	if err != nil {
-		return nil, fmt.Errorf("createComputeService: %v", err)
+// futureActivity handles activity timestamped after now, from clock skew between the container and the host
+// or a line logged just before midnight being given today's date, according to FUTURE_TIMESTAMPS
+// Left alone, such a timestamp would keep the machine online until the clock catches up with it
+func futureActivity(at, now time.Time) time.Time {
+	if !at.After(now) {
+		return at
 	}
 
-	// Get instance details
-	instance, err := service.Instances.Get(config.GoogleProjectID, config.GCEZone, config.GCEInstance).Context(ctx).Do()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get instance: %v", err)
-	}
+	policy := config().FutureTimestamps
+	slog.Warn("Activity timestamp is in the future, the clocks may be skewed",
+		"timestamp", at,
+		"ahead_seconds", int(at.Sub(now).Seconds()),
+		"future_timestamps", policy)
 
-	// If the machine is running, suspend it
-	if instance.Status == "RUNNING" {
-		slog.Info("Instance is RUNNING, suspending instance")
-		_, err := service.Instances.Suspend(config.GoogleProjectID, config.GCEZone, config.GCEInstance).Context(ctx).Do()
-		if err != nil {
-			return instance, fmt.Errorf("failed to suspend instance: %v", err)
-		}
-	} else {
-		slog.Info("Instance is not RUNNING, skipping suspension", "status", instance.Status)
+	if policy == futureTimestampsIgnore {
+		return time.Time{}
 	}
-
-	return instance, nil
+	return now
 }
 
-func suspendInstance() error {
+func suspendInstance(ctx context.Context) error {
 	slog.Info("Attempting to suspend instance directly via GCP API")
 
 	// Reset the timer before suspension to prevent immediate shutdown after wake-up
 	resetShutdownTimer()
 
-	_, err := suspendMachine()
+	// The other instances go first, once this one is suspended nothing is left to suspend them
+	if config().InstanceListFile != "" {
+		managedCtx, cancel := context.WithTimeout(ctx, suspendTimeout)
+		if err := suspendManagedInstances(managedCtx); err != nil {
+			slog.Error("Failed to suspend some managed instances, suspending this one anyway", "error", err)
+		}
+		cancel()
+	}
+
+	_, outcome, err := suspendMachine(ctx)
 	if err != nil {
-		return fmt.Errorf("failed to suspend machine: %v", err)
+		return fmt.Errorf("failed to suspend machine: %w", err)
 	}
 
-	slog.Info("Suspend request completed successfully")
+	slog.Info("Suspend request completed successfully", "outcome", outcome)
 	return nil
 }
 
 func initiateShutdown() {
+	cfg := config()
+
 	tracker.mu.RLock()
-	lastPing := tracker.lastPing
+	lastActivity := tracker.lastActivity
 	tracker.mu.RUnlock()
 
 	now := time.Now()
-	duration := now.Sub(lastPing)
-
-	// Check GitHub Actions as fallback
-	if lastGHA, err := getLastGitHubActionsActivity(); err == nil {
-		ghaDuration := now.Sub(lastGHA)
-		if ghaDuration < config.InactivityTimeout {
-			slog.Info("Staying online for GitHub Actions",
-				"gha_duration_seconds", int(ghaDuration.Seconds()))
-			// Reset timer for another round
+	duration := now.Sub(lastActivity)
+
+	// The timer is scheduled for when the score has been low long enough, but a ping may have raced it
+	if cfg.ActivityScoring && timeUntilBelowThreshold(now) > 0 {
+		slog.Info("Staying online, activity score above threshold",
+			"score", currentActivityScore(now),
+			"threshold", cfg.ActivityScoreThreshold)
+		recordDecision("stay_online", "activity score above threshold")
+		resetShutdownTimer()
+		return
+	}
+
+	// Suspending while GCP stops the instance on its schedule would race it
+	if inStopScheduleWindow(now) {
+		slog.Info("Staying online, GCP's instance schedule is stopping the instance")
+		recordDecision("stay_online", "gcp stop schedule")
+		resetShutdownTimer()
+		return
+	}
+
+	// Check the other activity sources as a fallback
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if source, sourceActivity, ok := activeSource(ctx, now); ok {
+		slog.Info("Staying online for activity source",
+			"source", source,
+			"idle_seconds", int(now.Sub(sourceActivity).Seconds()))
+		recordActivity(sourceActivity)
+		recordDecision("stay_online", source+" activity")
+		// Reset timer for another round
+		resetShutdownTimer()
+		return
+	}
+
+	// Make sure we don't suspend a GitHub Actions runner in the middle of a job
+	var runner *githubRunner
+	if cfg.GitHubToken != "" {
+		var err error
+		runner, err = checkGitHubRunnerIdle(ctx)
+		if errors.Is(err, errRunnerBusy) {
+			slog.Info("Staying online, GitHub runner is busy", "runner", runner.Name)
+			recordDecision("stay_online", "github runner busy")
 			resetShutdownTimer()
 			return
+		} else if err != nil {
+			if !cfg.GitHubSuspendOnUnknown {
+				slog.Warn("Staying online, could not determine GitHub runner state", "error", err)
+				recordDecision("stay_online", "github runner state unknown")
+				resetShutdownTimer()
+				return
+			}
+			slog.Warn("Could not determine GitHub runner state, suspending anyway", "error", err)
 		}
 	}
 
-	slog.Info("Proceeding with shutdown",
-		"ping_duration_seconds", int(duration.Seconds()))
+	reason := "inactivity timeout"
+	if cfg.DependencyHealthURL != "" {
+		_, detail := dependencyDown()
+		reason += ", " + detail
+	}
 
-	// Check if we have the required GCP configuration
-	if config.GoogleProjectID == "" || config.GCEZone == "" || config.GCEInstance == "" {
-		slog.Warn("Missing GCP configuration, cannot suspend",
-			"project", config.GoogleProjectID,
-			"zone", config.GCEZone,
-			"instance", config.GCEInstance)
-	} else {
-		if err := suspendFunc(); err != nil {
-			slog.Error("Failed to suspend instance", "error", err)
-		} else {
-			slog.Info("Suspend request sent successfully")
+	proceedWithSuspend(now, reason, runner, "ping_duration_seconds", int(duration.Seconds()))
+}
+
+// proceedWithSuspend suspends for reason once a timer has decided to, unless canary mode or the suspend throttle holds it back
+// now is when the timer fired, the time from then until the suspend call is measured
+// attrs describe why the timer decided to and are logged along with the reason
+func proceedWithSuspend(now time.Time, reason string, runner *githubRunner, attrs ...any) {
+	if effectiveSuspendMode(now) == suspendModeWarn {
+		slog.Warn("Canary mode, would suspend now", append(attrs, "reason", reason)...)
+		recordDecision("would_suspend", reason+" (canary)")
+		resetShutdownTimer()
+		return
+	}
+
+	if suspendThrottled(now) {
+		slog.Warn("Too many suspends in the last hour, staying online",
+			"max_suspends_per_hour", config().MaxSuspendsPerHour)
+		recordDecision("throttled", "max suspends per hour reached")
+		suspendsThrottled.inc()
+		resetShutdownTimer()
+		return
+	}
+
+	slog.Info("Proceeding with shutdown", append(attrs, "reason", reason)...)
+	markSuspendDecision(now)
+	_ = requestSuspend(reason, runner)
+	clearSuspendDecision()
+}
+
+// suspendAndShutdown suspends the instance and stops the HTTP server, regardless of activity
+// It returns why the suspend didn't happen, if it didn't
+func suspendAndShutdown(reason string, runner *githubRunner) error {
+	cfg := config()
+
+	// The most urgent reason comes first, and a preempted instance is going down whatever else asked
+	if strings.HasPrefix(reason, reasonPreempted) {
+		return preemptedShutdown(reason, runner)
+	}
+
+	if cfg.Provider == providerNoop {
+		return noopSuspend(reason)
+	}
+
+	// Without the GCP configuration there is nothing we can suspend, but shutting down would leave a running
+	// machine that no longer answers pings. Keep serving so the config can be fixed and pings still count
+	if missing := missingGCPConfig(cfg); len(missing) > 0 {
+		slog.Error("Missing GCP configuration, cannot suspend, staying online", "missing", missing)
+		recordDecision("skip_suspend", "missing gcp configuration")
+		resetShutdownTimer()
+		return errMissingGCPConfig
+	}
+
+	if reportOnly.Load() {
+		return reportOnlySuspend(reason)
+	}
+
+	// SHUTDOWN_TIMEOUT bounds the stages, the suspend, the webhooks and whatever is left for draining the servers
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	shutdownDeadline.Store(deadline.UnixNano())
+
+	if err := runShutdownStages(ctx, preSuspendStages(reason, runner)); err != nil {
+		// Leave the machine running and try again once it has been idle for another timeout
+		var aborted *stageAbortedError
+		errors.As(err, &aborted)
+		recordDecision("abort", aborted.stage+" failed")
+		resetShutdownTimer()
+		return err
+	}
+
+	recordDecision("suspend", reason)
+	recordSuspendAttempt(time.Now())
+	err := suspendFunc(ctx)
+	recordSuspendResult(err)
+	notifySuspendAfter(ctx, reason, err)
+	if errors.Is(err, errInstanceNotFound) {
+		// Retrying won't help, keep serving so /status can report it
+		slog.Error("Instance not found, it may have been deleted or moved. Stopping the inactivity timer", "error", err)
+		markInstanceNotFound()
+		stopShutdownTimer()
+		return err
+	} else if errors.Is(err, errSuspendLocked) {
+		// Another controller is suspending us right now, there is nothing left for us to do but wait
+		slog.Warn("Another controller holds the suspend lock, deferring the suspend", "error", err)
+		recordDecision("defer", "suspend lock held")
+		resetShutdownTimer()
+		return err
+	} else if err != nil {
+		slog.Error("Failed to suspend instance", "error", err)
+		if scheduleSuspendRetry() {
+			return err
 		}
+		exitCode.Store(int32(suspendExitCode(err)))
+	} else {
+		tracker.mu.RLock()
+		activeFor := time.Since(tracker.resumedAt)
+		tracker.mu.RUnlock()
+
+		activeDuration.observe(activeFor.Seconds())
+		slog.Info("Suspend request sent successfully", "active_seconds", int(activeFor.Seconds()))
 	}
 
-	// Signal server shutdown (protected by mutex to prevent race condition)
+	signalServerShutdown()
+	return err
+}
+
+// signalServerShutdown tells main to drain the servers and exit
+func signalServerShutdown() {
+	// Protected by mutex to prevent race condition
 	shutdownMutex.Lock()
 	defer shutdownMutex.Unlock()
 
@@ -272,66 +738,488 @@ func initiateShutdown() {
 	}
 }
 
+// ignoredPing reports whether a ping comes from monitoring traffic that shouldn't count as activity
+func ignoredPing(r *http.Request) bool {
+	userAgent := r.UserAgent()
+	for _, ignored := range config().IgnorePingUserAgents {
+		if strings.Contains(userAgent, ignored) {
+			return true
+		}
+	}
+	return false
+}
+
+// pingThresholdMet records a ping and reports whether PING_THRESHOLD pings have now arrived within PING_THRESHOLD_WINDOW
+// Caller must hold tracker.mu
+func pingThresholdMet(cfg *Config, now time.Time) bool {
+	if cfg.PingThreshold <= 1 {
+		return true
+	}
+
+	recent := tracker.recentPings[:0]
+	for _, t := range tracker.recentPings {
+		if now.Sub(t) < cfg.PingThresholdWindow {
+			recent = append(recent, t)
+		}
+	}
+	tracker.recentPings = append(recent, now)
+
+	return len(tracker.recentPings) >= cfg.PingThreshold
+}
+
+// What PING_MODE does to the inactivity timer
+const (
+	// pingModeReset starts a full inactivity timeout on every ping
+	pingModeReset = "reset"
+	// pingModeExtend adds PING_EXTEND_INCREMENT to the time left, up to PING_EXTEND_CAP
+	pingModeExtend = "extend"
+)
+
+// pingResult tells the caller whether its ping counted as activity and, if not, why
+type pingResult struct {
+	Counted bool   `json:"counted"`
+	Reason  string `json:"reason,omitempty"`
+}
+
 func pingHandler(w http.ResponseWriter, r *http.Request) {
-	tracker.mu.Lock()
-	tracker.lastPing = time.Now()
-	tracker.requestCount++
-	tracker.mu.Unlock()
+	// Under a flood the excess is turned away up front rather than queueing on tracker.mu
+	if limit := config().MaxConcurrentPings; limit > 0 {
+		if pingsInFlight.Add(1) > int64(limit) {
+			pingsInFlight.Add(-1)
+			pingsShed.inc()
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "Too many concurrent pings", http.StatusTooManyRequests)
+			return
+		}
+		defer pingsInFlight.Add(-1)
+	}
 
-	// Reset the shutdown timer
-	resetShutdownTimer()
+	if ignoredPing(r) {
+		slog.Debug("Ignoring ping from monitoring user agent",
+			"remote_addr", r.RemoteAddr,
+			"user_agent", r.UserAgent())
+		writePingResult(w, r, pingResult{Reason: "ignored: user-agent filtered"})
+		return
+	}
+
+	cfg := config()
+	if name := r.URL.Query().Get("instance"); name != "" && name != cfg.GCEInstance {
+		instancePingHandler(w, r, name)
+		return
+	}
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); r.Method == http.MethodPost && mediaType == "application/json" {
+		batchPingHandler(w, r)
+		return
+	}
+
+	result := countPing(cfg, time.Now())
 
 	slog.Info("Ping request received",
 		"remote_addr", r.RemoteAddr,
 		"user_agent", r.UserAgent(),
-		"timer_reset", true)
+		"timer_reset", result.Counted)
+
+	writePingResult(w, r, result)
+}
+
+// batchedActivity is one entry of a POST /ping batch
+type batchedActivity struct {
+	Source string `json:"source"`
+	// Timestamp is when the source was last active, defaults to now
+	Timestamp *time.Time `json:"ts"`
+}
+
+type batchedActivityResult struct {
+	Source     string    `json:"source"`
+	Timestamp  time.Time `json:"timestamp"`
+	TimerReset bool      `json:"timer_reset"`
+	// Reason says why an entry didn't count as a ping
+	Reason string `json:"reason,omitempty"`
+}
+
+// maxPingBatchBytes bounds a POST /ping body, /ping isn't behind an admin token
+const maxPingBatchBytes = 64 << 10
+
+// decodePingBatch reads a POST /ping batch, capped at maxPingBatchBytes
+// ADMIN_MAX_BODY_BYTES is for the token-protected endpoints, so the public /ping has its own limit
+func decodePingBatch(w http.ResponseWriter, r *http.Request) ([]batchedActivity, bool) {
+	decoder := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxPingBatchBytes))
+	decoder.DisallowUnknownFields()
+
+	var batch []batchedActivity
+	if err := decoder.Decode(&batch); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+			return nil, false
+		}
+		http.Error(w, "Invalid request body: "+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if decoder.More() {
+		http.Error(w, "Invalid request body: unexpected data after JSON array", http.StatusBadRequest)
+		return nil, false
+	}
+	return batch, true
+}
+
+// batchPingHandler records activity for several sources in one POST /ping, e.g. from an aggregator on the host
+// /ping isn't behind a token, so only the PING_SOURCES names are accepted and the whole batch counts as one ping,
+// however many entries are recent, so a single request can't meet PING_THRESHOLD by itself
+func batchPingHandler(w http.ResponseWriter, r *http.Request) {
+	batch, ok := decodePingBatch(w, r)
+	if !ok {
+		return
+	}
+	// Check every entry first so a bad one doesn't leave the batch half applied
+	cfg := config()
+	now := time.Now()
+	for i, entry := range batch {
+		if entry.Source == "" {
+			http.Error(w, fmt.Sprintf("Entry %d: source is required", i), http.StatusBadRequest)
+			return
+		}
+		if !slices.Contains(cfg.PingSources, entry.Source) {
+			http.Error(w, fmt.Sprintf("Entry %d: unknown source %q, it must be listed in PING_SOURCES", i, entry.Source), http.StatusBadRequest)
+			return
+		}
+		if entry.Timestamp != nil && entry.Timestamp.After(now) {
+			http.Error(w, fmt.Sprintf("Entry %d: ts must not be in the future", i), http.StatusBadRequest)
+			return
+		}
+	}
+
+	stale := pingResult{Reason: "ignored: older than the inactivity timeout"}
+	var ping *pingResult
+	results := make([]batchedActivityResult, 0, len(batch))
+	for _, entry := range batch {
+		at := now
+		if entry.Timestamp != nil {
+			at = *entry.Timestamp
+		}
+		recordSourceLastSeen(entry.Source, at)
+
+		result := stale
+		if now.Sub(at) < inactivityTimeout() {
+			if ping == nil {
+				counted := countPing(cfg, now)
+				ping = &counted
+			}
+			result = *ping
+		}
+		results = append(results, batchedActivityResult{
+			Source:     entry.Source,
+			Timestamp:  at,
+			TimerReset: result.Counted,
+			Reason:     result.Reason,
+		})
+	}
+	timerReset := ping != nil && ping.Counted
+
+	slog.Info("Batched activity received",
+		"remote_addr", r.RemoteAddr,
+		"entries", len(batch),
+		"timer_reset", timerReset)
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+// writePingResult answers "pong" as always, or the result as JSON for clients that accept it
+// The result is also in the X-Lightsout-Ping header so plain-text clients can see why a ping was ignored
+// Ignored pings still get a 200, health checkers are usually the ones being ignored
+func writePingResult(w http.ResponseWriter, r *http.Request, result pingResult) {
+	header := "counted"
+	if !result.Counted {
+		header = result.Reason
+	}
+	w.Header().Set("X-Lightsout-Ping", header)
+
+	if strings.Contains(r.Header.Get("Accept"), "application/json") {
+		writeJSON(w, http.StatusOK, result)
+		return
+	}
 
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusOK)
 	if _, err := w.Write([]byte("pong")); err != nil {
 		slog.Error("Failed to write ping response", "error", err)
-		http.Error(w, "Failed to write response", http.StatusInternalServerError)
-		return
 	}
 }
 
+// countPing records a ping at now, once PING_THRESHOLD allows, and resets or extends the shutdown timer
+func countPing(cfg *Config, now time.Time) pingResult {
+	result := pingResult{}
+	tracker.mu.Lock()
+	result.Counted = pingThresholdMet(cfg, now)
+	if result.Counted {
+		tracker.lastPing = now
+		tracker.lastActivity = now
+		tracker.requestCount++
+		if cfg.ActivityScoring {
+			recordScoredPing(now)
+		}
+	} else {
+		result.Reason = fmt.Sprintf("ignored: below ping threshold, %d of %d pings within %s",
+			len(tracker.recentPings), cfg.PingThreshold, cfg.PingThresholdWindow)
+	}
+	tracker.mu.Unlock()
+
+	// Reset the shutdown timer, or buy another increment of it
+	if result.Counted && cfg.PingMode == pingModeExtend {
+		extendShutdownTimer()
+	} else if result.Counted {
+		resetShutdownTimer()
+	}
+
+	return result
+}
+
 func healthHandler(w http.ResponseWriter, r *http.Request) {
+	// Health probes don't keep the machine online unless asked to, for checkers that probe /healthcheck instead of pinging
+	if cfg := config(); cfg.HealthcheckCountsAsActivity && !ignoredPing(r) {
+		result := countPing(cfg, time.Now())
+		slog.Debug("Healthcheck counted as activity",
+			"remote_addr", r.RemoteAddr,
+			"timer_reset", result.Counted)
+	}
+
 	w.Header().Set("Content-Type", "text/plain")
 	w.WriteHeader(http.StatusOK)
 }
 
-func main() {
-	slog.Info("Lightswitch starting",
-		"port", config.Port,
-		"inactivity_timeout", config.InactivityTimeout,
-		"keep_online", config.LibOpsKeepOnline == "yes")
+// startServers binds every server's address and only then starts serving, marking lightsout ready for /readyz
+// Handlers are all registered when the servers are built, so nothing is accepted before its route exists
+func startServers(servers []*http.Server) ([]net.Listener, error) {
+	var listeners []net.Listener
+	for _, server := range servers {
+		listener, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			for _, l := range listeners {
+				_ = l.Close()
+			}
+			return nil, fmt.Errorf("failed to listen on %s: %w", server.Addr, err)
+		}
+		listeners = append(listeners, listener)
+	}
 
-	// Check if this is a paid site that should stay online
-	if config.LibOpsKeepOnline != "yes" {
-		slog.Info("Starting inactivity timer", "timeout_seconds", int(config.InactivityTimeout.Seconds()))
-		resetShutdownTimer()
+	for i, server := range servers {
+		go func() {
+			slog.Info("HTTP server starting", "addr", listeners[i].Addr().String())
+			if err := server.Serve(listeners[i]); err != nil && err != http.ErrServerClosed {
+				slog.Error("HTTP server error", "addr", server.Addr, "error", err)
+			}
+		}()
 	}
 
-	// Setup HTTP handlers
-	http.HandleFunc("/ping", pingHandler)
-	http.HandleFunc("/healthcheck", healthHandler)
+	ready.Store(true)
+	return listeners, nil
+}
 
-	// Setup HTTP server
-	server := &http.Server{
-		Addr:              ":" + config.Port,
+// readyzHandler reports whether every listener is up, unlike /healthcheck which only says the process is alive
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain")
+	if !ready.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("not ready"))
+		return
+	}
+	_, _ = w.Write([]byte("ready"))
+}
+
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
 		ReadHeaderTimeout: 5 * time.Second,
 		ReadTimeout:       10 * time.Second,
 		WriteTimeout:      10 * time.Second,
 		IdleTimeout:       120 * time.Second,
 	}
+}
 
-	// Start server in goroutine
-	go func() {
-		slog.Info("HTTP server starting", "port", config.Port)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			slog.Error("HTTP server error", "error", err)
+// enableH2C lets clients speak HTTP/2 without TLS so frequent pingers can multiplex over one connection
+func enableH2C(server *http.Server) {
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	server.Protocols = &protocols
+}
+
+// newPublicMux builds the handler for PUBLIC_PORT, which serves nothing but the healthcheck
+func newPublicMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthcheck", healthHandler)
+	return mux
+}
+
+// newMux builds the handler for the private listener
+// Routes are registered on their own ServeMux rather than http.DefaultServeMux so nothing else in the process can collide with them
+func newMux() *http.ServeMux {
+	mux := http.NewServeMux()
+	routes = nil
+
+	// handle registers a handler and remembers its pattern for notFoundHandler
+	handle := func(pattern string, handler http.HandlerFunc) {
+		mux.HandleFunc(pattern, handler)
+		routes = append(routes, pattern)
+	}
+
+	handle("/ping", pingHandler)
+	handle("/healthcheck", healthHandler)
+	handle("GET /readyz", readyzHandler)
+	handle("GET /wait", waitHandler)
+	handle("GET /status", statusHandler)
+	handle("GET /next-suspend", nextSuspendHandler)
+	handle("GET /metrics", metricsHandler)
+	handle("GET /sources", sourcesHandler)
+	handle("GET /instance", instanceHandler)
+
+	// Admin endpoints are only exposed when a token has been configured
+	if len(adminTokens(config())) > 0 {
+		handle("POST /suspend", requireAdmin(scopeSuspend, suspendHandler))
+		handle("POST /cancel-suspend", requireAdmin(scopeCancelSuspend, cancelSuspendHandler))
+		handle("PUT /timeout", requireAdmin(scopeTimeout, timeoutHandler))
+		handle("POST /test-hook", requireAdmin(scopeTestHook, testHookHandler))
+		handle("POST /shutdown", requireAdmin(scopeShutdown, shutdownHandler))
+		handle("POST /activity", requireAdmin(scopeActivity, activityHandler))
+		handle("GET /debug/bundle", requireAdmin(scopeDebug, debugBundleHandler))
+		handle("POST /sources/{name}/disable", requireAdmin(scopeSources, disableSourceHandler))
+		handle("POST /sources/{name}/enable", requireAdmin(scopeSources, enableSourceHandler))
+
+		// newMux only serves the private listener, the public port never gets more than /healthcheck
+		if config().EnablePprof {
+			registerPprof(handle)
 		}
-	}()
+	}
+
+	// Anything else gets a list of what is available
+	mux.HandleFunc("/", notFoundHandler)
+
+	return mux
+}
+
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusNotFound, map[string]any{
+		"error":     "not found",
+		"path":      r.URL.Path,
+		"endpoints": routes,
+	})
+}
+
+func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
+	// Listeners and background loops are set up from the config at startup, SIGHUP doesn't change them
+	cfg := config()
+
+	slog.Info("Lightswitch starting",
+		"port", cfg.Port,
+		"inactivity_timeout", inactivityTimeout(),
+		"keep_online", keepOnline())
+	logEffectiveConfig(cfg)
+	logProvider(cfg)
+	logExitCodes()
+
+	if err := loadState(); err != nil {
+		slog.Warn("Failed to load state snapshot", "error", err)
+	}
+
+	if cfg.RespectInstanceSchedule && cfg.GoogleProjectID != "" && cfg.GCEZone != "" && cfg.GCEInstance != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		checkInstanceSchedule(ctx)
+		cancel()
+	}
+
+	if cfg.PermissionCheck && cfg.Provider == providerGCP && len(missingGCPConfig(cfg)) == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		checkSuspendPermissions(ctx)
+		cancel()
+	}
+
+	if cfg.EnablePprof && len(adminTokens(cfg)) == 0 {
+		slog.Warn("ENABLE_PPROF needs an admin token with the debug scope, profiling is disabled")
+	}
+
+	if cfg.InstanceListFile != "" {
+		if err := loadInstanceList(); err != nil {
+			slog.Error("Failed to load instance list", "path", cfg.InstanceListFile, "error", err)
+		}
+	}
+
+	// Check if this is a paid site that should stay online
+	if !keepOnline() {
+		slog.Info("Starting inactivity timer", "timeout_seconds", int(inactivityTimeout().Seconds()))
+		resetShutdownTimer()
+	}
+
+	// Background loops run until shutdown
+	bgCtx, stopBackground := context.WithCancel(context.Background())
+	var background sync.WaitGroup
+
+	background.Go(func() { watchReloadSignal(bgCtx) })
+	if cfg.HeartbeatURL != "" {
+		background.Go(func() { runHeartbeat(bgCtx) })
+	}
+	if cfg.ControlFile != "" {
+		background.Go(func() { watchControlFile(bgCtx) })
+	}
+	if cfg.ReconcileInterval > 0 {
+		background.Go(func() { runReconcile(bgCtx) })
+	}
+	if cfg.KeepOnlineLogInterval > 0 {
+		background.Go(func() { runKeepOnlineReport(bgCtx) })
+	}
+	if cfg.InstanceListFile != "" && cfg.InstanceListRefresh > 0 {
+		background.Go(func() { watchInstanceList(bgCtx) })
+	}
+	if cfg.WatchPreemption {
+		background.Go(func() { watchPreemption(bgCtx) })
+	}
+	if cfg.PostJobTimeout > 0 && cfg.PostJobPollInterval > 0 {
+		background.Go(func() { watchRunnerJobs(bgCtx) })
+	}
+	if cfg.StatsDAddr != "" && cfg.StatsDInterval > 0 {
+		background.Go(func() { runStatsD(bgCtx) })
+	}
+	background.Go(func() { watchResume(bgCtx) })
+	if cfg.RespectInstanceSchedule && cfg.InstanceScheduleRefresh > 0 && len(missingGCPConfig(cfg)) == 0 {
+		background.Go(func() { watchInstanceSchedule(bgCtx) })
+	}
+
+	// The control surface listens on PRIVATE_ADDRESS when set, otherwise on every interface like before.
+	// With PUBLIC_PORT, PRIVATE_ADDRESS defaults to loopback
+	privateAddr := ":" + cfg.Port
+	if cfg.PrivateAddress != "" {
+		privateAddr = cfg.PrivateAddress
+	}
+	privateServer := newHTTPServer(privateAddr, newMux())
+	if cfg.HTTP2Cleartext {
+		enableH2C(privateServer)
+	}
+	servers := []*http.Server{privateServer}
+
+	// Optionally expose only the healthcheck on a public port
+	if cfg.PublicPort != "" {
+		servers = append(servers, newHTTPServer(":"+cfg.PublicPort, newPublicMux()))
+	}
+
+	// HTTP/3 shares the private port over UDP, it is started first so /readyz covers it too
+	var quicListener *http3Listener
+	if cfg.HTTP3 {
+		var err error
+		quicListener, err = startHTTP3(privateAddr, privateServer.Handler)
+		if err != nil {
+			slog.Error("Failed to start HTTP/3 server", "error", err)
+			os.Exit(exitConfigError)
+		}
+	}
+
+	if _, err := startServers(servers); err != nil {
+		slog.Error("Failed to start HTTP servers", "error", err)
+		os.Exit(exitConfigError)
+	}
 
 	// Wait for shutdown signal or internal shutdown
 	sigChan := make(chan os.Signal, 1)
@@ -345,17 +1233,53 @@ func main() {
 	}
 
 	slog.Info("Gracefully shutting down...")
+	ready.Store(false)
 
 	// Stop the shutdown timer
 	stopShutdownTimer()
+	_ = cancelPendingSuspend("")
 
-	// Shutdown HTTP server
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// SHUTDOWN_TIMEOUT bounds everything left from here: background loops and draining the servers
+	// After a suspend only what is left of its SHUTDOWN_TIMEOUT remains
+	ctx, cancel := shutdownContext()
 	defer cancel()
 
-	if err := server.Shutdown(ctx); err != nil {
-		slog.Error("Server shutdown error", "error", err)
+	// Stop background loops
+	stopBackground()
+	stopped := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		slog.Warn("Background loops did not stop within the shutdown timeout",
+			"shutdown_timeout_seconds", int(cfg.ShutdownTimeout.Seconds()))
+	}
+
+	// Shutdown HTTP servers
+	var wg sync.WaitGroup
+	for _, server := range servers {
+		wg.Go(func() {
+			if err := server.Shutdown(ctx); err != nil {
+				slog.Error("Server shutdown error", "addr", server.Addr, "error", err)
+			}
+		})
 	}
+	if quicListener != nil {
+		wg.Go(func() {
+			if err := quicListener.Shutdown(ctx); err != nil {
+				slog.Error("HTTP/3 server shutdown error", "addr", quicListener.Addr().String(), "error", err)
+			}
+		})
+	}
+	wg.Wait()
 
-	slog.Info("Lightswitch shutdown complete")
+	code := int(exitCode.Load())
+	slog.Info("Lightswitch shutdown complete", "exit_code", code)
+	if code != exitOK {
+		cancel()
+		os.Exit(code)
+	}
 }
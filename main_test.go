@@ -1,10 +1,16 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"sync"
 	"testing"
 	"testing/synctest"
@@ -34,57 +40,83 @@ var mockGCP = &MockGCPAPI{}
 
 func setupTestConfig() *Config {
 	return &Config{
-		Port:              "8808",
-		InactivityTimeout: 90 * time.Second,
-		LogLevel:          "ERROR",
-		GoogleProjectID:   "test-project",
-		GCEZone:           "test-zone",
-		GCEInstance:       "test-instance",
-		LibOpsKeepOnline:  "",
+		Port:                 "8808",
+		InactivityTimeout:    90 * time.Second,
+		LogLevel:             "ERROR",
+		GoogleProjectID:      "test-project",
+		GCEZone:              "test-zone",
+		GCEInstance:          "test-instance",
+		LibOpsKeepOnline:     false,
+		AdminToken:           "test-admin-token",
+		AdminMaxBodyBytes:    4096,
+		ManualSuspendDelay:   30 * time.Second,
+		MaxInactivityTimeout: time.Hour,
+		MinInactivityTimeout: 5 * time.Second,
+		ShutdownTimeout:      10 * time.Second,
 	}
 }
 
 func setupTestEnvironment() func() {
 	// Save original globals
-	origConfig := config
+	origConfig := config()
 	origTracker := tracker
 	origShutdownTimer := shutdownTimer
 	origServerShutdown := serverShutdown
 	origSuspendFunc := suspendFunc
+	origActivitySources := activitySources
 
 	// Set test config and tracker
-	config = setupTestConfig()
+	currentConfig.Store(setupTestConfig())
 	tracker = &ActivityTracker{
-		lastPing: time.Now(),
+		lastPing:     time.Now(),
+		lastActivity: time.Now(),
+		startedAt:    time.Now(),
+		resumedAt:    time.Now(),
 	}
 	shutdownTimer = nil
 	serverShutdown = make(chan struct{})
 	suspendFunc = mockSuspendInstance
+	activitySources = nil
 	mockGCP.Reset()
 
-	// Setup test logging (suppress output)
-	opts := &slog.HandlerOptions{Level: slog.LevelError}
-	handler := slog.New(slog.NewTextHandler(io.Discard, opts))
-	slog.SetDefault(handler)
+	setupTestLogging()
 
 	// Return cleanup function
 	return func() {
 		// Stop any running shutdown timer first
 		stopShutdownTimer()
+		_ = cancelPendingSuspend("")
+		draining.Store(false)
+		resetInstanceCache()
+		resetPostJobTimer()
+		resetCalendarCache()
+		clearSuspendDecision()
+		resetDisabledSources()
+		reportOnly.Store(false)
+		shutdownDeadline.Store(0)
 
 		// Protect global variable assignments with mutex to prevent race condition
 		shutdownMutex.Lock()
-		config = origConfig
+		currentConfig.Store(origConfig)
 		tracker = origTracker
 		shutdownTimer = origShutdownTimer
 		serverShutdown = origServerShutdown
 		suspendFunc = origSuspendFunc
+		activitySources = origActivitySources
 		shutdownMutex.Unlock()
 	}
 }
 
+// setupTestLogging suppresses log output
+func setupTestLogging() {
+	opts := &slog.HandlerOptions{Level: slog.LevelError}
+	handler := slog.New(slog.NewTextHandler(io.Discard, opts))
+	slog.SetDefault(handler)
+	auditLog = slog.New(slog.DiscardHandler)
+}
+
 // Mock suspend function for testing
-func mockSuspendInstance() error {
+func mockSuspendInstance(context.Context) error {
 	mockGCP.mu.Lock()
 	mockGCP.suspendCalled = true
 	mockGCP.mu.Unlock()
@@ -105,7 +137,7 @@ func TestSuspensionAfterInactivityTimeout(t *testing.T) {
 		}
 
 		// Advance time by the inactivity timeout period using fake clock
-		time.Sleep(config.InactivityTimeout + 100*time.Millisecond)
+		time.Sleep(config().InactivityTimeout + 100*time.Millisecond)
 
 		// Verify suspension was called
 		if !mockGCP.WasSuspendCalled() {
@@ -123,7 +155,7 @@ func TestTimerResetOnPingRequest(t *testing.T) {
 		resetShutdownTimer()
 
 		// Wait for almost the timeout period
-		time.Sleep(config.InactivityTimeout - 1*time.Second)
+		time.Sleep(config().InactivityTimeout - 1*time.Second)
 
 		// Make a ping request to reset the timer
 		req := httptest.NewRequest("GET", "/ping", nil)
@@ -147,7 +179,7 @@ func TestTimerResetOnPingRequest(t *testing.T) {
 		}
 
 		// Wait for the full timeout period after the ping
-		time.Sleep(config.InactivityTimeout)
+		time.Sleep(config().InactivityTimeout)
 
 		// Now suspension should be called
 		if !mockGCP.WasSuspendCalled() {
@@ -166,7 +198,7 @@ func TestMultiplePingsKeepMachineAlive(t *testing.T) {
 		// Make multiple ping requests within the timeout period
 		for i := 0; i < 5; i++ {
 			// Wait for part of the timeout period
-			time.Sleep(config.InactivityTimeout / 2)
+			time.Sleep(config().InactivityTimeout / 2)
 
 			// Make a ping request
 			req := httptest.NewRequest("GET", "/ping", nil)
@@ -184,7 +216,7 @@ func TestMultiplePingsKeepMachineAlive(t *testing.T) {
 		}
 
 		// Finally, wait for the full timeout without any pings
-		time.Sleep(config.InactivityTimeout + 100*time.Millisecond)
+		time.Sleep(config().InactivityTimeout + 100*time.Millisecond)
 
 		// Now suspension should be called
 		if !mockGCP.WasSuspendCalled() {
@@ -199,16 +231,16 @@ func TestKeepOnlineDisablesSuspension(t *testing.T) {
 		defer cleanup()
 
 		// Set keep online flag
-		config.LibOpsKeepOnline = "yes"
+		updateConfig(func(cfg *Config) { cfg.LibOpsKeepOnline = true })
 
 		// Don't start the timer at all when keep online is enabled
-		// This simulates the main() function logic that checks LibOpsKeepOnline != "yes"
-		if config.LibOpsKeepOnline != "yes" {
+		// This simulates the main() function logic that checks LibOpsKeepOnline
+		if !config().LibOpsKeepOnline {
 			resetShutdownTimer()
 		}
 
 		// Wait for longer than the timeout period
-		time.Sleep(config.InactivityTimeout * 2)
+		time.Sleep(config().InactivityTimeout * 2)
 
 		// Suspension should NOT be called
 		if mockGCP.WasSuspendCalled() {
@@ -235,6 +267,72 @@ func TestHealthEndpoint(t *testing.T) {
 	}
 }
 
+func TestMaxConcurrentPings(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) { cfg.MaxConcurrentPings = 2 })
+	before := pingsShed.value.Load()
+
+	// Two pings are already being handled
+	pingsInFlight.Store(2)
+	defer pingsInFlight.Store(0)
+
+	w := httptest.NewRecorder()
+	pingHandler(w, httptest.NewRequest("GET", "/ping", nil))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("Expected a 429 with Retry-After, got %d", w.Code)
+	}
+	if got := pingsShed.value.Load() - before; got != 1 {
+		t.Fatalf("Expected the shed ping to be counted, got %d", got)
+	}
+
+	pingsInFlight.Store(1)
+	w = httptest.NewRecorder()
+	pingHandler(w, httptest.NewRequest("GET", "/ping", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected a ping under the limit to be handled, got %d", w.Code)
+	}
+	if got := pingsInFlight.Load(); got != 1 {
+		t.Fatalf("Expected the ping's slot to be released, %d in flight", got)
+	}
+}
+
+func TestHealthcheckCountsAsActivity(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	tracker.mu.Lock()
+	tracker.requestCount = 0
+	tracker.mu.Unlock()
+	requestCount := func() int64 {
+		tracker.mu.RLock()
+		defer tracker.mu.RUnlock()
+		return tracker.requestCount
+	}
+
+	healthHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthcheck", nil))
+	if got := requestCount(); got != 0 {
+		t.Fatalf("Health probes shouldn't count by default, got %d pings", got)
+	}
+
+	updateConfig(func(cfg *Config) {
+		cfg.HealthcheckCountsAsActivity = true
+		cfg.IgnorePingUserAgents = []string{"kube-probe"}
+	})
+	healthHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/healthcheck", nil))
+	if got := requestCount(); got != 1 {
+		t.Fatalf("Expected the health probe to count as a ping, got %d", got)
+	}
+
+	req := httptest.NewRequest("GET", "/healthcheck", nil)
+	req.Header.Set("User-Agent", "kube-probe/1.29")
+	healthHandler(httptest.NewRecorder(), req)
+	if got := requestCount(); got != 1 {
+		t.Fatalf("Ignored user agents shouldn't count, got %d pings", got)
+	}
+}
+
 func TestTimerResetBeforeSuspension(t *testing.T) {
 	synctest.Test(t, func(t *testing.T) {
 		cleanup := setupTestEnvironment()
@@ -244,7 +342,7 @@ func TestTimerResetBeforeSuspension(t *testing.T) {
 		resetShutdownTimer()
 
 		// Wait for timeout to trigger suspension
-		time.Sleep(config.InactivityTimeout + 100*time.Millisecond)
+		time.Sleep(config().InactivityTimeout + 100*time.Millisecond)
 
 		// Verify suspension was called
 		// The resetShutdownTimer call before suspension is tested implicitly
@@ -254,3 +352,680 @@ func TestTimerResetBeforeSuspension(t *testing.T) {
 		}
 	})
 }
+
+func TestKeepOnlineTruthyValues(t *testing.T) {
+	tests := []struct {
+		value string
+		want  bool
+	}{
+		{"yes", true},
+		{"YES", true},
+		{"true", true},
+		{"True", true},
+		{"1", true},
+		{"on", true},
+		{" On ", true},
+		{"no", false},
+		{"false", false},
+		{"0", false},
+		{"off", false},
+		{"", false},
+		{"maybe", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			t.Setenv("LIBOPS_KEEP_ONLINE", tt.value)
+			cfg, err := loadConfig()
+			if err != nil {
+				t.Fatalf("loadConfig: %v", err)
+			}
+			if got := cfg.LibOpsKeepOnline; got != tt.want {
+				t.Fatalf("LIBOPS_KEEP_ONLINE=%q: expected %v, got %v", tt.value, tt.want, got)
+			}
+		})
+	}
+}
+
+func TestUnknownPathListsEndpoints(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	origRoutes := routes
+	routes = []string{"/ping", "/healthcheck"}
+	defer func() { routes = origRoutes }()
+
+	req := httptest.NewRequest("GET", "/pnig", nil)
+	w := httptest.NewRecorder()
+	notFoundHandler(w, req)
+
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status 404, got %d", w.Code)
+	}
+
+	var body struct {
+		Path      string   `json:"path"`
+		Endpoints []string `json:"endpoints"`
+	}
+	if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if body.Path != "/pnig" || len(body.Endpoints) != 2 || body.Endpoints[0] != "/ping" {
+		t.Fatalf("Unexpected response body: %+v", body)
+	}
+}
+
+func TestTwoPhaseTimeout(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		updateConfig(func(cfg *Config) { cfg.ArmedTimeout = 30 * time.Second })
+		resetShutdownTimer()
+
+		time.Sleep(config().InactivityTimeout + time.Second)
+		if mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should not be called when the shutdown is only armed")
+		}
+		if currentStatus().ArmedAt == nil {
+			t.Fatal("Expected /status to report the shutdown as armed")
+		}
+
+		// A ping while armed goes back to idle
+		pingHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/ping", nil))
+		if currentStatus().ArmedAt != nil {
+			t.Fatal("A ping should disarm the shutdown")
+		}
+
+		time.Sleep(config().InactivityTimeout + config().ArmedTimeout - time.Second)
+		if mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should wait for both phases after a ping")
+		}
+
+		time.Sleep(2 * time.Second)
+		if !mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should be called after both phases elapse without activity")
+		}
+	})
+}
+
+func TestNewMuxServesRoutes(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	origRoutes := routes
+	defer func() { routes = origRoutes }()
+
+	mux := newMux()
+	registered := len(routes)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/ping", nil))
+	if w.Code != http.StatusOK || w.Body.String() != "pong" {
+		t.Fatalf("Expected pong from /ping, got %d %q", w.Code, w.Body.String())
+	}
+
+	// Admin routes are registered since the test config has a token
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("POST", "/suspend", nil))
+	if w.Code != http.StatusUnauthorized {
+		t.Fatalf("Expected status 401 from /suspend without a token, got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/pnig", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("Expected status 404, got %d", w.Code)
+	}
+
+	// Building a second mux must not panic on duplicate patterns or duplicate the route list
+	newMux()
+	if len(routes) != registered {
+		t.Fatalf("Expected %d routes, got %d: %v", registered, len(routes), routes)
+	}
+}
+
+func TestPublicMuxServesOnlyHealthcheck(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	mux := newPublicMux()
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/healthcheck", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200 from /healthcheck, got %d", w.Code)
+	}
+
+	for _, path := range []string{"/ping", "/status", "/metrics", "/suspend", "/debug/pprof/"} {
+		w = httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != http.StatusNotFound {
+			t.Fatalf("Expected status 404 from %s on the public port, got %d", path, w.Code)
+		}
+	}
+}
+
+func TestStartServersReadyBeforeTraffic(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	origRoutes := routes
+	defer func() {
+		routes = origRoutes
+		ready.Store(false)
+	}()
+
+	w := httptest.NewRecorder()
+	readyzHandler(w, httptest.NewRequest("GET", "/readyz", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status 503 before the servers start, got %d", w.Code)
+	}
+
+	server := newHTTPServer("127.0.0.1:0", newMux())
+	listeners, err := startServers([]*http.Server{server})
+	if err != nil {
+		t.Fatalf("startServers: %v", err)
+	}
+	defer server.Close()
+
+	// The very first requests must reach their handlers, not the catch-all 404
+	base := "http://" + listeners[0].Addr().String()
+	for _, path := range []string{"/readyz", "/status", "/healthcheck"} {
+		resp, err := http.Get(base + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("GET %s: expected status 200, got %d", path, resp.StatusCode)
+		}
+	}
+}
+
+func TestStartServersFailsOnBusyAddress(t *testing.T) {
+	first := newHTTPServer("127.0.0.1:0", http.NewServeMux())
+	listeners, err := startServers([]*http.Server{first})
+	if err != nil {
+		t.Fatalf("startServers: %v", err)
+	}
+	defer func() {
+		first.Close()
+		ready.Store(false)
+	}()
+
+	ready.Store(false)
+	second := newHTTPServer(listeners[0].Addr().String(), http.NewServeMux())
+	if _, err := startServers([]*http.Server{second}); err == nil {
+		t.Fatal("Expected an error listening on a busy address")
+	}
+	if ready.Load() {
+		t.Fatal("Should not be ready when a listener failed")
+	}
+}
+
+func TestIgnoredUserAgentDoesNotResetTimer(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		updateConfig(func(cfg *Config) { cfg.IgnorePingUserAgents = []string{"GoogleHC", "kube-probe"} })
+		resetShutdownTimer()
+
+		time.Sleep(config().InactivityTimeout - time.Second)
+
+		req := httptest.NewRequest("GET", "/ping", nil)
+		req.Header.Set("User-Agent", "GoogleHC/1.0")
+		w := httptest.NewRecorder()
+		pingHandler(w, req)
+		if w.Code != http.StatusOK || w.Body.String() != "pong" {
+			t.Fatalf("Expected pong for an ignored ping, got %d %q", w.Code, w.Body.String())
+		}
+
+		time.Sleep(2 * time.Second)
+		if !mockGCP.WasSuspendCalled() {
+			t.Fatal("A ping from an ignored user agent should not reset the timer")
+		}
+		if currentStatus().RequestCount != 0 {
+			t.Fatal("A ping from an ignored user agent should not be counted")
+		}
+	})
+}
+
+func TestPingThreshold(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		updateConfig(func(cfg *Config) {
+			cfg.PingThreshold = 3
+			cfg.PingThresholdWindow = 10 * time.Second
+		})
+		resetShutdownTimer()
+
+		ping := func() {
+			pingHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/ping", nil))
+		}
+
+		// Isolated pings spread wider than the window never add up to the threshold
+		time.Sleep(config().InactivityTimeout - 30*time.Second)
+		ping()
+		time.Sleep(15 * time.Second)
+		ping()
+		time.Sleep(15 * time.Second)
+		ping()
+		if currentStatus().RequestCount != 0 {
+			t.Fatal("Pings below the threshold should not be counted")
+		}
+
+		time.Sleep(time.Second)
+		if !mockGCP.WasSuspendCalled() {
+			t.Fatal("Pings below the threshold should not reset the timer")
+		}
+	})
+}
+
+func TestPingThresholdMet(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		updateConfig(func(cfg *Config) {
+			cfg.PingThreshold = 2
+			cfg.PingThresholdWindow = 10 * time.Second
+		})
+		resetShutdownTimer()
+
+		time.Sleep(config().InactivityTimeout - 5*time.Second)
+		pingHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/ping", nil))
+		time.Sleep(time.Second)
+		pingHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/ping", nil))
+
+		time.Sleep(10 * time.Second)
+		if mockGCP.WasSuspendCalled() {
+			t.Fatal("Enough pings within the window should reset the timer")
+		}
+		if currentStatus().RequestCount != 1 {
+			t.Fatalf("Expected only the ping that met the threshold to count, got %d", currentStatus().RequestCount)
+		}
+	})
+}
+
+func TestPingReportsWhyItWasIgnored(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) {
+		cfg.IgnorePingUserAgents = []string{"kube-probe"}
+		cfg.PingThreshold = 2
+		cfg.PingThresholdWindow = time.Minute
+	})
+
+	ping := func(userAgent string) (pingResult, string) {
+		req := httptest.NewRequest("GET", "/ping", nil)
+		req.Header.Set("Accept", "application/json")
+		req.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		pingHandler(w, req)
+
+		var result pingResult
+		if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+			t.Fatalf("Failed to decode ping response: %v", err)
+		}
+		return result, w.Header().Get("X-Lightsout-Ping")
+	}
+
+	if result, header := ping("kube-probe/1.29"); result.Counted || result.Reason != "ignored: user-agent filtered" || header != result.Reason {
+		t.Fatalf("Expected a filtered user agent, got %+v / %q", result, header)
+	}
+	if result, _ := ping("ci"); result.Counted || !strings.Contains(result.Reason, "1 of 2 pings") {
+		t.Fatalf("Expected a ping below the threshold, got %+v", result)
+	}
+	if result, header := ping("ci"); !result.Counted || result.Reason != "" || header != "counted" {
+		t.Fatalf("Expected the ping meeting the threshold to count, got %+v / %q", result, header)
+	}
+	stopShutdownTimer()
+
+	// Plain clients still get pong
+	w := httptest.NewRecorder()
+	pingHandler(w, httptest.NewRequest("GET", "/ping", nil))
+	if w.Body.String() != "pong" || w.Header().Get("X-Lightsout-Ping") != "counted" {
+		t.Fatalf("Expected pong with the result header, got %q / %q", w.Body.String(), w.Header().Get("X-Lightsout-Ping"))
+	}
+}
+
+func TestPingModeExtend(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+	defer stopShutdownTimer()
+
+	updateConfig(func(cfg *Config) {
+		cfg.InactivityTimeout = 10 * time.Minute
+		cfg.PingMode = pingModeExtend
+		cfg.PingExtendIncrement = 2 * time.Minute
+		cfg.PingExtendCap = 5 * time.Minute
+	})
+
+	remaining := func() time.Duration {
+		t.Helper()
+		status := currentStatus()
+		if status.TimerRemainingSeconds == nil {
+			t.Fatal("Expected the timer to be running")
+		}
+		return time.Duration(*status.TimerRemainingSeconds) * time.Second
+	}
+	ping := func() {
+		t.Helper()
+		w := httptest.NewRecorder()
+		pingHandler(w, httptest.NewRequest("GET", "/ping", nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+	}
+	near := func(got, want time.Duration) bool {
+		return got <= want && got > want-5*time.Second
+	}
+
+	stopShutdownTimer()
+	if status := currentStatus(); status.TimerRemainingSeconds != nil {
+		t.Fatalf("Expected no remaining time while the timer is stopped, got %d", *status.TimerRemainingSeconds)
+	}
+
+	// Each ping buys another increment, up to the cap
+	for _, want := range []time.Duration{2 * time.Minute, 4 * time.Minute, 5 * time.Minute, 5 * time.Minute} {
+		ping()
+		if got := remaining(); !near(got, want) {
+			t.Fatalf("Expected about %s left, got %s", want, got)
+		}
+	}
+
+	// A full window from another source is never cut short by a ping
+	resetShutdownTimer()
+	ping()
+	if got := remaining(); !near(got, 10*time.Minute) {
+		t.Fatalf("Expected the full timeout to be kept, got %s", got)
+	}
+}
+
+func TestBatchedPing(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) {
+		cfg.PingSources = []string{"ci", "ssh"}
+	})
+
+	stale := time.Now().Add(-time.Hour)
+	body := `[{"source":"ci"},{"source":"ssh","ts":"` + stale.Format(time.RFC3339Nano) + `"}]`
+	req := httptest.NewRequest("POST", "/ping", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	pingHandler(w, req)
+	stopShutdownTimer()
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	var results []batchedActivityResult
+	if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(results) != 2 || !results[0].TimerReset || results[1].TimerReset {
+		t.Fatalf("Expected only the recent entry to reset the timer, got %+v", results)
+	}
+
+	tracker.mu.RLock()
+	ci, ssh := tracker.sourceLastSeen["ci"], tracker.sourceLastSeen["ssh"]
+	tracker.mu.RUnlock()
+	if ci.IsZero() || !ssh.Equal(stale) {
+		t.Fatalf("Expected each source's last activity to be recorded, got ci=%v ssh=%v", ci, ssh)
+	}
+
+	// A bad entry rejects the whole batch
+	for _, body := range []string{`[{"source":"ci"},{"ts":"2020-01-01T00:00:00Z"}]`, `[{"source":"ci"},{"source":"unlisted"}]`} {
+		req = httptest.NewRequest("POST", "/ping", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w = httptest.NewRecorder()
+		pingHandler(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected status 400 for %s, got %d", body, w.Code)
+		}
+	}
+	tracker.mu.RLock()
+	_, unlisted := tracker.sourceLastSeen["unlisted"]
+	tracker.mu.RUnlock()
+	if unlisted {
+		t.Fatal("Sources missing from PING_SOURCES should not be recorded")
+	}
+
+	// /ping isn't behind a token, so the body is capped
+	req = httptest.NewRequest("POST", "/ping", strings.NewReader("["+strings.Repeat(`{"source":"ci"},`, maxPingBatchBytes/16)+`{"source":"ci"}]`))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	pingHandler(w, req)
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("Expected status 413 for an oversized batch, got %d", w.Code)
+	}
+
+	// A bodyless POST is still a plain ping
+	w = httptest.NewRecorder()
+	pingHandler(w, httptest.NewRequest("POST", "/ping", nil))
+	if w.Body.String() != "pong" {
+		t.Fatalf("Expected pong for a bodyless POST, got %q", w.Body.String())
+	}
+}
+
+func TestMissingGCPConfigKeepsServing(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+	defer stopShutdownTimer()
+
+	updateConfig(func(cfg *Config) { cfg.GoogleProjectID = "" })
+	suspended := false
+	suspendFunc = func(context.Context) error {
+		suspended = true
+		return nil
+	}
+
+	if err := suspendAndShutdown("inactivity timeout", nil); !errors.Is(err, errMissingGCPConfig) {
+		t.Fatalf("Expected errMissingGCPConfig, got %v", err)
+	}
+	if suspended {
+		t.Fatal("Should not try to suspend without the GCP configuration")
+	}
+	select {
+	case <-serverShutdown:
+		t.Fatal("Server should keep running without the GCP configuration")
+	default:
+	}
+	if exitCode.Load() != exitOK {
+		t.Fatalf("Expected no failure exit code, got %d", exitCode.Load())
+	}
+	if nextSuspendAt() == nil {
+		t.Fatal("Expected the inactivity timer to be restarted")
+	}
+
+	// Pings still count
+	w := httptest.NewRecorder()
+	pingHandler(w, httptest.NewRequest("GET", "/ping", nil))
+	if w.Body.String() != "pong" {
+		t.Fatalf("Expected pong, got %q", w.Body.String())
+	}
+}
+
+func TestNoopProviderOnlyLogsSuspends(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+	defer stopShutdownTimer()
+
+	updateConfig(func(cfg *Config) { cfg.Provider = providerNoop })
+	suspended := false
+	suspendFunc = func(context.Context) error {
+		suspended = true
+		return nil
+	}
+
+	if err := suspendAndShutdown("inactivity timeout", nil); !errors.Is(err, errNoopProvider) {
+		t.Fatalf("Expected errNoopProvider, got %v", err)
+	}
+	if suspended {
+		t.Fatal("The noop provider should never suspend")
+	}
+	select {
+	case <-serverShutdown:
+		t.Fatal("Server should keep running under the noop provider")
+	default:
+	}
+	if nextSuspendAt() == nil {
+		t.Fatal("Expected another inactivity window to start")
+	}
+
+	tracker.mu.RLock()
+	last := tracker.decisions[len(tracker.decisions)-1]
+	tracker.mu.RUnlock()
+	if last.Action != "would_suspend" || !strings.Contains(last.Reason, "noop provider") {
+		t.Fatalf("Expected a would_suspend decision, got %+v", last)
+	}
+}
+
+func TestLogLinesCarryInstance(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) { cfg.GoogleProjectID = "" })
+
+	var buf bytes.Buffer
+	newLogger(&buf, logLevel()).Error("Failed to suspend instance")
+
+	line := buf.String()
+	if !strings.Contains(line, "instance=test-instance") || !strings.Contains(line, "zone=test-zone") {
+		t.Fatalf("Expected the instance and zone on every line, got %q", line)
+	}
+	if strings.Contains(line, "project=") {
+		t.Fatalf("Unset fields should be left out, got %q", line)
+	}
+}
+
+func TestGCPLogFormat(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) { cfg.LogFormat = logFormatGCP })
+
+	var buf bytes.Buffer
+	newLogger(&buf, slog.LevelDebug).Warn("Instance state drifted", "status", "SUSPENDED")
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected a JSON log line, got %q: %v", buf.String(), err)
+	}
+	if entry["severity"] != "WARNING" || entry["message"] != "Instance state drifted" || entry["status"] != "SUSPENDED" {
+		t.Fatalf("Expected Cloud Logging fields, got %v", entry)
+	}
+	labels, ok := entry["logging.googleapis.com/labels"].(map[string]any)
+	if !ok || labels["instance"] != "test-instance" || labels["zone"] != "test-zone" {
+		t.Fatalf("Expected the instance as labels, got %v", entry)
+	}
+
+	for level, want := range map[slog.Level]string{
+		slog.LevelDebug: "DEBUG",
+		slog.LevelInfo:  "INFO",
+		slog.LevelWarn:  "WARNING",
+		slog.LevelError: "ERROR",
+	} {
+		if got := gcpSeverity(level); got != want {
+			t.Fatalf("Level %s: expected %s, got %s", level, want, got)
+		}
+	}
+}
+
+func TestH2CListener(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := newHTTPServer(listener.Addr().String(), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Proto)
+	}))
+	enableH2C(server)
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+
+	var protocols http.Protocols
+	protocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: &protocols}}
+
+	resp, err := client.Get("http://" + listener.Addr().String() + "/ping")
+	if err != nil {
+		t.Fatalf("h2c request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "HTTP/2.0" {
+		t.Fatalf("Expected an HTTP/2 request, got %s", body)
+	}
+
+	// Plain HTTP/1.1 clients keep working
+	resp, err = http.Get("http://" + listener.Addr().String() + "/ping")
+	if err != nil {
+		t.Fatalf("HTTP/1.1 request failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ = io.ReadAll(resp.Body)
+	if string(body) != "HTTP/1.1" {
+		t.Fatalf("Expected an HTTP/1.1 request, got %s", body)
+	}
+}
+
+func TestBatchedPingHonoursThreshold(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) {
+		cfg.PingSources = []string{"ci"}
+		cfg.PingThreshold = 3
+		cfg.PingThresholdWindow = time.Minute
+	})
+
+	batch := func() []batchedActivityResult {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/ping", strings.NewReader(`[{"source":"ci"},{"source":"ci"},{"source":"ci"}]`))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		pingHandler(w, req)
+
+		var results []batchedActivityResult
+		if err := json.NewDecoder(w.Body).Decode(&results); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return results
+	}
+	timerRunning := func() bool {
+		shutdownMutex.Lock()
+		defer shutdownMutex.Unlock()
+		return shutdownTimer != nil
+	}
+	defer stopShutdownTimer()
+
+	// Three entries in one request are still one ping
+	for range 2 {
+		results := batch()
+		if len(results) != 3 || results[0].TimerReset || results[2].TimerReset || results[2].Reason == "" {
+			t.Fatalf("Expected PING_THRESHOLD to hold the batch back, got %+v", results)
+		}
+		if timerRunning() {
+			t.Fatal("Expected the timer not to be reset below PING_THRESHOLD")
+		}
+	}
+
+	results := batch()
+	if len(results) != 3 || !results[0].TimerReset || !results[2].TimerReset {
+		t.Fatalf("Expected the third batch to meet PING_THRESHOLD, got %+v", results)
+	}
+	if !timerRunning() {
+		t.Fatal("Expected the timer to be reset once PING_THRESHOLD is met")
+	}
+}
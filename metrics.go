@@ -0,0 +1,257 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// metric is anything that can write itself in the Prometheus text exposition format
+// and report its current values for pushing to StatsD
+type metric interface {
+	writeTo(w io.Writer)
+	samples() []sample
+}
+
+// sample is one value of a metric, counters hold their running total
+type sample struct {
+	name    string
+	value   float64
+	counter bool
+}
+
+var (
+	registryMu sync.Mutex
+	registry   []metric
+)
+
+func register(m metric) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	registry = append(registry, m)
+}
+
+// histogram is a minimal Prometheus histogram
+type histogram struct {
+	name    string
+	help    string
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(name, help string, buckets []float64) *histogram {
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+	return &histogram{
+		name:    name,
+		help:    help,
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+func (h *histogram) writeTo(w io.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", h.name, h.help)
+	fmt.Fprintf(w, "# TYPE %s histogram\n", h.name)
+	for i, upper := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.name, formatFloat(upper), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.name, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.name, formatFloat(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.name, h.count)
+}
+
+// samples reports the count and sum, the buckets don't map onto StatsD
+func (h *histogram) samples() []sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	return []sample{
+		{name: h.name + "_count", value: float64(h.count), counter: true},
+		{name: h.name + "_sum", value: h.sum, counter: true},
+	}
+}
+
+// counter is a minimal Prometheus counter
+type counter struct {
+	name  string
+	help  string
+	value atomic.Uint64
+}
+
+func (c *counter) inc() {
+	c.value.Add(1)
+}
+
+func (c *counter) writeTo(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", c.name, c.help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", c.name)
+	fmt.Fprintf(w, "%s %d\n", c.name, c.value.Load())
+}
+
+func (c *counter) samples() []sample {
+	return []sample{{name: c.name, value: float64(c.value.Load()), counter: true}}
+}
+
+// gaugeFunc is a gauge whose value is read when /metrics is scraped
+// Set counter for values that only ever go up
+type gaugeFunc struct {
+	name    string
+	help    string
+	counter bool
+	fn      func() float64
+}
+
+func (g *gaugeFunc) writeTo(w io.Writer) {
+	kind := "gauge"
+	if g.counter {
+		kind = "counter"
+	}
+
+	fmt.Fprintf(w, "# HELP %s %s\n", g.name, g.help)
+	fmt.Fprintf(w, "# TYPE %s %s\n", g.name, kind)
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+func (g *gaugeFunc) samples() []sample {
+	return []sample{{name: g.name, value: g.fn(), counter: g.counter}}
+}
+
+// registerTrackerMetrics exposes the tracker's counters and gauges, read straight from it on every scrape
+func registerTrackerMetrics() {
+	readTracker := func(fn func() float64) func() float64 {
+		return func() float64 {
+			tracker.mu.RLock()
+			defer tracker.mu.RUnlock()
+			return fn()
+		}
+	}
+
+	register(&gaugeFunc{
+		name:    "lightsout_ping_requests_total",
+		help:    "Pings that counted as activity.",
+		counter: true,
+		fn:      readTracker(func() float64 { return float64(tracker.requestCount) }),
+	})
+	register(&gaugeFunc{
+		name: "lightsout_last_ping_timestamp_seconds",
+		help: "Unix time of the last ping.",
+		fn:   readTracker(func() float64 { return float64(tracker.lastPing.Unix()) }),
+	})
+	register(&gaugeFunc{
+		name: "lightsout_start_time_seconds",
+		help: "Unix time lightsout started.",
+		fn:   readTracker(func() float64 { return float64(tracker.startedAt.Unix()) }),
+	})
+	register(&gaugeFunc{
+		name: "lightsout_instance_not_found",
+		help: "1 once the GCP API reported the instance no longer exists.",
+		fn:   readTracker(func() float64 { return boolToFloat(tracker.instanceNotFound) }),
+	})
+	register(&gaugeFunc{
+		name: "lightsout_inactivity_timeout_seconds",
+		help: "The inactivity timeout currently in effect.",
+		fn:   func() float64 { return inactivityTimeout().Seconds() },
+	})
+	register(&gaugeFunc{
+		name: "lightsout_keep_online",
+		help: "1 while automatic suspend is disabled.",
+		fn:   func() float64 { return boolToFloat(keepOnline()) },
+	})
+	register(&gaugeFunc{
+		name: "lightsout_seconds_until_suspend",
+		help: "Seconds until the inactivity timer suspends the instance, -1 while it isn't running.",
+		fn:   secondsUntilSuspend,
+	})
+	register(&gaugeFunc{
+		name: "lightsout_shutdown_armed",
+		help: "1 while a two-phase shutdown is armed.",
+		fn:   func() float64 { return boolToFloat(!armedSince().IsZero()) },
+	})
+}
+
+func secondsUntilSuspend() float64 {
+	due := nextSuspendAt()
+	if due == nil {
+		return -1
+	}
+	return max(time.Until(*due).Seconds(), 0)
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// formatFloat avoids exponents so timestamps stay readable
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+// parseBuckets parses a comma-separated list of histogram upper bounds
+func parseBuckets(value string) ([]float64, error) {
+	var buckets []float64
+	for _, field := range strings.Split(value, ",") {
+		bucket, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || bucket <= 0 {
+			return nil, fmt.Errorf("invalid histogram bucket %q", field)
+		}
+		buckets = append(buckets, bucket)
+	}
+	return buckets, nil
+}
+
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetrics(w)
+}
+
+// collectSamples returns the current values of every registered metric
+func collectSamples() []sample {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	var all []sample
+	for _, m := range registry {
+		all = append(all, m.samples()...)
+	}
+	return all
+}
+
+// writeMetrics writes every registered metric in the Prometheus text format
+func writeMetrics(w io.Writer) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+
+	for _, m := range registry {
+		m.writeTo(w)
+	}
+}
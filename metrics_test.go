@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestHistogramExposition(t *testing.T) {
+	h := newHistogram("test_seconds", "Test histogram.", []float64{60, 10})
+	h.observe(5)
+	h.observe(30)
+	h.observe(120)
+
+	var buf bytes.Buffer
+	h.writeTo(&buf)
+
+	want := `# HELP test_seconds Test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{le="10"} 1
+test_seconds_bucket{le="60"} 2
+test_seconds_bucket{le="+Inf"} 3
+test_seconds_sum 155
+test_seconds_count 3
+`
+	if buf.String() != want {
+		t.Fatalf("Unexpected exposition:\n%s", buf.String())
+	}
+}
+
+func TestParseBuckets(t *testing.T) {
+	buckets, err := parseBuckets("60, 300,3600")
+	if err != nil || len(buckets) != 3 || buckets[1] != 300 {
+		t.Fatalf("Unexpected buckets %v (%v)", buckets, err)
+	}
+
+	for _, invalid := range []string{"", "60,,300", "sixty", "-5"} {
+		if _, err := parseBuckets(invalid); err == nil {
+			t.Fatalf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestActiveDurationRecordedOnSuspend(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		origActiveDuration := activeDuration
+		activeDuration = newHistogram("lightsout_active_duration_seconds", "test", []float64{60, 300})
+		defer func() { activeDuration = origActiveDuration }()
+
+		// Active time counts from the last resume, not from when lightsout started
+		time.Sleep(time.Hour)
+		if checkResumed(time.Minute, time.Minute+time.Second, time.Now()) {
+			t.Fatal("A second of clock drift should not count as a resume")
+		}
+		if !checkResumed(time.Minute, 2*time.Hour, time.Now()) {
+			t.Fatal("Expected the wall clock jumping ahead to count as a resume")
+		}
+
+		resetShutdownTimer()
+		time.Sleep(config().InactivityTimeout + time.Second)
+
+		var buf bytes.Buffer
+		activeDuration.writeTo(&buf)
+		if !strings.Contains(buf.String(), `lightsout_active_duration_seconds_bucket{le="300"} 1`) {
+			t.Fatalf("Expected one observation under 300s:\n%s", buf.String())
+		}
+		if !strings.Contains(buf.String(), `lightsout_active_duration_seconds_bucket{le="60"} 0`) {
+			t.Fatalf("Expected no observation under 60s:\n%s", buf.String())
+		}
+	})
+}
+
+func TestMetricsHandler(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	w := httptest.NewRecorder()
+	metricsHandler(w, httptest.NewRequest("GET", "/metrics", nil))
+
+	if !strings.Contains(w.Body.String(), "# TYPE lightsout_active_duration_seconds histogram") {
+		t.Fatalf("Expected the active duration histogram in /metrics:\n%s", w.Body.String())
+	}
+}
+
+func TestSuspendLastErrorGauge(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+	defer exitCode.Store(exitOK)
+
+	suspendFunc = func(context.Context) error { return errors.New("failed to suspend instance: quota exceeded") }
+	suspendAndShutdown("test", nil)
+
+	last := currentStatus().LastSuspendError
+	if last == nil || !strings.Contains(last.Message, "quota exceeded") {
+		t.Fatalf("Expected /status to report the failed suspend, got %+v", last)
+	}
+	if lastSuspendErrorTimestamp() != float64(last.Time.Unix()) {
+		t.Fatalf("Expected the gauge to match the error time, got %v", lastSuspendErrorTimestamp())
+	}
+
+	serverShutdown = make(chan struct{})
+	suspendFunc = mockSuspendInstance
+	suspendAndShutdown("test", nil)
+
+	if currentStatus().LastSuspendError != nil || lastSuspendErrorTimestamp() != 0 {
+		t.Fatal("A successful suspend should clear the last error")
+	}
+}
+
+func TestTrackerMetrics(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		origRegistry := registry
+		registry = nil
+		defer func() { registry = origRegistry }()
+		registerTrackerMetrics()
+
+		pingHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/ping", nil))
+		pingHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/ping", nil))
+
+		w := httptest.NewRecorder()
+		metricsHandler(w, httptest.NewRequest("GET", "/metrics", nil))
+
+		// The synctest clock starts at 2000-01-01 00:00 UTC
+		for _, want := range []string{
+			"# TYPE lightsout_ping_requests_total counter\nlightsout_ping_requests_total 2\n",
+			"lightsout_last_ping_timestamp_seconds 946684800\n",
+			"lightsout_inactivity_timeout_seconds 90\n",
+			"lightsout_keep_online 0\n",
+			"lightsout_shutdown_armed 0\n",
+		} {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Fatalf("Expected %q in /metrics:\n%s", want, w.Body.String())
+			}
+		}
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	monitoring "google.golang.org/api/monitoring/v3"
+	"google.golang.org/api/option"
+)
+
+// cpuLookback is how far back we look for CPU samples, GCE only writes them every minute and they can lag a few more
+const cpuLookback = 5 * time.Minute
+
+var (
+	monitoringServiceMu     sync.Mutex
+	cachedMonitoringService *monitoring.Service
+	// newMonitoringService is swapped out in tests to point at a fake monitoring API
+	newMonitoringService = createMonitoringService
+)
+
+func createMonitoringService(ctx context.Context) (*monitoring.Service, error) {
+	// Same Application Default Credentials as the compute service
+	creds, err := findCredentials(ctx, monitoring.MonitoringReadScope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find default credentials: %w", err)
+	}
+
+	service, err := monitoring.NewService(ctx, option.WithCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create monitoring service: %w", err)
+	}
+
+	return service, nil
+}
+
+// getMonitoringService returns the shared monitoring service, creating it on first use
+func getMonitoringService(ctx context.Context) (*monitoring.Service, error) {
+	monitoringServiceMu.Lock()
+	defer monitoringServiceMu.Unlock()
+
+	if cachedMonitoringService != nil {
+		return cachedMonitoringService, nil
+	}
+
+	service, err := newMonitoringService(ctx)
+	if err != nil {
+		return nil, err
+	}
+	cachedMonitoringService = service
+
+	return service, nil
+}
+
+// recentCPUUtilization returns the highest CPU utilization, between 0 and 1, Cloud Monitoring has for the instance in the last cpuLookback
+func recentCPUUtilization(ctx context.Context) (float64, error) {
+	cfg := config()
+
+	service, err := getMonitoringService(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	end := time.Now()
+	filter := fmt.Sprintf(`metric.type = "compute.googleapis.com/instance/cpu/utilization" AND metric.labels.instance_name = %q AND resource.labels.zone = %q`,
+		cfg.GCEInstance, cfg.GCEZone)
+	resp, err := service.Projects.TimeSeries.List("projects/" + cfg.GoogleProjectID).
+		Filter(filter).
+		IntervalStartTime(end.Add(-cpuLookback).UTC().Format(time.RFC3339)).
+		IntervalEndTime(end.UTC().Format(time.RFC3339)).
+		Context(ctx).
+		Do()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list CPU utilization: %w", err)
+	}
+
+	found := false
+	highest := 0.0
+	for _, series := range resp.TimeSeries {
+		for _, point := range series.Points {
+			if point.Value == nil || point.Value.DoubleValue == nil {
+				continue
+			}
+			found = true
+			highest = max(highest, *point.Value.DoubleValue)
+		}
+	}
+	if !found {
+		return 0, fmt.Errorf("no CPU utilization samples in the last %s", cpuLookback)
+	}
+
+	return highest, nil
+}
+
+// gcpCPUActivity treats CPU utilization above GCP_CPU_THRESHOLD as activity happening right now
+// Errors, including no data, only mean this source has nothing to say and the suspend goes ahead
+func gcpCPUActivity(ctx context.Context) (time.Time, error) {
+	utilization, err := recentCPUUtilization(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	percent := utilization * 100
+	slog.Debug("GCP CPU utilization", "percent", percent)
+	if percent > config().GCPCPUThreshold {
+		return time.Now(), nil
+	}
+	return time.Time{}, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	monitoring "google.golang.org/api/monitoring/v3"
+	"google.golang.org/api/option"
+)
+
+// useFakeMonitoringAPI points the monitoring service at handler
+func useFakeMonitoringAPI(t *testing.T, handler http.Handler) {
+	t.Helper()
+
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	origNewMonitoringService := newMonitoringService
+	newMonitoringService = func(ctx context.Context) (*monitoring.Service, error) {
+		return monitoring.NewService(ctx,
+			option.WithEndpoint(server.URL+"/"),
+			option.WithoutAuthentication())
+	}
+	cachedMonitoringService = nil
+
+	t.Cleanup(func() {
+		newMonitoringService = origNewMonitoringService
+		cachedMonitoringService = nil
+	})
+}
+
+func cpuSeries(values ...float64) monitoring.ListTimeSeriesResponse {
+	series := &monitoring.TimeSeries{}
+	for _, v := range values {
+		series.Points = append(series.Points, &monitoring.Point{Value: &monitoring.TypedValue{DoubleValue: &v}})
+	}
+	return monitoring.ListTimeSeriesResponse{TimeSeries: []*monitoring.TimeSeries{series}}
+}
+
+func TestGCPCPUActivity(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) { cfg.GCPCPUThreshold = 10 })
+
+	var response monitoring.ListTimeSeriesResponse
+	useFakeMonitoringAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasSuffix(r.URL.Path, "/projects/test-project/timeSeries") {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		if filter := r.URL.Query().Get("filter"); !strings.Contains(filter, `metric.labels.instance_name = "test-instance"`) {
+			t.Errorf("Filter should select the instance, got %s", filter)
+		}
+		writeComputeJSON(w, response)
+	}))
+
+	response = cpuSeries(0.02, 0.45, 0.03)
+	lastActivity, err := gcpCPUActivity(context.Background())
+	if err != nil || lastActivity.IsZero() {
+		t.Fatalf("Expected a busy CPU to count as activity, got %v / %v", lastActivity, err)
+	}
+
+	response = cpuSeries(0.02, 0.05)
+	lastActivity, err = gcpCPUActivity(context.Background())
+	if err != nil || !lastActivity.IsZero() {
+		t.Fatalf("Expected an idle CPU not to count as activity, got %v / %v", lastActivity, err)
+	}
+
+	response = monitoring.ListTimeSeriesResponse{}
+	if _, err := gcpCPUActivity(context.Background()); err == nil {
+		t.Fatal("Expected an error without any samples")
+	}
+}
+
+func TestGCPCPUActivityAPIError(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	useFakeMonitoringAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+	}))
+
+	// An error leaves the source out of the decision rather than keeping the machine online
+	lastActivity, err := checkSource(context.Background(), sourceFunc{name: "gcp_cpu", fn: gcpCPUActivity})
+	if err == nil || !lastActivity.IsZero() {
+		t.Fatalf("Expected an error and no activity, got %v / %v", lastActivity, err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// procNetDevPath and netSampleInterval are swapped out in tests
+	procNetDevPath    = "/proc/net/dev"
+	netSampleInterval = time.Second
+)
+
+// readNetBytes returns the bytes received plus sent so far on NET_INTERFACE, or every interface but loopback if unset
+func readNetBytes() (uint64, error) {
+	data, err := os.ReadFile(procNetDevPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read interface counters: %w", err)
+	}
+	return parseNetDev(string(data), config().NetInterface)
+}
+
+// parseNetDev sums the receive and transmit byte counters in /proc/net/dev for iface, or every interface but lo if empty
+func parseNetDev(content, iface string) (uint64, error) {
+	var total uint64
+	found := false
+	for line := range strings.SplitSeq(content, "\n") {
+		name, counters, ok := strings.Cut(line, ":")
+		if !ok {
+			// The two header lines
+			continue
+		}
+		name = strings.TrimSpace(name)
+		if (iface == "" && name == "lo") || (iface != "" && name != iface) {
+			continue
+		}
+
+		// 8 receive counters followed by 8 transmit counters, bytes first in each
+		fields := strings.Fields(counters)
+		if len(fields) < 16 {
+			return 0, fmt.Errorf("unexpected counters for %s: %q", name, line)
+		}
+		rx, rxErr := strconv.ParseUint(fields[0], 10, 64)
+		tx, txErr := strconv.ParseUint(fields[8], 10, 64)
+		if rxErr != nil || txErr != nil {
+			return 0, fmt.Errorf("unexpected counters for %s: %q", name, line)
+		}
+		total += rx + tx
+		found = true
+	}
+
+	if !found {
+		if iface == "" {
+			return 0, fmt.Errorf("no network interfaces found")
+		}
+		return 0, fmt.Errorf("network interface %s not found", iface)
+	}
+	return total, nil
+}
+
+// netThroughputActivity samples the interface counters twice and treats throughput above NET_THROUGHPUT_THRESHOLD as activity happening right now
+func netThroughputActivity(ctx context.Context) (time.Time, error) {
+	before, err := readNetBytes()
+	if err != nil {
+		return time.Time{}, err
+	}
+	start := time.Now()
+
+	select {
+	case <-ctx.Done():
+		return time.Time{}, ctx.Err()
+	case <-time.After(netSampleInterval):
+	}
+
+	after, err := readNetBytes()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// Counters go backwards if the interface was reset, which says nothing about activity
+	if after < before {
+		return time.Time{}, fmt.Errorf("interface counters went backwards")
+	}
+
+	bytesPerSecond := float64(after-before) / time.Since(start).Seconds()
+	slog.Debug("Network throughput", "bytes_per_second", int64(bytesPerSecond))
+	if bytesPerSecond > float64(config().NetThroughputThreshold) {
+		return time.Now(), nil
+	}
+	return time.Time{}, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const netDevHeader = `Inter-|   Receive                                                |  Transmit
+ face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+`
+
+func netDevLine(iface string, rx, tx uint64) string {
+	return fmt.Sprintf("%6s: %d 10 0 0 0 0 0 0 %d 10 0 0 0 0 0 0\n", iface, rx, tx)
+}
+
+func TestParseNetDev(t *testing.T) {
+	content := netDevHeader + netDevLine("lo", 1000, 1000) + netDevLine("ens4", 200, 50) + netDevLine("docker0", 30, 20)
+
+	tests := []struct {
+		iface   string
+		want    uint64
+		wantErr bool
+	}{
+		{"", 300, false},
+		{"ens4", 250, false},
+		{"lo", 2000, false},
+		{"eth9", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseNetDev(content, tt.iface)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Fatalf("parseNetDev(%q): expected %d (error %t), got %d / %v", tt.iface, tt.want, tt.wantErr, got, err)
+		}
+	}
+}
+
+func TestNetThroughputActivity(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	path := filepath.Join(t.TempDir(), "dev")
+	origPath, origInterval := procNetDevPath, netSampleInterval
+	procNetDevPath, netSampleInterval = path, 50*time.Millisecond
+	defer func() { procNetDevPath, netSampleInterval = origPath, origInterval }()
+
+	updateConfig(func(cfg *Config) { cfg.NetThroughputThreshold = 1000 })
+
+	// Rewrite the counters while the source is sampling, as traffic would
+	measure := func(delta uint64) time.Time {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(netDevHeader+netDevLine("ens4", 0, 0)), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		go func() {
+			time.Sleep(10 * time.Millisecond)
+			// Rename so a read never sees a half written file
+			_ = os.WriteFile(path+".new", []byte(netDevHeader+netDevLine("ens4", delta, 0)), 0o644)
+			_ = os.Rename(path+".new", path)
+		}()
+
+		lastActivity, err := netThroughputActivity(context.Background())
+		if err != nil {
+			t.Fatalf("netThroughputActivity: %v", err)
+		}
+		return lastActivity
+	}
+
+	if measure(10) != (time.Time{}) {
+		t.Fatal("A trickle of traffic should not count as activity")
+	}
+	if measure(1_000_000).IsZero() {
+		t.Fatal("Throughput above the threshold should count as activity")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// operationGetter fetches the current state of a zone operation, it is swapped for a fake in tests
+type operationGetter interface {
+	Get(ctx context.Context, project, zone, name string) (*compute.Operation, error)
+}
+
+// zoneOperations is the operationGetter backed by the compute API
+type zoneOperations struct {
+	service *compute.Service
+}
+
+func (z zoneOperations) Get(ctx context.Context, project, zone, name string) (*compute.Operation, error) {
+	return z.service.ZoneOperations.Get(project, zone, name).Context(ctx).Do()
+}
+
+const (
+	// operationPollInterval is the first wait between polls, doubling up to maxOperationPollInterval
+	operationPollInterval    = time.Second
+	maxOperationPollInterval = 10 * time.Second
+	// maxOperationPollErrors is how many polls in a row may fail before we give up on the operation
+	maxOperationPollErrors = 3
+)
+
+var (
+	errOperationTimeout = errors.New("timed out waiting for operation")
+
+	operationsWithWarnings = &counter{
+		name: "lightsout_operations_with_warnings_total",
+		help: "Compute operations that succeeded with warnings, e.g. about deprecated resources.",
+	}
+)
+
+// operationWarnings returns op's warnings as "CODE: message", counting the operation if it has any
+// Warnings don't make an operation fail, but they are early notice of deprecations on the suspend path
+func operationWarnings(op *compute.Operation) []string {
+	if op == nil || len(op.Warnings) == 0 {
+		return nil
+	}
+
+	operationsWithWarnings.inc()
+	warnings := make([]string, 0, len(op.Warnings))
+	for _, warning := range op.Warnings {
+		warnings = append(warnings, warning.Code+": "+warning.Message)
+	}
+	return warnings
+}
+
+// waitForOperation polls op until it is DONE and returns it, or the error it finished with
+// ctx bounds the whole wait, running out of it returns errOperationTimeout
+func waitForOperation(ctx context.Context, ops operationGetter, project, zone string, op *compute.Operation) (*compute.Operation, error) {
+	interval := operationPollInterval
+	failedPolls := 0
+
+	for op.Status != "DONE" {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w %s: %v", errOperationTimeout, op.Name, ctx.Err())
+		case <-time.After(interval):
+		}
+		interval = min(interval*2, maxOperationPollInterval)
+
+		current, err := ops.Get(ctx, project, zone, op.Name)
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w %s: %v", errOperationTimeout, op.Name, ctx.Err())
+		}
+		if err != nil {
+			failedPolls++
+			if failedPolls >= maxOperationPollErrors {
+				return nil, fmt.Errorf("failed to get operation %s: %w", op.Name, err)
+			}
+			continue
+		}
+		failedPolls = 0
+		op = current
+	}
+
+	if op.Error != nil && len(op.Error.Errors) > 0 {
+		first := op.Error.Errors[0]
+		return nil, fmt.Errorf("operation %s failed: %s: %s", op.Name, first.Code, first.Message)
+	}
+	return op, nil
+}
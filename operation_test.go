@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// fakeOperations replays a fixed sequence of poll results, repeating the last one
+type fakeOperations struct {
+	results []fakePoll
+	polls   int
+}
+
+type fakePoll struct {
+	status string
+	err    error
+	opErr  *compute.OperationError
+}
+
+func (f *fakeOperations) Get(_ context.Context, _, _, name string) (*compute.Operation, error) {
+	result := f.results[min(f.polls, len(f.results)-1)]
+	f.polls++
+	if result.err != nil {
+		return nil, result.err
+	}
+	return &compute.Operation{Name: name, Status: result.status, Error: result.opErr}, nil
+}
+
+func TestWaitForOperationDoneImmediately(t *testing.T) {
+	ops := &fakeOperations{results: []fakePoll{{status: "DONE"}}}
+
+	_, err := waitForOperation(t.Context(), ops, "p", "z", &compute.Operation{Name: "op", Status: "DONE"})
+	if err != nil {
+		t.Fatalf("waitForOperation: %v", err)
+	}
+	if ops.polls != 0 {
+		t.Fatalf("Expected no polls for a finished operation, got %d", ops.polls)
+	}
+}
+
+func TestWaitForOperationDoneAfterPolls(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ops := &fakeOperations{results: []fakePoll{
+			{status: "RUNNING"},
+			{err: errors.New("transient")},
+			{status: "RUNNING"},
+			{status: "DONE"},
+		}}
+
+		start := time.Now()
+		_, err := waitForOperation(t.Context(), ops, "p", "z", &compute.Operation{Name: "op", Status: "PENDING"})
+		if err != nil {
+			t.Fatalf("waitForOperation: %v", err)
+		}
+		if ops.polls != 4 {
+			t.Fatalf("Expected 4 polls, got %d", ops.polls)
+		}
+		// 1s, 2s, 4s and 8s between polls
+		if waited := time.Since(start); waited != 15*time.Second {
+			t.Fatalf("Expected the poll interval to back off, waited %s", waited)
+		}
+	})
+}
+
+func TestWaitForOperationError(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ops := &fakeOperations{results: []fakePoll{{
+			status: "DONE",
+			opErr: &compute.OperationError{Errors: []*compute.OperationErrorErrors{{
+				Code:    "UNSUPPORTED_OPERATION",
+				Message: "instance has a local SSD",
+			}}},
+		}}}
+
+		_, err := waitForOperation(t.Context(), ops, "p", "z", &compute.Operation{Name: "op", Status: "RUNNING"})
+		if err == nil || !strings.Contains(err.Error(), "UNSUPPORTED_OPERATION") {
+			t.Fatalf("Expected the operation's error, got %v", err)
+		}
+	})
+}
+
+func TestWaitForOperationPollErrors(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ops := &fakeOperations{results: []fakePoll{{err: errors.New("backend error")}}}
+
+		_, err := waitForOperation(t.Context(), ops, "p", "z", &compute.Operation{Name: "op", Status: "RUNNING"})
+		if err == nil || ops.polls != maxOperationPollErrors {
+			t.Fatalf("Expected to give up after %d failed polls, got %v after %d", maxOperationPollErrors, err, ops.polls)
+		}
+	})
+}
+
+func TestWaitForOperationTimeout(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(t.Context(), time.Minute)
+		defer cancel()
+
+		ops := &fakeOperations{results: []fakePoll{{status: "RUNNING"}}}
+		_, err := waitForOperation(ctx, ops, "p", "z", &compute.Operation{Name: "op", Status: "RUNNING"})
+		if !errors.Is(err, errOperationTimeout) {
+			t.Fatalf("Expected errOperationTimeout, got %v", err)
+		}
+	})
+}
+
+func TestOperationWarnings(t *testing.T) {
+	before := operationsWithWarnings.value.Load()
+
+	if warnings := operationWarnings(&compute.Operation{Name: "op", Status: "DONE"}); warnings != nil {
+		t.Fatalf("Expected no warnings, got %v", warnings)
+	}
+
+	ops := &fakeOperations{results: []fakePoll{{status: "DONE"}}}
+	op, err := waitForOperation(t.Context(), ops, "p", "z", &compute.Operation{
+		Name:   "op",
+		Status: "DONE",
+		Warnings: []*compute.OperationWarnings{{
+			Code:    "DEPRECATED_RESOURCE_USED",
+			Message: "machine type n1-standard-1 is deprecated",
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Expected warnings not to fail the operation, got %v", err)
+	}
+
+	warnings := operationWarnings(op)
+	if len(warnings) != 1 || warnings[0] != "DEPRECATED_RESOURCE_USED: machine type n1-standard-1 is deprecated" {
+		t.Fatalf("Unexpected warnings %v", warnings)
+	}
+	if got := operationsWithWarnings.value.Load() - before; got != 1 {
+		t.Fatalf("Expected 1 operation with warnings counted, got %d", got)
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// githubActionsSource is the name of the activity source watching the runner
+	githubActionsSource = "github_actions"
+	// jobCompletedMarker is what the runner logs when it finishes a job, e.g. "Job build completed with result: Succeeded"
+	jobCompletedMarker = "completed with result"
+)
+
+var (
+	postJobMu sync.Mutex
+	// postJobTimer suspends POST_JOB_TIMEOUT after the last job finished, it doesn't care about pings
+	postJobTimer  *time.Timer
+	postJobDue    time.Time
+	lastJobFinish time.Time
+	// runnerWasBusy is what the runner status file said at the last check
+	runnerWasBusy bool
+)
+
+// recordJobCompletion notes that the runner finished a job at at and, with POST_JOB_TIMEOUT set, starts the post-job timer from then
+// The same completion seen again, e.g. in the runner's logs on the next check, is ignored
+func recordJobCompletion(at time.Time) {
+	timeout := config().PostJobTimeout
+
+	postJobMu.Lock()
+	defer postJobMu.Unlock()
+
+	if !at.After(lastJobFinish) {
+		return
+	}
+	lastJobFinish = at
+	if timeout <= 0 {
+		return
+	}
+
+	if postJobTimer != nil {
+		postJobTimer.Stop()
+	}
+	delay := max(timeout-time.Since(at), 0)
+	postJobDue = time.Now().Add(delay)
+	postJobTimer = time.AfterFunc(delay, func() { postJobTimeout(at) })
+
+	slog.Info("Runner finished a job, starting post-job timer",
+		"completed_at", at,
+		"post_job_timeout_seconds", int(timeout.Seconds()),
+		"suspend_in_seconds", int(delay.Seconds()))
+}
+
+// recordRunnerStatus tracks what the runner status file says, a busy runner going idle has finished a job
+func recordRunnerStatus(busy bool, now time.Time) {
+	postJobMu.Lock()
+	finished := runnerWasBusy && !busy
+	runnerWasBusy = busy
+	postJobMu.Unlock()
+
+	if finished {
+		recordJobCompletion(now)
+	}
+}
+
+// recordRunnerLogLine treats a runner log line announcing a finished job as a job completion at the line's time
+func recordRunnerLogLine(line string, at time.Time) {
+	if strings.Contains(line, jobCompletedMarker) {
+		recordJobCompletion(at)
+	}
+}
+
+// lastJobCompletion returns when the runner last finished a job and when the post-job timer suspends, either may be zero
+func lastJobCompletion() (completed, due time.Time) {
+	postJobMu.Lock()
+	defer postJobMu.Unlock()
+
+	if postJobTimer == nil {
+		return lastJobFinish, time.Time{}
+	}
+	return lastJobFinish, postJobDue
+}
+
+// resetPostJobTimer stops the post-job timer and forgets the runner's jobs
+func resetPostJobTimer() {
+	postJobMu.Lock()
+	defer postJobMu.Unlock()
+
+	if postJobTimer != nil {
+		postJobTimer.Stop()
+		postJobTimer = nil
+	}
+	postJobDue = time.Time{}
+	lastJobFinish = time.Time{}
+	runnerWasBusy = false
+}
+
+// postJobTimeout suspends once POST_JOB_TIMEOUT has passed since the job that finished at completedAt,
+// unless the runner has picked up another job since
+func postJobTimeout(completedAt time.Time) {
+	firedAt := time.Now()
+
+	postJobMu.Lock()
+	superseded := !lastJobFinish.Equal(completedAt)
+	if !superseded {
+		postJobTimer = nil
+	}
+	postJobMu.Unlock()
+
+	if superseded {
+		return
+	}
+	if keepOnline() || draining.Load() {
+		slog.Info("Post-job timeout reached but not suspending", "keep_online", keepOnline(), "draining", draining.Load())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// Anything the runner did after the job finished is a new job
+	for _, source := range activitySources {
+		if source.Name() != githubActionsSource {
+			continue
+		}
+		if lastActivity, err := checkSource(ctx, source); err == nil && lastActivity.After(completedAt) {
+			slog.Info("Runner started another job, post-job timer stopped")
+			recordDecision("stay_online", "runner started another job")
+			return
+		}
+	}
+
+	var runner *githubRunner
+	if config().GitHubToken != "" {
+		var err error
+		runner, err = checkGitHubRunnerIdle(ctx)
+		if errors.Is(err, errRunnerBusy) {
+			slog.Info("Staying online, GitHub runner is busy", "runner", runner.Name)
+			recordDecision("stay_online", "github runner busy")
+			return
+		} else if err != nil {
+			if !config().GitHubSuspendOnUnknown {
+				slog.Warn("Staying online, could not determine GitHub runner state", "error", err)
+				recordDecision("stay_online", "github runner state unknown")
+				return
+			}
+			slog.Warn("Could not determine GitHub runner state, suspending anyway", "error", err)
+		}
+	}
+
+	proceedWithSuspend(firedAt, "post-job timeout", runner, "job_completed_at", completedAt)
+}
+
+// watchRunnerJobs checks the runner every POST_JOB_POLL_INTERVAL until ctx is cancelled, so a finished job
+// starts the post-job timer even while pings keep the inactivity timer from looking at the runner
+func watchRunnerJobs(ctx context.Context) {
+	cfg := config()
+
+	var source ActivitySource
+	for _, s := range activitySources {
+		if s.Name() == githubActionsSource {
+			source = s
+		}
+	}
+	if source == nil {
+		slog.Warn("POST_JOB_TIMEOUT is set but there is no runner status file or docker to watch the runner with")
+		return
+	}
+
+	slog.Info("Watching runner for finished jobs",
+		"post_job_timeout_seconds", int(cfg.PostJobTimeout.Seconds()),
+		"poll_interval_seconds", int(cfg.PostJobPollInterval.Seconds()))
+
+	ticker := time.NewTicker(cfg.PostJobPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			if _, err := checkSource(checkCtx, source); err != nil && ctx.Err() == nil {
+				slog.Debug("Could not check runner for finished jobs", "error", err)
+			}
+			cancel()
+		}
+	}
+}
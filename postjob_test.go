@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+// useRunnerStatusFile points GITHUB_RUNNER_STATUS_FILE at a temp file holding status and returns a function to change it
+func useRunnerStatusFile(t *testing.T, status string) func(string) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "runner-status")
+	write := func(status string) {
+		if err := os.WriteFile(path, []byte(status), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(status)
+	updateConfig(func(cfg *Config) { cfg.GitHubRunnerStatusFile = path })
+	activitySources = []ActivitySource{sourceFunc{name: githubActionsSource, fn: githubActionsActivity}}
+	return write
+}
+
+func TestPostJobTimeoutSuspendsAfterJob(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		updateConfig(func(cfg *Config) { cfg.PostJobTimeout = 10 * time.Minute })
+		setStatus := useRunnerStatusFile(t, "busy")
+
+		if _, err := githubActionsActivity(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+		setStatus("idle")
+		if _, err := githubActionsActivity(t.Context()); err != nil {
+			t.Fatal(err)
+		}
+		if completed, due := lastJobCompletion(); completed.IsZero() || due.IsZero() {
+			t.Fatal("Expected the busy to idle change to start the post-job timer")
+		}
+
+		// Pings don't hold the post-job timer off
+		time.Sleep(9 * time.Minute)
+		recordActivity(time.Now())
+		if mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspended before POST_JOB_TIMEOUT")
+		}
+
+		time.Sleep(2 * time.Minute)
+		synctest.Wait()
+		if !mockGCP.WasSuspendCalled() {
+			t.Fatal("Expected a suspend once POST_JOB_TIMEOUT passed")
+		}
+	})
+}
+
+func TestPostJobTimeoutNewJob(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		updateConfig(func(cfg *Config) { cfg.PostJobTimeout = 10 * time.Minute })
+		setStatus := useRunnerStatusFile(t, "busy")
+
+		_, _ = githubActionsActivity(t.Context())
+		setStatus("idle")
+		_, _ = githubActionsActivity(t.Context())
+
+		// The runner picks up another job before the timer runs out
+		time.Sleep(5 * time.Minute)
+		setStatus("busy")
+
+		time.Sleep(6 * time.Minute)
+		synctest.Wait()
+		if mockGCP.WasSuspendCalled() {
+			t.Fatal("Should not suspend while the runner is running another job")
+		}
+	})
+}
+
+func TestRunnerLogJobCompletion(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	at := time.Now().Add(-time.Minute).Truncate(time.Second)
+	recordRunnerLogLine("12:00:00: Listening for Jobs", at.Add(-time.Hour))
+	if completed, _ := lastJobCompletion(); !completed.IsZero() {
+		t.Fatal("Only finished jobs are completions")
+	}
+
+	recordRunnerLogLine("12:00:00: Job build completed with result: Succeeded", at)
+	completed, due := lastJobCompletion()
+	if !completed.Equal(at) {
+		t.Fatalf("Expected the completion at %v, got %v", at, completed)
+	}
+	if !due.IsZero() {
+		t.Fatal("Expected no post-job timer without POST_JOB_TIMEOUT")
+	}
+}
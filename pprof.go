@@ -0,0 +1,16 @@
+package main
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// registerPprof adds the net/http/pprof handlers under /debug/pprof/, behind an admin token with the debug scope
+// Profiles may run longer than the server's write timeout, pprof extends the deadline for them itself
+func registerPprof(handle func(pattern string, handler http.HandlerFunc)) {
+	handle("/debug/pprof/", requireAdmin(scopeDebug, pprof.Index))
+	handle("GET /debug/pprof/cmdline", requireAdmin(scopeDebug, pprof.Cmdline))
+	handle("/debug/pprof/symbol", requireAdmin(scopeDebug, pprof.Symbol))
+	handle("GET /debug/pprof/profile", requireAdmin(scopeDebug, pprof.Profile))
+	handle("GET /debug/pprof/trace", requireAdmin(scopeDebug, pprof.Trace))
+}
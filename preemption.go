@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var (
+	// preemptedURL and preemptionRetryDelay are swapped out in tests
+	preemptedURL         = "http://metadata.google.internal/computeMetadata/v1/instance/preempted"
+	preemptionRetryDelay = 5 * time.Second
+
+	// metadataClient has no timeout, preemption checks hang until the value changes
+	metadataClient = &http.Client{}
+
+	preemptions = &counter{
+		name: "lightsout_preemptions_total",
+		help: "Spot preemption notices received from the metadata server.",
+	}
+)
+
+// watchPreemption waits on the metadata server's preempted value until ctx is cancelled
+// and shuts down gracefully as soon as it flips, GCE only gives a preempted instance about 30 seconds
+func watchPreemption(ctx context.Context) {
+	slog.Info("Watching for spot preemption")
+
+	etag := ""
+	for {
+		preempted, next, err := checkPreempted(ctx, etag)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Warn("Failed to check for preemption", "error", err)
+			etag = ""
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(preemptionRetryDelay):
+			}
+			continue
+		}
+		if preempted {
+			handlePreemption()
+			return
+		}
+		etag = next
+	}
+}
+
+// checkPreempted reads the preempted value, waiting for it to change from etag if one is given
+// It returns the value's new etag to wait on next
+func checkPreempted(ctx context.Context, etag string) (bool, string, error) {
+	target := preemptedURL
+	if etag != "" {
+		target += "?" + url.Values{"wait_for_change": {"true"}, "last_etag": {etag}}.Encode()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return false, "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+
+	resp, err := metadataClient.Do(req)
+	if err != nil {
+		return false, "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, "", fmt.Errorf("metadata server returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64))
+	if err != nil {
+		return false, "", err
+	}
+
+	return strings.TrimSpace(string(body)) == "TRUE", resp.Header.Get("ETag"), nil
+}
+
+// reasonPreempted is the suspend reason of a spot preemption, it outranks every other trigger
+const reasonPreempted = "preempted"
+
+// handlePreemption shuts down through requestSuspend, so a suspend another trigger already started is joined rather than raced
+func handlePreemption() {
+	preemptions.inc()
+	slog.Warn("Spot preemption notice received, shutting down gracefully")
+	recordDecision("preempted", "spot preemption notice")
+
+	stopShutdownTimer()
+	_ = cancelPendingSuspend("")
+
+	var runner *githubRunner
+	if config().GitHubToken != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		var err error
+		// A busy runner is about to lose its job either way, deregister it too
+		runner, err = checkGitHubRunnerIdle(ctx)
+		cancel()
+		if runner == nil {
+			slog.Warn("Could not look up the GitHub runner", "error", err)
+		}
+	}
+
+	_ = requestSuspend(reasonPreempted, runner)
+}
+
+// preemptedShutdown runs the shutdown stages that still matter on a preempted instance and stops the servers
+// GCE stops the instance itself, so there is no suspend, and nothing is left to abort: whatever fails we are going down
+func preemptedShutdown(reason string, runner *githubRunner) error {
+	ctx, cancel := context.WithTimeout(context.Background(), config().ShutdownTimeout)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	shutdownDeadline.Store(deadline.UnixNano())
+
+	_ = runShutdownStages(ctx, preSuspendStages(reason, runner))
+	signalServerShutdown()
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useFakeMetadata serves preempted values in order, then hangs like a wait_for_change request that never sees one
+func useFakeMetadata(t *testing.T, responses ...string) *atomic.Int32 {
+	t.Helper()
+
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Metadata-Flavor") != "Google" {
+			http.Error(w, "missing Metadata-Flavor", http.StatusForbidden)
+			return
+		}
+		n := int(calls.Add(1))
+		if n > 1 && r.URL.Query().Get("last_etag") == "" && responses[n-2] != "error" {
+			http.Error(w, "expected to wait on the last etag", http.StatusBadRequest)
+			return
+		}
+		if n > len(responses) {
+			<-r.Context().Done()
+			return
+		}
+		if responses[n-1] == "error" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("ETag", "etag-"+strconv.Itoa(n))
+		_, _ = w.Write([]byte(responses[n-1]))
+	}))
+	t.Cleanup(server.Close)
+
+	origURL, origDelay := preemptedURL, preemptionRetryDelay
+	preemptedURL, preemptionRetryDelay = server.URL, 10*time.Millisecond
+	t.Cleanup(func() { preemptedURL, preemptionRetryDelay = origURL, origDelay })
+
+	return &calls
+}
+
+func TestPreemptionRunsShutdownStages(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	useFakeMetadata(t, "FALSE", "error", "FALSE", "TRUE")
+	hookRan := filepath.Join(t.TempDir(), "hook-ran")
+	updateConfig(func(cfg *Config) {
+		cfg.PreSuspendHook = "touch " + hookRan
+		cfg.HookTimeout = 5 * time.Second
+	})
+
+	suspended := false
+	suspendFunc = func(context.Context) error {
+		suspended = true
+		return nil
+	}
+	before := preemptions.value.Load()
+
+	done := make(chan struct{})
+	go func() {
+		watchPreemption(context.Background())
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watcher did not return after the preemption notice")
+	}
+
+	select {
+	case <-serverShutdown:
+	default:
+		t.Fatal("Expected the servers to drain after a preemption")
+	}
+	if _, err := os.Stat(hookRan); err != nil {
+		t.Fatalf("Expected the pre-suspend hook to run: %v", err)
+	}
+	if suspended {
+		t.Fatal("A preempted instance shouldn't be suspended")
+	}
+	if got := preemptions.value.Load() - before; got != 1 {
+		t.Fatalf("Expected one preemption counted, got %d", got)
+	}
+}
+
+func TestPreemptionWatcherStops(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	calls := useFakeMetadata(t, "FALSE")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchPreemption(ctx)
+		close(done)
+	}()
+
+	// Wait until the watcher is blocked on a change that never comes
+	for calls.Load() < 2 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Watcher did not stop when cancelled")
+	}
+	select {
+	case <-serverShutdown:
+		t.Fatal("Servers shouldn't drain without a preemption")
+	default:
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"errors"
+	"log/slog"
+)
+
+// Providers for PROVIDER, what actually suspends the machine
+const (
+	providerGCP = "gcp"
+	// providerNoop only logs the suspends it would have done, for running lightsout on a laptop to try a config out
+	providerNoop = "noop"
+)
+
+// errNoopProvider means the suspend was only logged because PROVIDER is noop
+var errNoopProvider = errors.New("noop provider, nothing was suspended")
+
+// defaultProvider picks noop when nothing says which instance to suspend, GCP otherwise
+// Partial GCP config still picks GCP so the missing settings get reported
+func defaultProvider(cfg *Config) string {
+	if len(missingGCPConfig(cfg)) == 3 && !cfg.LibOpsKeepOnline {
+		return providerNoop
+	}
+	return providerGCP
+}
+
+// noopSuspend stands in for the suspend under the noop provider: it logs what would have happened
+// and starts another inactivity window so the timer can be watched firing again
+func noopSuspend(reason string) error {
+	slog.Info("Noop provider, would suspend now", "reason", reason)
+	recordDecision("would_suspend", reason+" (noop provider)")
+	resetShutdownTimer()
+	return errNoopProvider
+}
+
+// logProvider explains at startup when suspends won't go anywhere
+func logProvider(cfg *Config) {
+	if cfg.Provider == providerNoop {
+		slog.Warn("No cloud provider configured, using the noop provider: suspends are only logged. " +
+			"Set GCP_PROJECT, GCP_ZONE and GCP_INSTANCE_NAME or PROVIDER=gcp to suspend a real instance")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// reconcileResult is what the last reconcile found, reported on /status
+type reconcileResult struct {
+	Time     time.Time `json:"time"`
+	Status   string    `json:"status,omitempty"`
+	Expected string    `json:"expected"`
+	Drift    bool      `json:"drift"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// runReconcile compares the instance's actual status with what we expect every RECONCILE_INTERVAL until ctx is cancelled
+// It only reports drift, the suspend timer stays in charge of suspending
+func runReconcile(ctx context.Context) {
+	interval := config().ReconcileInterval
+
+	slog.Info("Starting reconcile loop", "interval_seconds", int(interval.Seconds()))
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			slog.Debug("Reconcile loop stopped")
+			return
+		case <-ticker.C:
+			reconcile(ctx)
+		}
+	}
+}
+
+// reconcile fetches the instance once and records whether its status is the one we expect
+// While lightsout is running and hasn't suspended it, the instance should be RUNNING
+func reconcile(ctx context.Context) {
+	cfg := config()
+
+	if len(missingGCPConfig(cfg)) > 0 || isInstanceNotFound() {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	result := reconcileResult{Time: time.Now(), Expected: "RUNNING"}
+
+	instance, _, err := readInstance(ctx)
+	if err == nil {
+		result.Status = instance.Status
+	}
+
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+		slog.Warn("Could not reconcile instance state", "error", err)
+	case result.Status != result.Expected:
+		result.Drift = true
+		slog.Warn("Instance state drifted",
+			"status", result.Status,
+			"expected", result.Expected)
+	default:
+		slog.Debug("Instance state reconciled", "status", result.Status)
+	}
+
+	tracker.mu.Lock()
+	tracker.lastReconcile = &result
+	tracker.mu.Unlock()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestReconcileReportsDrift(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	var status atomic.Value
+	status.Store("RUNNING")
+	useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeComputeJSON(w, compute.Instance{Name: "test-instance", Status: status.Load().(string)})
+	}))
+
+	reconcile(context.Background())
+	result := currentStatus().LastReconcile
+	if result == nil || result.Drift || result.Status != "RUNNING" {
+		t.Fatalf("Expected a RUNNING instance without drift, got %+v", result)
+	}
+
+	status.Store("SUSPENDED")
+	reconcile(context.Background())
+	result = currentStatus().LastReconcile
+	if result == nil || !result.Drift || result.Status != "SUSPENDED" {
+		t.Fatalf("Expected drift for a SUSPENDED instance, got %+v", result)
+	}
+}
+
+func TestReconcileRecordsErrors(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+
+	reconcile(context.Background())
+	result := currentStatus().LastReconcile
+	if result == nil || result.Error == "" || result.Drift {
+		t.Fatalf("Expected the error to be recorded without drift, got %+v", result)
+	}
+}
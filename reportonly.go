@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+// requiredSuspendPermissions are what lightsout needs on its instance to suspend it
+var requiredSuspendPermissions = []string{"compute.instances.get", "compute.instances.suspend"}
+
+var (
+	// reportOnly is set at startup when the credentials can't suspend the instance, suspends are then only reported
+	reportOnly atomic.Bool
+
+	// errReportOnly means the suspend was only reported because the credentials can't make it
+	errReportOnly = errors.New("report-only mode, nothing was suspended")
+)
+
+// checkSuspendPermissions asks IAM whether the credentials hold requiredSuspendPermissions on the instance
+// and switches to report-only mode if not, rather than finding out from a 403 once the machine has gone idle.
+// With FALLBACK_TO_STOP it asks about the stop permission too, but lacking only that leaves suspends working, so it is just a warning.
+// If the check itself fails suspends go ahead as usual
+func checkSuspendPermissions(ctx context.Context) {
+	cfg := config()
+
+	service, err := getComputeService(ctx)
+	if err != nil {
+		slog.Warn("Could not check the suspend permissions", "error", err)
+		return
+	}
+	permissions := slices.Clone(requiredSuspendPermissions)
+	if cfg.FallbackToStop {
+		permissions = append(permissions, fallbackStopPermission)
+	}
+	resp, err := service.Instances.TestIamPermissions(cfg.GoogleProjectID, cfg.GCEZone, cfg.GCEInstance,
+		&compute.TestPermissionsRequest{Permissions: permissions}).Context(ctx).Do()
+	if err != nil {
+		slog.Warn("Could not check the suspend permissions", "error", err)
+		return
+	}
+
+	missing := slices.DeleteFunc(permissions, func(permission string) bool {
+		return slices.Contains(resp.Permissions, permission)
+	})
+	if len(missing) == 0 {
+		slog.Debug("Credentials can suspend the instance")
+		return
+	}
+	if !slices.ContainsFunc(missing, func(permission string) bool {
+		return slices.Contains(requiredSuspendPermissions, permission)
+	}) {
+		slog.Warn("Credentials can't stop the instance, FALLBACK_TO_STOP won't work for it", "missing_permissions", missing)
+		return
+	}
+
+	reportOnly.Store(true)
+	slog.Error("Credentials can't suspend the instance, running in REPORT-ONLY mode: "+
+		"suspends are logged and sent to SUSPEND_WEBHOOK_URL but never made",
+		"missing_permissions", missing)
+}
+
+// reportOnlySuspend stands in for the suspend in report-only mode: it reports what would have happened
+// and starts another inactivity window
+func reportOnlySuspend(reason string) error {
+	slog.Warn("Report-only mode, would suspend now", "reason", reason)
+	recordDecision("would_suspend", reason+" (report-only)")
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookClient.Timeout)
+	defer cancel()
+	if err := notifySuspend(ctx, webhookWouldSuspend, reason, nil); err != nil {
+		slog.Warn("Failed to send suspend webhook", "event", webhookWouldSuspend, "error", err)
+	}
+
+	resetShutdownTimer()
+	return errReportOnly
+}
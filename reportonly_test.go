@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// useFakePermissions answers testIamPermissions with granted, or fails it when granted is nil
+func useFakePermissions(t *testing.T, granted []string) {
+	t.Helper()
+
+	useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/instances/test-instance/testIamPermissions") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if granted == nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		writeComputeJSON(w, map[string]any{"permissions": granted})
+	}))
+}
+
+func TestCheckSuspendPermissions(t *testing.T) {
+	tests := []struct {
+		name         string
+		granted      []string
+		fallbackStop bool
+		reportOnly   bool
+	}{
+		{"all granted", requiredSuspendPermissions, false, false},
+		{"read only", []string{"compute.instances.get"}, false, true},
+		{"none", []string{}, false, true},
+		{"check failed", nil, false, false},
+		{"no stop for the fallback", requiredSuspendPermissions, true, false},
+		{"stop but no suspend", []string{"compute.instances.get", "compute.instances.stop"}, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cleanup := setupTestEnvironment()
+			defer cleanup()
+
+			updateConfig(func(cfg *Config) { cfg.FallbackToStop = tt.fallbackStop })
+			useFakePermissions(t, tt.granted)
+			checkSuspendPermissions(t.Context())
+
+			if got := reportOnly.Load(); got != tt.reportOnly {
+				t.Fatalf("Expected report-only %v, got %v", tt.reportOnly, got)
+			}
+		})
+	}
+}
+
+func TestReportOnlySuspend(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	var events []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhookPayload
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		events = append(events, payload.Event)
+	}))
+	defer server.Close()
+	updateConfig(func(cfg *Config) { cfg.SuspendWebhookURL = server.URL })
+
+	reportOnly.Store(true)
+	err := suspendAndShutdown("inactivity timeout", nil)
+	if !errors.Is(err, errReportOnly) {
+		t.Fatalf("Expected the suspend to only be reported, got %v", err)
+	}
+	if mockGCP.WasSuspendCalled() {
+		t.Fatal("Should not suspend in report-only mode")
+	}
+	if len(events) != 1 || events[0] != webhookWouldSuspend {
+		t.Fatalf("Expected a would_suspend webhook, got %v", events)
+	}
+	select {
+	case <-serverShutdown:
+		t.Fatal("Server should keep running in report-only mode")
+	default:
+	}
+	stopShutdownTimer()
+
+	if !currentStatus().ReportOnly {
+		t.Fatal("Expected /status to show report-only mode")
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+var suspendRetries = &counter{
+	name: "lightsout_suspend_retries_total",
+	help: "Shutdown decisions re-run after a failed suspend.",
+}
+
+// scheduleSuspendRetry re-runs the shutdown decision after SUSPEND_RETRY_INTERVAL rather than giving up on a failed suspend,
+// so a transient API error doesn't leave the machine running for another full window
+// It reports false once SUSPEND_RETRY_ATTEMPTS retries have been used, or when retries are disabled
+func scheduleSuspendRetry() bool {
+	cfg := config()
+
+	if cfg.SuspendRetryInterval <= 0 {
+		return false
+	}
+
+	tracker.mu.Lock()
+	attempt := tracker.suspendRetries + 1
+	if attempt > cfg.SuspendRetryAttempts {
+		tracker.mu.Unlock()
+		return false
+	}
+	tracker.suspendRetries = attempt
+	tracker.mu.Unlock()
+
+	shutdownMutex.Lock()
+	defer shutdownMutex.Unlock()
+
+	// Activity before the retry resets the timer as usual and the retry never happens
+	if shutdownTimer != nil {
+		shutdownTimer.Stop()
+	}
+	shutdownArmedAt = time.Time{}
+	shutdownGeneration++
+	shutdownTimerDue = time.Now().Add(cfg.SuspendRetryInterval)
+	shutdownTimer = time.AfterFunc(cfg.SuspendRetryInterval, initiateShutdown)
+
+	suspendRetries.inc()
+	slog.Warn("Suspend failed, retrying the shutdown decision",
+		"attempt", attempt,
+		"max_attempts", cfg.SuspendRetryAttempts,
+		"retry_in_seconds", int(cfg.SuspendRetryInterval.Seconds()))
+	recordDecision("retry", "suspend failed")
+
+	return true
+}
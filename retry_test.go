@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestSuspendRetriedAfterFailure(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+		defer exitCode.Store(exitOK)
+
+		updateConfig(func(cfg *Config) {
+			cfg.SuspendRetryInterval = 10 * time.Second
+			cfg.SuspendRetryAttempts = 3
+		})
+
+		var attempts atomic.Int32
+		suspendFunc = func(context.Context) error {
+			if attempts.Add(1) < 3 {
+				return errors.New("backend error")
+			}
+			return nil
+		}
+		before := suspendRetries.value.Load()
+
+		initiateShutdown()
+		if attempts.Load() != 1 {
+			t.Fatalf("Expected one suspend attempt, got %d", attempts.Load())
+		}
+		select {
+		case <-serverShutdown:
+			t.Fatal("Server should keep running while a retry is scheduled")
+		default:
+		}
+
+		time.Sleep(config().SuspendRetryInterval + time.Second)
+		synctest.Wait()
+		if attempts.Load() != 2 {
+			t.Fatalf("Expected a retry after the retry interval, got %d attempts", attempts.Load())
+		}
+
+		time.Sleep(config().SuspendRetryInterval)
+		synctest.Wait()
+		if attempts.Load() != 3 {
+			t.Fatalf("Expected a second retry, got %d attempts", attempts.Load())
+		}
+		select {
+		case <-serverShutdown:
+		default:
+			t.Fatal("Server should shut down once the suspend succeeds")
+		}
+		if got := suspendRetries.value.Load() - before; got != 2 {
+			t.Fatalf("Expected 2 retries counted, got %d", got)
+		}
+	})
+}
+
+func TestSuspendRetriesExhausted(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+		defer exitCode.Store(exitOK)
+
+		updateConfig(func(cfg *Config) {
+			cfg.SuspendRetryInterval = 10 * time.Second
+			cfg.SuspendRetryAttempts = 1
+		})
+
+		var attempts atomic.Int32
+		suspendFunc = func(context.Context) error {
+			attempts.Add(1)
+			return errors.New("backend error")
+		}
+
+		initiateShutdown()
+		time.Sleep(config().SuspendRetryInterval + time.Second)
+		synctest.Wait()
+
+		if attempts.Load() != 2 {
+			t.Fatalf("Expected the first attempt and one retry, got %d attempts", attempts.Load())
+		}
+		select {
+		case <-serverShutdown:
+		default:
+			t.Fatal("Server should shut down once the retries are used up")
+		}
+		if exitCode.Load() != exitSuspendFailed {
+			t.Fatalf("Expected the suspend failure exit code, got %d", exitCode.Load())
+		}
+	})
+}
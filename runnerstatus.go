@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// githubLogActivity reads the runner container's logs, it is swapped out in tests
+	githubLogActivity = getLastGitHubActionsActivity
+
+	githubLogCacheMu sync.Mutex
+	// githubLogCache is the last docker logs check, reused for GHA_CHECK_CACHE_TTL
+	githubLogCache *githubLogCheck
+)
+
+// githubLogCheck is the result of one docker logs check of the runner container
+type githubLogCheck struct {
+	checkedAt    time.Time
+	lastActivity time.Time
+	err          error
+}
+
+// errNoRunnerStatus means GITHUB_RUNNER_STATUS_FILE isn't configured or doesn't exist yet
+var errNoRunnerStatus = errors.New("no runner status file")
+
+// runnerStatusFile is the shape of a JSON status file, e.g. {"status": "busy"}
+type runnerStatusFile struct {
+	Status string `json:"status"`
+}
+
+// readRunnerStatus reads GITHUB_RUNNER_STATUS_FILE and reports whether the runner is running a job
+// The file may hold JSON with a status field or just the status, either busy/running or idle/online
+func readRunnerStatus() (bool, error) {
+	path := config().GitHubRunnerStatusFile
+	if path == "" {
+		return false, errNoRunnerStatus
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, errNoRunnerStatus
+	} else if err != nil {
+		return false, fmt.Errorf("failed to read runner status file: %w", err)
+	}
+
+	status := strings.TrimSpace(string(data))
+	var parsed runnerStatusFile
+	if json.Unmarshal(data, &parsed) == nil && parsed.Status != "" {
+		status = parsed.Status
+	}
+
+	switch strings.ToLower(status) {
+	case "busy", "running", "active":
+		return true, nil
+	case "idle", "online", "offline":
+		return false, nil
+	default:
+		return false, fmt.Errorf("unknown runner status %q in %s", status, path)
+	}
+}
+
+// githubActionsActivity prefers the runner's status file and falls back to the runner container's logs when there is none
+func githubActionsActivity(ctx context.Context) (time.Time, error) {
+	busy, err := readRunnerStatus()
+	switch {
+	case errors.Is(err, errNoRunnerStatus):
+		if _, lookErr := exec.LookPath("docker"); lookErr != nil {
+			return time.Time{}, fmt.Errorf("no runner status file and docker not found")
+		}
+		return cachedGitHubLogActivity(ctx)
+	case err != nil:
+		return time.Time{}, err
+	case busy:
+		recordRunnerStatus(true, time.Now())
+		return time.Now(), nil
+	default:
+		recordRunnerStatus(false, time.Now())
+		return time.Time{}, nil
+	}
+}
+
+// cachedGitHubLogActivity checks the runner container's logs at most once per GHA_CHECK_CACHE_TTL,
+// so a timer that fires often on a busy host doesn't exec docker every time
+func cachedGitHubLogActivity(ctx context.Context) (time.Time, error) {
+	ttl := config().GHACheckCacheTTL
+
+	githubLogCacheMu.Lock()
+	defer githubLogCacheMu.Unlock()
+
+	if ttl > 0 && githubLogCache != nil && time.Since(githubLogCache.checkedAt) < ttl {
+		return githubLogCache.lastActivity, githubLogCache.err
+	}
+
+	lastActivity, err := githubLogActivity(ctx)
+	githubLogCache = &githubLogCheck{checkedAt: time.Now(), lastActivity: lastActivity, err: err}
+	return lastActivity, err
+}
+
+// githubLogCacheAge returns how old the cached docker logs check is, false when nothing is cached
+func githubLogCacheAge() (time.Duration, bool) {
+	githubLogCacheMu.Lock()
+	defer githubLogCacheMu.Unlock()
+
+	if githubLogCache == nil || config().GHACheckCacheTTL <= 0 {
+		return 0, false
+	}
+	return time.Since(githubLogCache.checkedAt), true
+}
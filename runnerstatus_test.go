@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestRunnerStatusFile(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) { cfg.GitHubRunnerStatusFile = filepath.Join(t.TempDir(), "status") })
+
+	tests := []struct {
+		content string
+		active  bool
+		wantErr bool
+	}{
+		{"busy\n", true, false},
+		{"idle\n", false, false},
+		{`{"status": "running"}`, true, false},
+		{`{"status": "online"}`, false, false},
+		{"confused", false, true},
+	}
+
+	for _, tt := range tests {
+		if err := os.WriteFile(config().GitHubRunnerStatusFile, []byte(tt.content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		lastActivity, err := githubActionsActivity(context.Background())
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%q: unexpected error %v", tt.content, err)
+		}
+		if lastActivity.IsZero() == tt.active {
+			t.Fatalf("%q: expected active=%t, got %v", tt.content, tt.active, lastActivity)
+		}
+	}
+}
+
+func TestRunnerStatusFileFallsBackToDocker(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) { cfg.GitHubRunnerStatusFile = filepath.Join(t.TempDir(), "missing") })
+	t.Setenv("PATH", t.TempDir())
+
+	// Without the file we fall back to the container's logs, which needs docker
+	if _, err := githubActionsActivity(context.Background()); err == nil {
+		t.Fatal("Expected an error without a status file or docker")
+	}
+
+	// A status file is enough to enable the source without docker
+	if sources := buildActivitySources(); !hasSource(sources, "github_actions") {
+		t.Fatal("Expected the github_actions source with a status file configured")
+	}
+}
+
+func hasSource(sources []ActivitySource, name string) bool {
+	for _, source := range sources {
+		if source.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestGitHubLogCheckCached(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		origLogActivity := githubLogActivity
+		defer func() {
+			githubLogActivity = origLogActivity
+			githubLogCache = nil
+		}()
+
+		checks := 0
+		githubLogActivity = func(context.Context) (time.Time, error) {
+			checks++
+			return time.Now(), nil
+		}
+		updateConfig(func(cfg *Config) { cfg.GHACheckCacheTTL = time.Minute })
+
+		first, _ := cachedGitHubLogActivity(t.Context())
+		time.Sleep(30 * time.Second)
+		second, _ := cachedGitHubLogActivity(t.Context())
+		if checks != 1 || !second.Equal(first) {
+			t.Fatalf("Expected the cached check to be reused within the TTL, got %d checks", checks)
+		}
+		if age := currentStatus().GHACheckAgeSeconds; age == nil || *age != 30 {
+			t.Fatalf("Expected a 30s old check on /status, got %v", age)
+		}
+
+		time.Sleep(31 * time.Second)
+		_, _ = cachedGitHubLogActivity(t.Context())
+		if checks != 2 {
+			t.Fatalf("Expected a fresh check once the TTL passed, got %d checks", checks)
+		}
+	})
+}
+
+func TestGitHubLogsReadConcurrently(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	// Each container logs a different time, and "stuck" never answers
+	script := filepath.Join(t.TempDir(), "docker")
+	content := `#!/bin/sh
+case "$4" in
+  early) echo "00:00:01: Listening for Jobs" ;;
+  late) echo "00:00:02: Running job: build" ;;
+  stuck) exec sleep 10 ;;
+esac
+`
+	if err := os.WriteFile(script, []byte(content), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	origDockerCommand := dockerCommand
+	dockerCommand = script
+	defer func() { dockerCommand = origDockerCommand }()
+
+	updateConfig(func(cfg *Config) {
+		cfg.GHACheckTimeout = 500 * time.Millisecond
+		cfg.GitHubRunnerContainers = []string{"early", "stuck", "late"}
+	})
+
+	start := time.Now()
+	last, err := getLastGitHubActionsActivity(t.Context())
+	if err != nil {
+		t.Fatalf("Expected the readable containers to count, got %v", err)
+	}
+	if last.Second() != 2 {
+		t.Fatalf("Expected the most recent activity across containers, got %v", last)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("Expected GHA_CHECK_TIMEOUT to bound the check, took %s", elapsed)
+	}
+
+	updateConfig(func(cfg *Config) { cfg.GitHubRunnerContainers = []string{"stuck"} })
+	if _, err := getLastGitHubActionsActivity(t.Context()); err == nil {
+		t.Fatal("Expected an error when no container could be read")
+	}
+}
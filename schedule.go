@@ -0,0 +1,235 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stopScheduleWindow is how long after the scheduled time GCP may take to start a scheduled stop
+const stopScheduleWindow = 15 * time.Minute
+
+// instanceStopSchedule describes a GCP instance schedule that stops the instance on its own
+type instanceStopSchedule struct {
+	Policy   string `json:"policy"`
+	Schedule string `json:"schedule"`
+	TimeZone string `json:"time_zone"`
+
+	cron     *cronSchedule
+	location *time.Location
+}
+
+// inStopWindow reports whether GCP may be stopping the instance at now,
+// from a scheduled stop until stopScheduleWindow after it
+func (s *instanceStopSchedule) inStopWindow(now time.Time) bool {
+	local := now.In(s.location).Truncate(time.Minute)
+	for at := local; !at.Before(local.Add(-stopScheduleWindow)); at = at.Add(-time.Minute) {
+		if s.cron.matches(at) {
+			return true
+		}
+	}
+	return false
+}
+
+// cronSchedule is a unix-cron expression as GCP instance schedules use them, one bit set per allowed value
+// of minute, hour, day of month, month and day of week
+type cronSchedule struct {
+	fields [5]uint64
+	// anyDay is set when day of month and day of week aren't both restricted, otherwise cron matches either one
+	anyDay bool
+}
+
+var cronFieldRanges = [5]struct{ min, max int }{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+
+// parseCron parses a spec like "0 22 * * 1-5", with lists, ranges and steps in each field
+func parseCron(spec string) (*cronSchedule, error) {
+	parts := strings.Fields(spec)
+	if len(parts) != len(cronFieldRanges) {
+		return nil, fmt.Errorf("%q: expected minute, hour, day of month, month and day of week", spec)
+	}
+
+	var schedule cronSchedule
+	for i, part := range parts {
+		bounds := cronFieldRanges[i]
+		for item := range strings.SplitSeq(part, ",") {
+			expr, stepText, hasStep := strings.Cut(item, "/")
+			step := 1
+			if hasStep {
+				var err error
+				if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+					return nil, fmt.Errorf("%q: invalid step %q", spec, stepText)
+				}
+			}
+
+			from, to := bounds.min, bounds.max
+			if expr != "*" {
+				first, last, isRange := strings.Cut(expr, "-")
+				var err error
+				if from, err = strconv.Atoi(first); err != nil {
+					return nil, fmt.Errorf("%q: invalid value %q", spec, item)
+				}
+				switch {
+				case isRange:
+					if to, err = strconv.Atoi(last); err != nil {
+						return nil, fmt.Errorf("%q: invalid value %q", spec, item)
+					}
+				case !hasStep:
+					to = from
+				}
+			}
+			if from < bounds.min || to > bounds.max || from > to {
+				return nil, fmt.Errorf("%q: %q is out of range", spec, item)
+			}
+
+			for value := from; value <= to; value += step {
+				schedule.fields[i] |= 1 << value
+			}
+		}
+	}
+
+	// Sunday is both 0 and 7
+	if schedule.fields[4]&(1<<7) != 0 {
+		schedule.fields[4] |= 1
+	}
+	schedule.anyDay = parts[2] == "*" || parts[4] == "*"
+	return &schedule, nil
+}
+
+// matches reports whether t, in the schedule's time zone, is one of its minutes
+func (c *cronSchedule) matches(t time.Time) bool {
+	has := func(field, value int) bool {
+		return c.fields[field]&(1<<value) != 0
+	}
+	if !has(0, t.Minute()) || !has(1, t.Hour()) || !has(3, int(t.Month())) {
+		return false
+	}
+
+	dayOfMonth, dayOfWeek := has(2, t.Day()), has(4, int(t.Weekday()))
+	if c.anyDay {
+		return dayOfMonth && dayOfWeek
+	}
+	return dayOfMonth || dayOfWeek
+}
+
+// parseResourcePolicyURL returns the region and name from a resource policy URL like
+// https://www.googleapis.com/compute/v1/projects/p/regions/us-central1/resourcePolicies/name
+func parseResourcePolicyURL(url string) (project, region, name string, err error) {
+	parts := strings.Split(strings.Trim(url, "/"), "/")
+	for i := 0; i+1 < len(parts); i++ {
+		switch parts[i] {
+		case "projects":
+			project = parts[i+1]
+		case "regions":
+			region = parts[i+1]
+		case "resourcePolicies":
+			name = parts[i+1]
+		}
+	}
+
+	if project == "" || region == "" || name == "" {
+		return "", "", "", fmt.Errorf("unrecognized resource policy %q", url)
+	}
+	return project, region, name, nil
+}
+
+// detectStopSchedule looks for an instance schedule resource policy with a stop schedule attached to the instance
+func detectStopSchedule(ctx context.Context) (*instanceStopSchedule, error) {
+	cfg := config()
+
+	service, err := getComputeService(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("createComputeService: %w", err)
+	}
+
+	instance, err := service.Instances.Get(cfg.GoogleProjectID, cfg.GCEZone, cfg.GCEInstance).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance: %w", err)
+	}
+
+	for _, url := range instance.ResourcePolicies {
+		project, region, name, err := parseResourcePolicyURL(url)
+		if err != nil {
+			return nil, err
+		}
+
+		policy, err := service.ResourcePolicies.Get(project, region, name).Context(ctx).Do()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get resource policy %s: %w", name, err)
+		}
+
+		if policy.InstanceSchedulePolicy != nil && policy.InstanceSchedulePolicy.VmStopSchedule != nil {
+			schedule := &instanceStopSchedule{
+				Policy:   name,
+				Schedule: policy.InstanceSchedulePolicy.VmStopSchedule.Schedule,
+				TimeZone: policy.InstanceSchedulePolicy.TimeZone,
+			}
+			if schedule.cron, err = parseCron(schedule.Schedule); err != nil {
+				return nil, fmt.Errorf("stop schedule of %s: %w", name, err)
+			}
+			if schedule.location, err = time.LoadLocation(schedule.TimeZone); err != nil {
+				return nil, fmt.Errorf("time zone of %s: %w", name, err)
+			}
+			return schedule, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// checkInstanceSchedule looks up the instance's stop schedule, so that suspends are left to GCP around its stops
+// A failed check keeps whatever schedule was found before
+func checkInstanceSchedule(ctx context.Context) {
+	schedule, err := detectStopSchedule(ctx)
+	if err != nil {
+		slog.Warn("Could not check for an instance schedule", "error", err)
+		return
+	}
+
+	tracker.mu.Lock()
+	previous := tracker.stopSchedule
+	tracker.stopSchedule = schedule
+	tracker.mu.Unlock()
+
+	switch {
+	case schedule == nil && previous != nil:
+		slog.Info("Instance stop schedule removed", "policy", previous.Policy)
+	case schedule == nil:
+		slog.Debug("No instance stop schedule attached")
+	case previous == nil || previous.Policy != schedule.Policy || previous.Schedule != schedule.Schedule || previous.TimeZone != schedule.TimeZone:
+		slog.Info("Instance has a GCP-managed stop schedule, leaving suspension to GCP around its stops",
+			"policy", schedule.Policy,
+			"schedule", schedule.Schedule,
+			"time_zone", schedule.TimeZone,
+			"window", stopScheduleWindow)
+	}
+}
+
+// watchInstanceSchedule checks the instance schedule again every INSTANCE_SCHEDULE_REFRESH,
+// so a schedule attached, changed or removed after startup is picked up
+func watchInstanceSchedule(ctx context.Context) {
+	ticker := time.NewTicker(config().InstanceScheduleRefresh)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+			checkInstanceSchedule(checkCtx)
+			cancel()
+		}
+	}
+}
+
+// inStopScheduleWindow reports whether GCP's stop schedule may be stopping the instance at now
+func inStopScheduleWindow(now time.Time) bool {
+	tracker.mu.RLock()
+	schedule := tracker.stopSchedule
+	tracker.mu.RUnlock()
+
+	return schedule != nil && schedule.inStopWindow(now)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestParseResourcePolicyURL(t *testing.T) {
+	project, region, name, err := parseResourcePolicyURL("https://www.googleapis.com/compute/v1/projects/test-project/regions/us-central1/resourcePolicies/nightly-stop")
+	if err != nil {
+		t.Fatalf("parseResourcePolicyURL: %v", err)
+	}
+	if project != "test-project" || region != "us-central1" || name != "nightly-stop" {
+		t.Fatalf("Unexpected parse: %s %s %s", project, region, name)
+	}
+
+	if _, _, _, err := parseResourcePolicyURL("nightly-stop"); err == nil {
+		t.Fatal("Expected an error for a bare policy name")
+	}
+}
+
+func TestInstanceStopScheduleHoldsOffSuspension(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	// Every minute is a scheduled stop, so any time is inside the stop window
+	var attached atomic.Bool
+	attached.Store(true)
+	useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/instances/test-instance"):
+			instance := compute.Instance{Name: "test-instance", Status: "RUNNING"}
+			if attached.Load() {
+				instance.ResourcePolicies = []string{
+					"https://www.googleapis.com/compute/v1/projects/test-project/regions/test-region/resourcePolicies/nightly-stop",
+				}
+			}
+			writeComputeJSON(w, instance)
+		case strings.HasSuffix(r.URL.Path, "/regions/test-region/resourcePolicies/nightly-stop"):
+			writeComputeJSON(w, compute.ResourcePolicy{
+				Name: "nightly-stop",
+				InstanceSchedulePolicy: &compute.ResourcePolicyInstanceSchedulePolicy{
+					TimeZone:       "America/New_York",
+					VmStopSchedule: &compute.ResourcePolicyInstanceSchedulePolicySchedule{Schedule: "* * * * *"},
+				},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer stopShutdownTimer()
+
+	checkInstanceSchedule(t.Context())
+
+	status := currentStatus()
+	if status.StopSchedule == nil || status.StopSchedule.Schedule != "* * * * *" {
+		t.Fatalf("Expected /status to report the stop schedule, got %+v", status.StopSchedule)
+	}
+
+	initiateShutdown()
+	if mockGCP.WasSuspendCalled() {
+		t.Fatal("Suspension should be left to GCP inside its stop window")
+	}
+	shutdownMutex.Lock()
+	timerRunning := shutdownTimer != nil
+	shutdownMutex.Unlock()
+	if !timerRunning {
+		t.Fatal("The shutdown timer should keep running around a scheduled stop")
+	}
+
+	// A schedule detached later is picked up by the next check
+	attached.Store(false)
+	checkInstanceSchedule(t.Context())
+	if currentStatus().StopSchedule != nil {
+		t.Fatal("Expected the stop schedule to be cleared once it is detached")
+	}
+	initiateShutdown()
+	if !mockGCP.WasSuspendCalled() {
+		t.Fatal("Suspension should go ahead once the stop schedule is gone")
+	}
+}
+
+func TestStopScheduleWindow(t *testing.T) {
+	cron, err := parseCron("0 22 * * 1-5")
+	if err != nil {
+		t.Fatalf("parseCron: %v", err)
+	}
+	location, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schedule := &instanceStopSchedule{cron: cron, location: location}
+
+	// 2024-05-01 is a Wednesday, 2024-05-04 a Saturday
+	tests := []struct {
+		at   string
+		want bool
+	}{
+		{"2024-05-01T21:59:00-04:00", false},
+		{"2024-05-01T22:00:00-04:00", true},
+		{"2024-05-02T02:14:30Z", true},
+		{"2024-05-01T22:16:00-04:00", false},
+		{"2024-05-04T22:05:00-04:00", false},
+	}
+	for _, tt := range tests {
+		at, err := time.Parse(time.RFC3339, tt.at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := schedule.inStopWindow(at); got != tt.want {
+			t.Fatalf("inStopWindow(%s): expected %v, got %v", tt.at, tt.want, got)
+		}
+	}
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		spec  string
+		at    string
+		match bool
+	}{
+		{"*/15 * * * *", "2024-05-01T10:45:00Z", true},
+		{"*/15 * * * *", "2024-05-01T10:46:00Z", false},
+		{"30 8,18 * * *", "2024-05-01T18:30:00Z", true},
+		{"0 0 * * 7", "2024-05-05T00:00:00Z", true},
+		{"0 0 1 * 1", "2024-05-06T00:00:00Z", true},
+		{"0 0 1 * 1", "2024-05-07T00:00:00Z", false},
+		{"0 0 * 6 *", "2024-05-01T00:00:00Z", false},
+	}
+	for _, tt := range tests {
+		cron, err := parseCron(tt.spec)
+		if err != nil {
+			t.Fatalf("parseCron(%q): %v", tt.spec, err)
+		}
+		at, _ := time.Parse(time.RFC3339, tt.at)
+		if got := cron.matches(at); got != tt.match {
+			t.Fatalf("%q at %s: expected %v, got %v", tt.spec, tt.at, tt.match, got)
+		}
+	}
+
+	for _, spec := range []string{"", "0 22 * *", "60 * * * *", "0 22 * * mon", "*/0 * * * *", "5-1 * * * *"} {
+		if _, err := parseCron(spec); err == nil {
+			t.Fatalf("Expected an error for %q", spec)
+		}
+	}
+}
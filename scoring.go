@@ -0,0 +1,63 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+// activityScore is an exponentially decaying count of pings
+// Each ping adds one and the score halves every ACTIVITY_SCORE_HALF_LIFE, so bursts of traffic
+// keep the machine online for longer than a single ping would
+type activityScore struct {
+	value   float64
+	updated time.Time
+}
+
+// at returns the decayed score at now
+func (s activityScore) at(now time.Time, halfLife time.Duration) float64 {
+	if s.value == 0 || halfLife <= 0 {
+		return 0
+	}
+	elapsed := now.Sub(s.updated).Seconds()
+	return s.value * math.Exp2(-elapsed/halfLife.Seconds())
+}
+
+// recordScoredPing adds a ping to the activity score
+// The caller must hold tracker.mu
+func recordScoredPing(now time.Time) {
+	tracker.score = activityScore{
+		value:   tracker.score.at(now, config().ActivityScoreHalfLife) + 1,
+		updated: now,
+	}
+}
+
+// currentActivityScore returns the decayed activity score
+func currentActivityScore(now time.Time) float64 {
+	tracker.mu.RLock()
+	defer tracker.mu.RUnlock()
+
+	return tracker.score.at(now, config().ActivityScoreHalfLife)
+}
+
+// timeUntilBelowThreshold returns how long until the activity score decays below ACTIVITY_SCORE_THRESHOLD
+func timeUntilBelowThreshold(now time.Time) time.Duration {
+	cfg := config()
+
+	score := currentActivityScore(now)
+	if score < cfg.ActivityScoreThreshold || cfg.ActivityScoreThreshold <= 0 {
+		return 0
+	}
+
+	halfLives := math.Log2(score / cfg.ActivityScoreThreshold)
+	// round up so the timer never fires a hair before the score has actually dropped
+	return time.Duration(halfLives*float64(cfg.ActivityScoreHalfLife)) + time.Second
+}
+
+// shutdownDelay returns how long the shutdown timer should wait
+// With scoring enabled the score must stay below the threshold for the whole inactivity timeout
+func shutdownDelay(timeout time.Duration) time.Duration {
+	if !config().ActivityScoring {
+		return timeout
+	}
+	return timeUntilBelowThreshold(time.Now()) + timeout
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"math"
+	"net/http/httptest"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestActivityScoreDecay(t *testing.T) {
+	now := time.Now()
+	score := activityScore{value: 8, updated: now}
+
+	if got := score.at(now.Add(2*time.Minute), time.Minute); math.Abs(got-2) > 1e-9 {
+		t.Fatalf("Expected score 2 after two half lives, got %f", got)
+	}
+	if got := (activityScore{}).at(now, time.Minute); got != 0 {
+		t.Fatalf("Expected zero score, got %f", got)
+	}
+}
+
+func TestBurstyPingsExtendScoredTimeout(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		updateConfig(func(cfg *Config) {
+			cfg.ActivityScoring = true
+			cfg.ActivityScoreHalfLife = time.Minute
+			cfg.ActivityScoreThreshold = 1
+		})
+
+		// A burst of four pings decays below the threshold after two half lives
+		for i := 0; i < 4; i++ {
+			pingHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/ping", nil))
+		}
+
+		if status := currentStatus(); status.ActivityScore == nil || *status.ActivityScore != 4 {
+			t.Fatalf("Expected activity score 4 on /status, got %v", status.ActivityScore)
+		}
+
+		time.Sleep(2*time.Minute + config().InactivityTimeout - 5*time.Second)
+		if mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should wait for the score to stay below the threshold for the full timeout")
+		}
+
+		time.Sleep(10 * time.Second)
+		if !mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should be called once the score has been low for the full timeout")
+		}
+	})
+}
+
+func TestScoringDisabledKeepsResetTimer(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		for i := 0; i < 4; i++ {
+			pingHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/ping", nil))
+		}
+
+		if currentStatus().ActivityScore != nil {
+			t.Fatal("Activity score should not be reported when scoring is disabled")
+		}
+
+		time.Sleep(config().InactivityTimeout + time.Second)
+		if !mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should be called after the plain inactivity timeout")
+		}
+	})
+}
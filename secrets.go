@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/option"
+	secretmanager "google.golang.org/api/secretmanager/v1"
+)
+
+// secretPrefix marks an env value as a Secret Manager reference, e.g. sm://projects/p/secrets/name/versions/latest
+const secretPrefix = "sm://"
+
+var (
+	// secretCache holds resolved references so a SIGHUP reload doesn't fetch them again
+	secretCacheMu sync.Mutex
+	secretCache   = make(map[string]string)
+
+	// accessSecret is swapped out in tests to avoid calling Secret Manager
+	accessSecret = accessSecretVersion
+)
+
+// accessSecretVersion fetches a secret version's payload using Application Default Credentials
+func accessSecretVersion(ctx context.Context, name string) (string, error) {
+	creds, err := findCredentials(ctx, secretmanager.CloudPlatformScope)
+	if err != nil {
+		return "", fmt.Errorf("failed to find default credentials: %w", err)
+	}
+
+	service, err := secretmanager.NewService(ctx, option.WithCredentials(creds))
+	if err != nil {
+		return "", fmt.Errorf("failed to create secret manager service: %w", err)
+	}
+
+	resp, err := service.Projects.Secrets.Versions.Access(name).Context(ctx).Do()
+	if err != nil {
+		return "", fmt.Errorf("failed to access secret %s: %w", name, err)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(resp.Payload.Data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode secret %s: %w", name, err)
+	}
+	return string(data), nil
+}
+
+// resolveSecret returns value, or the secret it references if it starts with sm://
+func resolveSecret(value string) (string, error) {
+	name, ok := strings.CutPrefix(value, secretPrefix)
+	if !ok {
+		return value, nil
+	}
+
+	secretCacheMu.Lock()
+	defer secretCacheMu.Unlock()
+
+	if secret, ok := secretCache[name]; ok {
+		return secret, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	secret, err := accessSecret(ctx, name)
+	if err != nil {
+		return "", err
+	}
+	// Secrets written with a trailing newline by `gcloud secrets create --data-file` shouldn't end up in headers
+	secret = strings.TrimRight(secret, "\r\n")
+	secretCache[name] = secret
+
+	return secret, nil
+}
+
+// withoutURL drops the request URL from an HTTP client error, for URLs that carry a secret like CALENDAR_ICS_URL or HEARTBEAT_URL
+func withoutURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s request: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
+
+// urlHost is what we log of a URL that carries a secret, its host or [redacted] when it doesn't parse
+func urlHost(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "[redacted]"
+	}
+	return u.Host
+}
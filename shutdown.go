@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+// Shutdown runs as a fixed pipeline:
+//
+//	remove_runner, pre_suspend_hook, notify_pre_suspend, stop_containers, suspend, notify_post_suspend, server_drain
+//
+// The stages before the suspend continue past a failure unless they are listed in SHUTDOWN_ABORT_ON, in which case
+// the suspend is called off and the inactivity timer starts over. The suspend's own failures are handled by
+// suspendAndShutdown, and nothing after it can be called off since the machine is already on its way down.
+const (
+	stageRemoveRunner     = "remove_runner"
+	stagePreSuspendHook   = "pre_suspend_hook"
+	stageNotifyPreSuspend = "notify_pre_suspend"
+	stageStopContainers   = "stop_containers"
+)
+
+// shutdownDeadline is when the SHUTDOWN_TIMEOUT of the last suspend runs out, in Unix nanoseconds, 0 before any suspend
+var shutdownDeadline atomic.Int64
+
+// shutdownContext bounds draining the servers at exit by what is left of the suspend's SHUTDOWN_TIMEOUT,
+// or a full SHUTDOWN_TIMEOUT when we are exiting without having suspended
+func shutdownContext() (context.Context, context.CancelFunc) {
+	if deadline := shutdownDeadline.Load(); deadline != 0 {
+		return context.WithDeadline(context.Background(), time.Unix(0, deadline))
+	}
+	return context.WithTimeout(context.Background(), config().ShutdownTimeout)
+}
+
+// abortableStages are the stages SHUTDOWN_ABORT_ON may name, in the order they run
+var abortableStages = []string{stageRemoveRunner, stagePreSuspendHook, stageNotifyPreSuspend, stageStopContainers}
+
+// shutdownStage is one step of the work done before suspending
+type shutdownStage struct {
+	name    string
+	timeout time.Duration
+	run     func(ctx context.Context) error
+}
+
+// stageAbortedError means a stage listed in SHUTDOWN_ABORT_ON failed and the suspend was called off
+type stageAbortedError struct {
+	stage string
+	err   error
+}
+
+func (e *stageAbortedError) Error() string {
+	return fmt.Sprintf("shutdown aborted by %s: %v", e.stage, e.err)
+}
+
+func (e *stageAbortedError) Unwrap() error {
+	return e.err
+}
+
+// preSuspendStages returns the configured stages that run before the suspend call, in order
+func preSuspendStages(reason string, runner *githubRunner) []shutdownStage {
+	cfg := config()
+
+	var stages []shutdownStage
+	if runner != nil && cfg.GitHubRemoveRunner {
+		stages = append(stages, shutdownStage{
+			name:    stageRemoveRunner,
+			timeout: 30 * time.Second,
+			run: func(ctx context.Context) error {
+				return removeGitHubRunner(ctx, runner.ID)
+			},
+		})
+	}
+	if cfg.PreSuspendHook != "" {
+		stages = append(stages, shutdownStage{
+			name:    stagePreSuspendHook,
+			timeout: cfg.HookTimeout,
+			run:     runPreSuspendHook,
+		})
+	}
+	if cfg.SuspendWebhookURL != "" {
+		stages = append(stages, shutdownStage{
+			name:    stageNotifyPreSuspend,
+			timeout: webhookClient.Timeout,
+			run: func(ctx context.Context) error {
+				return notifySuspend(ctx, webhookPreSuspend, reason, nil)
+			},
+		})
+	}
+	if len(cfg.StopContainers) > 0 {
+		// docker stop gets the grace period, we give it a little longer to kill anything that ignores it
+		stages = append(stages, shutdownStage{
+			name:    stageStopContainers,
+			timeout: cfg.StopContainersTimeout + 10*time.Second,
+			run:     stopContainers,
+		})
+	}
+	return stages
+}
+
+// runShutdownStages runs stages in order and logs how each went, each stage gets its own timeout within ctx's deadline
+// It stops at the first failing stage listed in SHUTDOWN_ABORT_ON and returns a *stageAbortedError
+func runShutdownStages(ctx context.Context, stages []shutdownStage) error {
+	abortOn := config().ShutdownAbortOn
+
+	for _, stage := range stages {
+		stageCtx, cancel := ctx, context.CancelFunc(func() {})
+		if stage.timeout > 0 {
+			stageCtx, cancel = context.WithTimeout(ctx, stage.timeout)
+		}
+		start := time.Now()
+		err := stage.run(stageCtx)
+		cancel()
+
+		attrs := []any{
+			"stage", stage.name,
+			"duration_ms", time.Since(start).Milliseconds(),
+			"timeout_seconds", int(stage.timeout.Seconds()),
+		}
+		switch {
+		case err == nil:
+			slog.Info("Shutdown stage finished", attrs...)
+		case slices.Contains(abortOn, stage.name):
+			slog.Error("Shutdown stage failed, aborting the suspend", append(attrs, "error", err)...)
+			return &stageAbortedError{stage: stage.name, err: err}
+		default:
+			slog.Error("Shutdown stage failed, continuing", append(attrs, "error", err)...)
+		}
+	}
+	return nil
+}
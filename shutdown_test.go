@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPreSuspendStageOrder(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) {
+		cfg.GitHubRemoveRunner = true
+		cfg.PreSuspendHook = "true"
+		cfg.SuspendWebhookURL = "http://127.0.0.1:0/hook"
+		cfg.StopContainers = []string{"github-actions-runner"}
+	})
+
+	var names []string
+	for _, stage := range preSuspendStages("inactivity timeout", &githubRunner{ID: 1}) {
+		names = append(names, stage.name)
+	}
+	if !slices.Equal(names, abortableStages) {
+		t.Fatalf("Expected stages %v, got %v", abortableStages, names)
+	}
+
+	updateConfig(func(cfg *Config) {
+		cfg.GitHubRemoveRunner = false
+		cfg.StopContainers = nil
+	})
+	if got := len(preSuspendStages("inactivity timeout", nil)); got != 2 {
+		t.Fatalf("Expected only the configured stages, got %d", got)
+	}
+}
+
+// shutdownRecorder collects the order shutdown work happens in
+type shutdownRecorder struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (r *shutdownRecorder) add(event string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, event)
+}
+
+func (r *shutdownRecorder) get() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.events)
+}
+
+// useRecordedShutdown points the hook, webhook and suspend at rec, with the hook running command first
+func useRecordedShutdown(t *testing.T, rec *shutdownRecorder, command string) {
+	t.Helper()
+
+	hookLog := filepath.Join(t.TempDir(), "hook.log")
+	updateConfig(func(cfg *Config) {
+		cfg.PreSuspendHook = "echo hook > " + hookLog + "; " + command
+		cfg.HookTimeout = 5 * time.Second
+	})
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhookPayload
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		// The hook has finished by the time the webhook is sent, so its output is already there
+		if _, err := os.Stat(hookLog); err == nil {
+			rec.add("hook")
+			_ = os.Remove(hookLog)
+		}
+		rec.add(payload.Event)
+	}))
+	t.Cleanup(server.Close)
+	updateConfig(func(cfg *Config) { cfg.SuspendWebhookURL = server.URL })
+
+	suspendFunc = func(context.Context) error {
+		if _, err := os.Stat(hookLog); err == nil {
+			rec.add("hook")
+			_ = os.Remove(hookLog)
+		}
+		rec.add("suspend")
+		return nil
+	}
+}
+
+func TestShutdownPipelineOrder(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	rec := &shutdownRecorder{}
+	useRecordedShutdown(t, rec, "exit 1")
+
+	// Failures continue by default
+	if err := suspendAndShutdown("inactivity timeout", nil); err != nil {
+		t.Fatalf("Expected the suspend to go ahead, got %v", err)
+	}
+
+	want := []string{"hook", webhookPreSuspend, "suspend", webhookPostSuspend}
+	if got := rec.get(); !slices.Equal(got, want) {
+		t.Fatalf("Expected %v, got %v", want, got)
+	}
+	select {
+	case <-serverShutdown:
+	default:
+		t.Fatal("Expected the servers to drain after the suspend")
+	}
+}
+
+func TestHookFailureAbortsSuspend(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	rec := &shutdownRecorder{}
+	useRecordedShutdown(t, rec, "exit 1")
+	updateConfig(func(cfg *Config) { cfg.ShutdownAbortOn = []string{stagePreSuspendHook} })
+
+	err := suspendAndShutdown("inactivity timeout", nil)
+	var aborted *stageAbortedError
+	if !errors.As(err, &aborted) || aborted.stage != stagePreSuspendHook {
+		t.Fatalf("Expected the hook to abort the suspend, got %v", err)
+	}
+	if got := rec.get(); len(got) != 0 {
+		t.Fatalf("Expected nothing after the hook to run, got %v", got)
+	}
+	select {
+	case <-serverShutdown:
+		t.Fatal("Server should keep running after an aborted suspend")
+	default:
+	}
+	stopShutdownTimer()
+
+	tracker.mu.RLock()
+	decisions := slices.Clone(tracker.decisions)
+	tracker.mu.RUnlock()
+	if len(decisions) == 0 || decisions[len(decisions)-1].Action != "abort" || !strings.Contains(decisions[len(decisions)-1].Reason, stagePreSuspendHook) {
+		t.Fatalf("Expected an abort decision, got %+v", decisions)
+	}
+}
+
+func TestShutdownTimeoutBoundsSuspend(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	rec := &shutdownRecorder{}
+	useRecordedShutdown(t, rec, "sleep 5")
+	updateConfig(func(cfg *Config) {
+		cfg.ShutdownTimeout = 300 * time.Millisecond
+	})
+
+	var suspendCtxErr error
+	suspendFunc = func(ctx context.Context) error {
+		suspendCtxErr = ctx.Err()
+		return nil
+	}
+
+	start := time.Now()
+	_ = suspendAndShutdown("inactivity timeout", nil)
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("Expected SHUTDOWN_TIMEOUT to cut the hook short, took %v", elapsed)
+	}
+	if !errors.Is(suspendCtxErr, context.DeadlineExceeded) {
+		t.Fatalf("Expected the suspend to share the expired shutdown deadline, got %v", suspendCtxErr)
+	}
+
+	ctx, cancel := shutdownContext()
+	defer cancel()
+	if ctx.Err() == nil {
+		t.Fatal("Expected nothing left of SHUTDOWN_TIMEOUT for draining the servers")
+	}
+}
@@ -0,0 +1,386 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ActivitySource reports the last time it observed activity on the machine
+// initiateShutdown consults every configured source and combines their answers according to SOURCE_POLICY
+type ActivitySource interface {
+	Name() string
+	LastActivity(ctx context.Context) (time.Time, error)
+}
+
+// sourceFunc adapts a plain function into an ActivitySource
+type sourceFunc struct {
+	name string
+	fn   func(ctx context.Context) (time.Time, error)
+}
+
+func (s sourceFunc) Name() string {
+	return s.name
+}
+
+func (s sourceFunc) LastActivity(ctx context.Context) (time.Time, error) {
+	return s.fn(ctx)
+}
+
+// sourceStatus is what GET /sources reports for each activity source
+type sourceStatus struct {
+	Name         string     `json:"name"`
+	Active       bool       `json:"active"`
+	LastActivity *time.Time `json:"last_activity"`
+	Error        string     `json:"error,omitempty"`
+	// DisabledUntil is set while the source is left out of the suspend decision, whatever it reports
+	DisabledUntil *time.Time `json:"disabled_until,omitempty"`
+}
+
+// checkSource queries source and returns the most recent activity it has ever reported
+// Sources like the job queue only report activity while it is happening, remembering it means
+// the queue has to stay empty for the whole timeout before we suspend
+func checkSource(ctx context.Context, source ActivitySource) (time.Time, error) {
+	lastActivity, err := source.LastActivity(ctx)
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if tracker.sourceLastSeen == nil {
+		tracker.sourceLastSeen = make(map[string]time.Time)
+	}
+	if err == nil && lastActivity.After(tracker.sourceLastSeen[source.Name()]) {
+		tracker.sourceLastSeen[source.Name()] = lastActivity
+	}
+
+	return tracker.sourceLastSeen[source.Name()], err
+}
+
+// How SOURCE_POLICY combines the activity sources
+const (
+	// sourcePolicyAll suspends only once every source is idle, any one of them keeps the machine online
+	sourcePolicyAll = "all"
+	// sourcePolicyAnyIdle suspends as soon as one source is idle, the machine only stays online while all of them are active
+	sourcePolicyAnyIdle = "any-idle"
+)
+
+// activeSource returns the source keeping the machine online under SOURCE_POLICY and its last activity, if there is one
+// Sources that can't be checked have no say, and open /wait requests keep the machine online under either policy
+func activeSource(ctx context.Context, now time.Time) (string, time.Time, bool) {
+	policy := config().SourcePolicy
+	timeout := inactivityTimeout()
+
+	var (
+		active     string
+		activeSeen time.Time
+		idle       string
+	)
+	for _, source := range activitySources {
+		if !sourceDisabledUntil(source.Name()).IsZero() {
+			slog.Debug("Activity source disabled, leaving it out", "source", source.Name())
+			continue
+		}
+		lastActivity, err := checkSource(ctx, source)
+		if err != nil {
+			slog.Debug("Could not check activity source", "source", source.Name(), "error", err)
+			continue
+		}
+
+		if now.Sub(lastActivity) >= timeout {
+			if source.Name() != "wait" && idle == "" {
+				idle = source.Name()
+			}
+			continue
+		}
+		if policy != sourcePolicyAnyIdle || source.Name() == "wait" {
+			return source.Name(), lastActivity, true
+		}
+		if active == "" {
+			active, activeSeen = source.Name(), lastActivity
+		}
+	}
+
+	if active == "" {
+		return "", time.Time{}, false
+	}
+	if idle != "" {
+		slog.Info("Activity source is idle, ignoring the active ones",
+			"idle_source", idle,
+			"active_source", active,
+			"source_policy", policy)
+		return "", time.Time{}, false
+	}
+	return active, activeSeen, true
+}
+
+// recordActivity moves the shared last activity forward to at, so activity a source found shows up in /status like a ping would
+func recordActivity(at time.Time) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if at.After(tracker.lastActivity) {
+		tracker.lastActivity = at
+	}
+}
+
+// currentSources checks every activity source, including pings
+func currentSources(ctx context.Context) []sourceStatus {
+	now := time.Now()
+	timeout := inactivityTimeout()
+
+	tracker.mu.RLock()
+	lastPing := tracker.lastPing
+	tracker.mu.RUnlock()
+
+	statuses := []sourceStatus{{
+		Name:         "ping",
+		Active:       now.Sub(lastPing) < timeout,
+		LastActivity: &lastPing,
+	}}
+
+	for _, source := range activitySources {
+		status := sourceStatus{Name: source.Name()}
+
+		lastActivity, err := checkSource(ctx, source)
+		if err != nil {
+			status.Error = err.Error()
+		}
+		if !lastActivity.IsZero() {
+			status.LastActivity = &lastActivity
+			status.Active = now.Sub(lastActivity) < timeout
+		}
+		if until := sourceDisabledUntil(source.Name()); !until.IsZero() {
+			status.DisabledUntil = &until
+		}
+
+		statuses = append(statuses, status)
+	}
+
+	return statuses
+}
+
+func sourcesHandler(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), 5*time.Second)
+	defer cancel()
+
+	writeJSON(w, http.StatusOK, currentSources(ctx))
+}
+
+// buildActivitySources returns the activity sources enabled by the config
+func buildActivitySources() []ActivitySource {
+	cfg := config()
+
+	// Open /wait requests always count
+	sources := []ActivitySource{sourceFunc{name: "wait", fn: waitActivity}}
+
+	// Hosts without docker can't have a runner container, don't bother checking its logs unless the runner writes a status file
+	if _, err := exec.LookPath("docker"); err != nil && cfg.GitHubRunnerStatusFile == "" {
+		slog.Info("docker not found, GitHub Actions log check disabled")
+	} else {
+		sources = append(sources, sourceFunc{name: githubActionsSource, fn: githubActionsActivity})
+	}
+
+	if cfg.QueueDepthCommand != "" || cfg.QueueDepthURL != "" {
+		sources = append(sources, sourceFunc{name: "queue", fn: queueActivity})
+	}
+
+	if cfg.JobLockFile != "" {
+		sources = append(sources, sourceFunc{name: "job_lock", fn: jobLockActivity})
+	}
+
+	if cfg.DeployLock != "" {
+		sources = append(sources, sourceFunc{name: "deploy_lock", fn: deployLockActivity})
+	}
+
+	if cfg.CalendarICSURL != "" {
+		sources = append(sources, sourceFunc{name: "calendar", fn: calendarActivity})
+	}
+
+	if cfg.WatchGPU {
+		if _, err := exec.LookPath("nvidia-smi"); err != nil {
+			slog.Warn("WATCH_GPU is enabled but nvidia-smi was not found, ignoring GPU activity", "error", err)
+		} else {
+			sources = append(sources, sourceFunc{name: "gpu", fn: gpuActivity})
+		}
+	}
+
+	if cfg.WatchNetThroughput {
+		sources = append(sources, sourceFunc{name: "net_throughput", fn: netThroughputActivity})
+	}
+
+	if cfg.WatchWorkdir {
+		if cfg.Workdir == "" {
+			slog.Warn("WATCH_WORKDIR is enabled but WORKDIR is not set, ignoring open files")
+		} else {
+			sources = append(sources, sourceFunc{name: "workdir", fn: workdirActivity})
+		}
+	}
+
+	if cfg.WatchGCPCPU {
+		sources = append(sources, sourceFunc{name: "gcp_cpu", fn: gcpCPUActivity})
+	}
+
+	if cfg.WatchSSHSessions {
+		if _, err := exec.LookPath("who"); err != nil {
+			slog.Warn("WATCH_SSH_SESSIONS is enabled but who was not found, ignoring login sessions", "error", err)
+		} else {
+			sources = append(sources, sourceFunc{name: "ssh_sessions", fn: sessionActivity})
+		}
+	}
+
+	return sources
+}
+
+// jobLockActivity treats JOB_LOCK_FILE being flocked by another process as activity happening right now
+func jobLockActivity(context.Context) (time.Time, error) {
+	f, err := os.Open(config().JobLockFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		// Job wrappers usually create the file on first use
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("failed to open job lock file: %w", err)
+	}
+	defer f.Close()
+
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if errors.Is(err, syscall.EWOULDBLOCK) {
+		return time.Now(), nil
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("failed to check job lock: %w", err)
+	}
+
+	// Let go straight away so we never hold up a job that is about to start
+	_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	return time.Time{}, nil
+}
+
+// queueActivity treats a non-empty job queue as activity happening right now
+func queueActivity(ctx context.Context) (time.Time, error) {
+	depth, err := getQueueDepth(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if depth > 0 {
+		return time.Now(), nil
+	}
+	return time.Time{}, nil
+}
+
+// getQueueDepth reads the number of pending jobs from QUEUE_DEPTH_COMMAND or QUEUE_DEPTH_URL
+// Either is expected to output a single integer
+func getQueueDepth(ctx context.Context) (int, error) {
+	cfg := config()
+
+	var output []byte
+	if cfg.QueueDepthCommand != "" {
+		var err error
+		output, err = exec.CommandContext(ctx, "sh", "-c", cfg.QueueDepthCommand).Output()
+		if err != nil {
+			return 0, fmt.Errorf("queue depth command failed: %v", err)
+		}
+	} else {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, cfg.QueueDepthURL, nil)
+		if err != nil {
+			return 0, err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return 0, fmt.Errorf("queue depth request failed: %v", err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return 0, fmt.Errorf("queue depth request failed: %s", resp.Status)
+		}
+		output, err = io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if err != nil {
+			return 0, fmt.Errorf("failed to read queue depth: %v", err)
+		}
+	}
+
+	depth, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil {
+		return 0, fmt.Errorf("could not parse queue depth %q", strings.TrimSpace(string(output)))
+	}
+	return depth, nil
+}
+
+// gpuActivity treats any GPU with non-zero utilization as activity happening right now
+func gpuActivity(ctx context.Context) (time.Time, error) {
+	output, err := exec.CommandContext(ctx, "nvidia-smi", "--query-gpu=utilization.gpu", "--format=csv,noheader,nounits").Output()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("nvidia-smi failed: %v", err)
+	}
+
+	utilization, err := parseGPUUtilization(string(output))
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	slog.Debug("GPU utilization", "percent", utilization)
+	if utilization > 0 {
+		return time.Now(), nil
+	}
+	return time.Time{}, nil
+}
+
+// parseGPUUtilization returns the highest utilization percentage reported by nvidia-smi, one GPU per line
+func parseGPUUtilization(output string) (int, error) {
+	highest := 0
+	found := false
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+
+		utilization, err := strconv.Atoi(line)
+		if err != nil {
+			return 0, fmt.Errorf("could not parse GPU utilization %q", line)
+		}
+		highest = max(highest, utilization)
+		found = true
+	}
+
+	if !found {
+		return 0, fmt.Errorf("nvidia-smi reported no GPUs")
+	}
+	return highest, nil
+}
+
+// sessionActivity treats anyone being logged in as activity happening right now
+// who reads utmp, so in a container /run/utmp has to be mounted from the host
+func sessionActivity(ctx context.Context) (time.Time, error) {
+	output, err := exec.CommandContext(ctx, "who").Output()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("who failed: %v", err)
+	}
+
+	users := parseWho(string(output))
+	slog.Debug("Login sessions", "users", users)
+	if len(users) > 0 {
+		return time.Now(), nil
+	}
+	return time.Time{}, nil
+}
+
+// parseWho returns the user of each session listed by who, one session per line
+func parseWho(output string) []string {
+	var users []string
+	for _, line := range strings.Split(output, "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			users = append(users, fields[0])
+		}
+	}
+	return users
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestQueueDepthCommand(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) { cfg.QueueDepthCommand = "echo 3" })
+	depth, err := getQueueDepth(t.Context())
+	if err != nil {
+		t.Fatalf("getQueueDepth: %v", err)
+	}
+	if depth != 3 {
+		t.Fatalf("Expected depth 3, got %d", depth)
+	}
+
+	updateConfig(func(cfg *Config) { cfg.QueueDepthCommand = "echo not-a-number" })
+	if _, err := getQueueDepth(t.Context()); err == nil {
+		t.Fatal("Expected an error for unparseable output")
+	}
+}
+
+func TestQueueDepthURL(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "0")
+	}))
+	defer server.Close()
+
+	updateConfig(func(cfg *Config) { cfg.QueueDepthURL = server.URL })
+	last, err := queueActivity(t.Context())
+	if err != nil {
+		t.Fatalf("queueActivity: %v", err)
+	}
+	if !last.IsZero() {
+		t.Fatal("An empty queue should not count as activity")
+	}
+}
+
+func TestNonEmptyQueueKeepsMachineOnline(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) { cfg.QueueDepthCommand = "echo 1" })
+	activitySources = buildActivitySources()
+
+	initiateShutdown()
+
+	if mockGCP.WasSuspendCalled() {
+		t.Fatal("Suspension should not be called while the queue has work")
+	}
+}
+
+func TestSourceActivityUpdatesLastActivity(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	sourceTime := time.Now().Add(-10 * time.Second)
+	tracker.mu.Lock()
+	tracker.lastPing = time.Now().Add(-time.Hour)
+	tracker.lastActivity = tracker.lastPing
+	tracker.mu.Unlock()
+
+	activitySources = []ActivitySource{
+		sourceFunc{name: "github_actions", fn: func(context.Context) (time.Time, error) {
+			return sourceTime, nil
+		}},
+	}
+
+	initiateShutdown()
+
+	status := currentStatus()
+	if !status.LastActivity.Equal(sourceTime) {
+		t.Fatalf("Expected last activity %v from the source, got %v", sourceTime, status.LastActivity)
+	}
+	if status.LastPing.After(sourceTime) {
+		t.Fatal("Source activity should not count as a ping")
+	}
+}
+
+func TestStaleActivitySourceAllowsSuspension(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	activitySources = []ActivitySource{
+		sourceFunc{name: "stale", fn: func(context.Context) (time.Time, error) {
+			return time.Now().Add(-2 * config().InactivityTimeout), nil
+		}},
+	}
+
+	initiateShutdown()
+
+	if !mockGCP.WasSuspendCalled() {
+		t.Fatal("Suspension should be called when every source is idle")
+	}
+}
+
+func TestSourcePolicy(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	activitySources = []ActivitySource{
+		sourceFunc{name: "queue", fn: func(context.Context) (time.Time, error) {
+			return time.Now(), nil
+		}},
+		sourceFunc{name: "gpu", fn: func(context.Context) (time.Time, error) {
+			return time.Time{}, nil
+		}},
+	}
+
+	if name, _, ok := activeSource(t.Context(), time.Now()); !ok || name != "queue" {
+		t.Fatalf("Expected the queue to keep the machine online with SOURCE_POLICY=all, got %q, %v", name, ok)
+	}
+
+	updateConfig(func(cfg *Config) { cfg.SourcePolicy = sourcePolicyAnyIdle })
+	if name, _, ok := activeSource(t.Context(), time.Now()); ok {
+		t.Fatalf("Expected the idle GPU to allow a suspend with SOURCE_POLICY=any-idle, got %q", name)
+	}
+
+	// Open /wait requests always count
+	activitySources = append(activitySources, sourceFunc{name: "wait", fn: func(context.Context) (time.Time, error) {
+		return time.Now(), nil
+	}})
+	if name, _, ok := activeSource(t.Context(), time.Now()); !ok || name != "wait" {
+		t.Fatalf("Expected an open /wait request to keep the machine online, got %q, %v", name, ok)
+	}
+
+	initiateShutdown()
+	if mockGCP.WasSuspendCalled() {
+		t.Fatal("Suspension should not be called while a /wait request is open")
+	}
+}
+
+func TestParseGPUUtilization(t *testing.T) {
+	tests := []struct {
+		output  string
+		want    int
+		wantErr bool
+	}{
+		{"0\n", 0, false},
+		{"0\n87\n3\n", 87, false},
+		{" 12 \n", 12, false},
+		{"", 0, true},
+		{"[N/A]\n", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseGPUUtilization(tt.output)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("parseGPUUtilization(%q): unexpected error %v", tt.output, err)
+		}
+		if got != tt.want {
+			t.Fatalf("parseGPUUtilization(%q): expected %d, got %d", tt.output, tt.want, got)
+		}
+	}
+}
+
+func TestSourcesEndpoint(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	queueDepth := 2
+	activitySources = []ActivitySource{
+		sourceFunc{name: "queue", fn: func(context.Context) (time.Time, error) {
+			if queueDepth > 0 {
+				return time.Now(), nil
+			}
+			return time.Time{}, nil
+		}},
+		sourceFunc{name: "broken", fn: func(context.Context) (time.Time, error) {
+			return time.Time{}, fmt.Errorf("docker not running")
+		}},
+	}
+
+	w := httptest.NewRecorder()
+	sourcesHandler(w, httptest.NewRequest("GET", "/sources", nil))
+
+	var statuses []sourceStatus
+	if err := json.NewDecoder(w.Body).Decode(&statuses); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(statuses) != 3 {
+		t.Fatalf("Expected ping plus two sources, got %+v", statuses)
+	}
+	if statuses[0].Name != "ping" || !statuses[0].Active {
+		t.Fatalf("Expected an active ping source, got %+v", statuses[0])
+	}
+	if !statuses[1].Active || statuses[1].LastActivity == nil {
+		t.Fatalf("Expected an active queue source, got %+v", statuses[1])
+	}
+	if statuses[2].Active || statuses[2].Error == "" {
+		t.Fatalf("Expected an inactive broken source with an error, got %+v", statuses[2])
+	}
+
+	// The queue emptied but its last activity is remembered
+	queueDepth = 0
+	statuses = currentSources(t.Context())
+	if !statuses[1].Active || statuses[1].LastActivity == nil {
+		t.Fatalf("Expected the queue to still count as recently active, got %+v", statuses[1])
+	}
+}
+
+func TestGitHubActionsSourceSkippedWithoutDocker(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	t.Setenv("PATH", t.TempDir())
+
+	for _, source := range buildActivitySources() {
+		if source.Name() == "github_actions" {
+			t.Fatal("GitHub Actions source should be disabled when docker is not installed")
+		}
+	}
+}
+
+func TestGitHubActionsFutureTimestamp(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	now := time.Date(2024, 5, 1, 0, 0, 10, 0, time.UTC)
+
+	last, err := parseGitHubActionsTimestamp("23:59:50: Job completed", now)
+	if err != nil {
+		t.Fatalf("parseGitHubActionsTimestamp: %v", err)
+	}
+	if !last.Equal(now) {
+		t.Fatalf("Expected a future timestamp to be clamped to now, got %v", last)
+	}
+
+	last, err = parseGitHubActionsTimestamp("00:00:05: Listening for Jobs", now)
+	if err != nil || !last.Equal(now.Add(-5*time.Second)) {
+		t.Fatalf("Expected a past timestamp to be kept, got %v, %v", last, err)
+	}
+
+	updateConfig(func(cfg *Config) { cfg.FutureTimestamps = futureTimestampsIgnore })
+	last, err = parseGitHubActionsTimestamp("23:59:50: Job completed", now)
+	if err != nil || !last.IsZero() {
+		t.Fatalf("Expected a future timestamp to be ignored, got %v, %v", last, err)
+	}
+}
+
+func TestJobLockActivity(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) { cfg.JobLockFile = filepath.Join(t.TempDir(), "job.lock") })
+
+	// No file yet means no job has run
+	last, err := jobLockActivity(t.Context())
+	if err != nil || !last.IsZero() {
+		t.Fatalf("Expected no activity without a lock file, got %v, %v", last, err)
+	}
+
+	job, err := os.Create(config().JobLockFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer job.Close()
+
+	last, err = jobLockActivity(t.Context())
+	if err != nil || !last.IsZero() {
+		t.Fatalf("Expected no activity while the lock is free, got %v, %v", last, err)
+	}
+
+	if err := syscall.Flock(int(job.Fd()), syscall.LOCK_EX); err != nil {
+		t.Fatal(err)
+	}
+	last, err = jobLockActivity(t.Context())
+	if err != nil || last.IsZero() {
+		t.Fatalf("Expected activity while a job holds the lock, got %v, %v", last, err)
+	}
+
+	if err := syscall.Flock(int(job.Fd()), syscall.LOCK_UN); err != nil {
+		t.Fatal(err)
+	}
+	last, err = jobLockActivity(t.Context())
+	if err != nil || !last.IsZero() {
+		t.Fatalf("Expected no activity once the job released the lock, got %v, %v", last, err)
+	}
+}
+
+func TestParseWho(t *testing.T) {
+	output := `alice    pts/0        2024-05-01 09:12 (203.0.113.7)
+bob      pts/1        2024-05-01 10:40 (198.51.100.2)
+
+`
+	users := parseWho(output)
+	if len(users) != 2 || users[0] != "alice" || users[1] != "bob" {
+		t.Fatalf("Unexpected users %q", users)
+	}
+
+	if users := parseWho("\n"); len(users) != 0 {
+		t.Fatalf("Expected no sessions, got %q", users)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxDecisions bounds the in-memory decision log
+const maxDecisions = 50
+
+// decision records the outcome of a single shutdown evaluation
+type decision struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Reason string    `json:"reason"`
+	// LatencySeconds is how long after the timer fired a suspend was issued, only on suspend_issued
+	LatencySeconds *float64 `json:"latency_seconds,omitempty"`
+}
+
+// stateSnapshot is what gets written to STATE_FILE right before we suspend
+type stateSnapshot struct {
+	SavedAt       time.Time  `json:"saved_at"`
+	StartedAt     time.Time  `json:"started_at"`
+	UptimeSeconds int64      `json:"uptime_seconds"`
+	RequestCount  int64      `json:"request_count"`
+	LastPing      time.Time  `json:"last_ping"`
+	Decisions     []decision `json:"decisions"`
+}
+
+func recordDecision(action, reason string) {
+	recordDecisionLatency(action, reason, nil)
+}
+
+func recordDecisionLatency(action, reason string, latencySeconds *float64) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	tracker.decisions = append(tracker.decisions, decision{
+		Time:           time.Now(),
+		Action:         action,
+		Reason:         reason,
+		LatencySeconds: latencySeconds,
+	})
+	if len(tracker.decisions) > maxDecisions {
+		tracker.decisions = tracker.decisions[len(tracker.decisions)-maxDecisions:]
+	}
+}
+
+var (
+	suspendsSucceeded = &counter{
+		name: "lightsout_suspends_total",
+		help: "Suspends of this instance that succeeded.",
+	}
+	suspendFailures = &counter{
+		name: "lightsout_suspend_failures_total",
+		help: "Suspends of this instance that failed.",
+	}
+)
+
+// suspendError is a failed suspend, kept for alerting via /status and /metrics
+type suspendError struct {
+	Time    time.Time `json:"time"`
+	Message string    `json:"message"`
+}
+
+// recordSuspendResult remembers a failed suspend, or clears the last failure once a suspend succeeds
+func recordSuspendResult(err error) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	if err == nil {
+		suspendsSucceeded.inc()
+		tracker.lastSuspendError = nil
+		return
+	}
+	suspendFailures.inc()
+	tracker.lastSuspendError = &suspendError{
+		Time:    time.Now(),
+		Message: err.Error(),
+	}
+}
+
+func lastSuspendErrorTimestamp() float64 {
+	tracker.mu.RLock()
+	defer tracker.mu.RUnlock()
+
+	if tracker.lastSuspendError == nil {
+		return 0
+	}
+	return float64(tracker.lastSuspendError.Time.Unix())
+}
+
+// consumeWarmupGrace reports whether the warmup grace is still pending, using it up
+func consumeWarmupGrace() bool {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	pending := tracker.warmupGrace
+	tracker.warmupGrace = false
+	return pending
+}
+
+// resumeClockJump is how far the wall clock has to run ahead of the monotonic clock between two checks to count
+// as a resume, the monotonic clock stands still while the instance is suspended
+const resumeClockJump = 30 * time.Second
+
+// watchResume notices the instance resuming under a running lightsout, from the wall clock jumping ahead
+func watchResume(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			checkResumed(now.Sub(last), now.Round(0).Sub(last.Round(0)), now)
+			last = now
+		}
+	}
+}
+
+// checkResumed records a resume at now when wall time ran more than resumeClockJump ahead of monotonic time
+func checkResumed(monotonic, wall time.Duration, now time.Time) bool {
+	if wall-monotonic < resumeClockJump {
+		return false
+	}
+
+	tracker.mu.Lock()
+	tracker.resumedAt = now
+	tracker.mu.Unlock()
+
+	slog.Info("Instance resumed", "suspended_seconds", int((wall - monotonic).Seconds()))
+	return true
+}
+
+// recordSuspendAttempt remembers a suspend for MAX_SUSPENDS_PER_HOUR, forgetting those older than an hour
+func recordSuspendAttempt(now time.Time) {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	tracker.recentSuspends = append(suspendsSince(now.Add(-time.Hour)), now)
+}
+
+// suspendThrottled reports whether MAX_SUSPENDS_PER_HOUR suspends have already been attempted in the last hour
+func suspendThrottled(now time.Time) bool {
+	limit := config().MaxSuspendsPerHour
+	if limit <= 0 {
+		return false
+	}
+
+	tracker.mu.RLock()
+	defer tracker.mu.RUnlock()
+
+	return len(suspendsSince(now.Add(-time.Hour))) >= limit
+}
+
+// suspendsSince returns the recent suspends after since, the caller must hold tracker.mu
+func suspendsSince(since time.Time) []time.Time {
+	for i, t := range tracker.recentSuspends {
+		if t.After(since) {
+			return tracker.recentSuspends[i:]
+		}
+	}
+	return nil
+}
+
+func snapshotState() stateSnapshot {
+	tracker.mu.RLock()
+	defer tracker.mu.RUnlock()
+
+	now := time.Now()
+	return stateSnapshot{
+		SavedAt:       now,
+		StartedAt:     tracker.startedAt,
+		UptimeSeconds: int64(now.Sub(tracker.startedAt).Seconds()),
+		RequestCount:  tracker.requestCount,
+		LastPing:      tracker.lastPing,
+		Decisions:     append([]decision(nil), tracker.decisions...),
+	}
+}
+
+// saveState writes a final snapshot of the tracker to STATE_FILE
+// The file is written to a temp file and renamed so a suspend mid-write can't leave a truncated snapshot
+func saveState() error {
+	cfg := config()
+
+	if cfg.StateFile == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(snapshotState(), "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal state: %v", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(cfg.StateFile), ".lightsout-state-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp state file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write state: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync state: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close state file: %v", err)
+	}
+
+	if err := os.Rename(tmp.Name(), cfg.StateFile); err != nil {
+		return fmt.Errorf("failed to save state: %v", err)
+	}
+
+	slog.Debug("State snapshot saved", "path", cfg.StateFile)
+	return nil
+}
+
+// loadState restores counters from a previous snapshot so they survive restarts, then removes it
+func loadState() error {
+	cfg := config()
+
+	if cfg.StateFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(cfg.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read state: %v", err)
+	}
+
+	var snapshot stateSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("failed to parse state: %v", err)
+	}
+
+	// A snapshot is restored once, another restart would otherwise count the same requests and decisions again
+	if err := os.Remove(cfg.StateFile); err != nil {
+		return fmt.Errorf("failed to remove restored state: %v", err)
+	}
+
+	// The snapshot is only written right before a suspend. A machine booted since then has come back from it,
+	// while one booted earlier only had lightsout restart, which doesn't need the grace
+	resumed := true
+	if booted, err := bootTime(); err != nil {
+		slog.Debug("Could not read the boot time, treating the snapshot as a resume", "error", err)
+	} else {
+		resumed = booted.After(snapshot.SavedAt)
+	}
+
+	tracker.mu.Lock()
+	tracker.warmupGrace = cfg.WarmupGrace && resumed
+	tracker.requestCount += snapshot.RequestCount
+	tracker.decisions = append(snapshot.Decisions, tracker.decisions...)
+	if len(tracker.decisions) > maxDecisions {
+		tracker.decisions = tracker.decisions[len(tracker.decisions)-maxDecisions:]
+	}
+	tracker.mu.Unlock()
+
+	slog.Info("Restored state snapshot",
+		"path", cfg.StateFile,
+		"saved_at", snapshot.SavedAt,
+		"request_count", snapshot.RequestCount)
+	return nil
+}
+
+// bootTime reads when the system booted from the btime line of /proc/stat
+func bootTime() (time.Time, error) {
+	data, err := os.ReadFile(filepath.Join(procPath, "stat"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	for line := range strings.SplitSeq(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 || fields[0] != "btime" {
+			continue
+		}
+		seconds, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unexpected btime: %q", line)
+		}
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("no btime in %s", filepath.Join(procPath, "stat"))
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestStateSnapshotRoundTrip(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) { cfg.StateFile = filepath.Join(t.TempDir(), "state.json") })
+
+	tracker.requestCount = 7
+	recordDecision("suspend", "inactivity timeout")
+
+	if err := saveState(); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+
+	// Simulate a restart with a fresh tracker
+	tracker = &ActivityTracker{}
+	if err := loadState(); err != nil {
+		t.Fatalf("loadState: %v", err)
+	}
+
+	if tracker.requestCount != 7 {
+		t.Fatalf("Expected request count 7, got %d", tracker.requestCount)
+	}
+	if len(tracker.decisions) != 1 || tracker.decisions[0].Action != "suspend" {
+		t.Fatalf("Expected restored suspend decision, got %+v", tracker.decisions)
+	}
+}
+
+func TestLoadStateOnce(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) { cfg.StateFile = filepath.Join(t.TempDir(), "state.json") })
+
+	tracker.requestCount = 7
+	recordDecision("suspend", "inactivity timeout")
+	if err := saveState(); err != nil {
+		t.Fatalf("saveState: %v", err)
+	}
+
+	// Two restarts in a row, only the first finds the snapshot
+	tracker = &ActivityTracker{}
+	for range 2 {
+		if err := loadState(); err != nil {
+			t.Fatalf("loadState: %v", err)
+		}
+	}
+
+	if tracker.requestCount != 7 {
+		t.Fatalf("Expected request count 7 after loading twice, got %d", tracker.requestCount)
+	}
+	if len(tracker.decisions) != 1 {
+		t.Fatalf("Expected one restored decision after loading twice, got %+v", tracker.decisions)
+	}
+	if _, err := os.Stat(config().StateFile); !os.IsNotExist(err) {
+		t.Fatalf("Expected the snapshot to be removed once restored, got %v", err)
+	}
+}
+
+func TestDecisionLogIsBounded(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	for i := 0; i < maxDecisions*2; i++ {
+		recordDecision("stay_online", "test")
+	}
+
+	if len(tracker.decisions) != maxDecisions {
+		t.Fatalf("Expected %d decisions, got %d", maxDecisions, len(tracker.decisions))
+	}
+}
+
+func TestLoadStateMissingFile(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	updateConfig(func(cfg *Config) { cfg.StateFile = filepath.Join(t.TempDir(), "missing.json") })
+	if err := loadState(); err != nil {
+		t.Fatalf("Missing state file should not be an error: %v", err)
+	}
+}
+
+func TestWarmupGraceAfterResume(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		updateConfig(func(cfg *Config) {
+			cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+			cfg.WarmupGrace = true
+		})
+		if err := saveState(); err != nil {
+			t.Fatalf("saveState: %v", err)
+		}
+		time.Sleep(time.Minute)
+		useFakeBootTime(t, time.Now())
+		if err := loadState(); err != nil {
+			t.Fatalf("loadState: %v", err)
+		}
+
+		resetShutdownTimer()
+		time.Sleep(config().InactivityTimeout + time.Second)
+		if mockGCP.WasSuspendCalled() {
+			t.Fatal("The first timeout after a resume should be skipped")
+		}
+
+		time.Sleep(config().InactivityTimeout)
+		if !mockGCP.WasSuspendCalled() {
+			t.Fatal("Only the first timeout after a resume should be skipped")
+		}
+	})
+}
+
+func TestNoWarmupGraceAfterRestart(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		// The machine booted before the snapshot was saved, only lightsout restarted
+		useFakeBootTime(t, time.Now())
+		time.Sleep(time.Minute)
+		updateConfig(func(cfg *Config) {
+			cfg.StateFile = filepath.Join(t.TempDir(), "state.json")
+			cfg.WarmupGrace = true
+		})
+		if err := saveState(); err != nil {
+			t.Fatalf("saveState: %v", err)
+		}
+		if err := loadState(); err != nil {
+			t.Fatalf("loadState: %v", err)
+		}
+
+		resetShutdownTimer()
+		time.Sleep(config().InactivityTimeout + time.Second)
+		if !mockGCP.WasSuspendCalled() {
+			t.Fatal("A restart without a resume should not skip the first timeout")
+		}
+	})
+}
+
+// useFakeBootTime points procPath at a directory whose stat file says the system booted at booted
+func useFakeBootTime(t *testing.T, booted time.Time) {
+	t.Helper()
+
+	proc := t.TempDir()
+	stat := fmt.Sprintf("cpu  1 2 3 4\nbtime %d\nprocesses 42\n", booted.Unix())
+	if err := os.WriteFile(filepath.Join(proc, "stat"), []byte(stat), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	origProc := procPath
+	procPath = proc
+	t.Cleanup(func() { procPath = origProc })
+}
+
+func TestMaxSuspendsPerHour(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		updateConfig(func(cfg *Config) { cfg.MaxSuspendsPerHour = 2 })
+		throttledBefore := suspendsThrottled.value.Load()
+
+		suspendAgain := func() bool {
+			mockGCP.Reset()
+			serverShutdown = make(chan struct{})
+			initiateShutdown()
+			return mockGCP.WasSuspendCalled()
+		}
+
+		if !suspendAgain() {
+			t.Fatal("The first suspend should go through")
+		}
+		time.Sleep(10 * time.Minute)
+		if !suspendAgain() {
+			t.Fatal("The second suspend should go through")
+		}
+		time.Sleep(10 * time.Minute)
+		if suspendAgain() {
+			t.Fatal("A third suspend within the hour should be throttled")
+		}
+		if suspendsThrottled.value.Load() != throttledBefore+1 {
+			t.Fatal("Expected the throttled suspend to be counted")
+		}
+
+		// Staying online restarted the inactivity timer, stop it so only our calls suspend
+		stopShutdownTimer()
+
+		// The first suspend falls out of the window
+		time.Sleep(41 * time.Minute)
+		if !suspendAgain() {
+			t.Fatal("Suspends should be allowed again once the hour has rolled on")
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"net"
+	"time"
+)
+
+// statsdMaxPacket keeps each datagram under a typical Ethernet MTU so nothing is fragmented
+const statsdMaxPacket = 1432
+
+// statsdSink pushes the metrics /metrics exposes to a StatsD server over UDP
+type statsdSink struct {
+	conn net.Conn
+	// sent is each counter's total as of the last push, StatsD counters take increments
+	sent map[string]float64
+}
+
+func newStatsdSink(addr string) (*statsdSink, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open StatsD socket: %w", err)
+	}
+	return &statsdSink{conn: conn, sent: make(map[string]float64)}, nil
+}
+
+// runStatsD pushes the metrics to STATSD_ADDR every STATSD_INTERVAL until ctx is cancelled
+// It works with or without anything scraping /metrics, for setups that only take pushed metrics
+func runStatsD(ctx context.Context) {
+	cfg := config()
+
+	sink, err := newStatsdSink(cfg.StatsDAddr)
+	if err != nil {
+		slog.Error("StatsD disabled", "addr", cfg.StatsDAddr, "error", err)
+		return
+	}
+	defer sink.conn.Close()
+
+	slog.Info("Pushing metrics to StatsD",
+		"addr", cfg.StatsDAddr,
+		"interval_seconds", int(cfg.StatsDInterval.Seconds()))
+
+	ticker := time.NewTicker(cfg.StatsDInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			// One last push so a suspend shows up before we are gone
+			if err := sink.push(collectSamples()); err != nil {
+				slog.Warn("Failed to push metrics to StatsD", "error", err)
+			}
+			return
+		case <-ticker.C:
+			if err := sink.push(collectSamples()); err != nil {
+				slog.Warn("Failed to push metrics to StatsD", "error", err)
+			}
+		}
+	}
+}
+
+// push sends samples, counters as the increase since the last push and gauges as their current value
+func (s *statsdSink) push(samples []sample) error {
+	var packet bytes.Buffer
+	flush := func() error {
+		if packet.Len() == 0 {
+			return nil
+		}
+		_, err := s.conn.Write(bytes.TrimSuffix(packet.Bytes(), []byte("\n")))
+		packet.Reset()
+		return err
+	}
+
+	for _, sample := range samples {
+		var line string
+		if sample.counter {
+			delta := sample.value - s.sent[sample.name]
+			s.sent[sample.name] = sample.value
+			if delta <= 0 {
+				continue
+			}
+			line = sample.name + ":" + formatFloat(delta) + "|c\n"
+		} else {
+			line = sample.name + ":" + formatFloat(sample.value) + "|g\n"
+		}
+
+		if packet.Len()+len(line) > statsdMaxPacket {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		packet.WriteString(line)
+	}
+	return flush()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenStatsD returns a UDP listener standing in for a StatsD server
+func listenStatsD(t *testing.T) net.PacketConn {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readStatsD returns the lines of the next datagram
+func readStatsD(t *testing.T, conn net.PacketConn) []string {
+	t.Helper()
+
+	buf := make([]byte, 65536)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Split(string(buf[:n]), "\n")
+}
+
+func TestStatsDPush(t *testing.T) {
+	server := listenStatsD(t)
+	sink, err := newStatsdSink(server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.conn.Close()
+
+	pings := &counter{name: "test_pings_total"}
+	pings.value.Store(3)
+	timeout := &gaugeFunc{name: "test_timeout_seconds", fn: func() float64 { return 90 }}
+	samples := func() []sample { return append(pings.samples(), timeout.samples()...) }
+
+	if err := sink.push(samples()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"test_pings_total:3|c", "test_timeout_seconds:90|g"}
+	if got := readStatsD(t, server); !slices.Equal(got, want) {
+		t.Fatalf("Expected %q, got %q", want, got)
+	}
+
+	// Counters are sent as the increase since the last push, and left out when they haven't moved
+	if err := sink.push(samples()); err != nil {
+		t.Fatal(err)
+	}
+	if got := readStatsD(t, server); !slices.Equal(got, []string{"test_timeout_seconds:90|g"}) {
+		t.Fatalf("Expected only the gauge, got %q", got)
+	}
+	pings.inc()
+	if err := sink.push(samples()); err != nil {
+		t.Fatal(err)
+	}
+	if got := readStatsD(t, server); !slices.Contains(got, "test_pings_total:1|c") {
+		t.Fatalf("Expected the counter increment, got %q", got)
+	}
+}
+
+func TestStatsDPushSplitsPackets(t *testing.T) {
+	server := listenStatsD(t)
+	sink, err := newStatsdSink(server.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.conn.Close()
+
+	var samples []sample
+	for i := range 100 {
+		samples = append(samples, sample{name: "test_gauge_" + strings.Repeat("x", 20) + string(rune('a'+i%26)), value: float64(i)})
+	}
+	if err := sink.push(samples); err != nil {
+		t.Fatal(err)
+	}
+
+	received := 0
+	for received < len(samples) {
+		lines := readStatsD(t, server)
+		if size := len(strings.Join(lines, "\n")); size > statsdMaxPacket {
+			t.Fatalf("Datagram of %d bytes is over the limit", size)
+		}
+		received += len(lines)
+	}
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+type pendingSuspendStatus struct {
+	SuspendAt        time.Time `json:"suspend_at"`
+	RemainingSeconds int       `json:"remaining_seconds"`
+}
+
+type statusResponse struct {
+	StartedAt                time.Time               `json:"started_at"`
+	UptimeSeconds            int64                   `json:"uptime_seconds"`
+	RequestCount             int64                   `json:"request_count"`
+	LastPing                 time.Time               `json:"last_ping"`
+	LastActivity             time.Time               `json:"last_activity"`
+	KeepOnline               bool                    `json:"keep_online"`
+	SuspendMode              string                  `json:"suspend_mode"`
+	ReportOnly               bool                    `json:"report_only"`
+	InactivityTimeoutSeconds int                     `json:"inactivity_timeout_seconds"`
+	InstanceNotFound         bool                    `json:"instance_not_found"`
+	StopSchedule             *instanceStopSchedule   `json:"stop_schedule,omitempty"`
+	LastSuspendError         *suspendError           `json:"last_suspend_error"`
+	LastReconcile            *reconcileResult        `json:"last_reconcile,omitempty"`
+	PendingSuspend           *pendingSuspendStatus   `json:"pending_suspend"`
+	Draining                 bool                    `json:"draining"`
+	ArmedAt                  *time.Time              `json:"armed_at"`
+	ActivityScore            *float64                `json:"activity_score,omitempty"`
+	Instances                []managedInstanceStatus `json:"instances,omitempty"`
+	DisabledSources          []disabledSource        `json:"disabled_sources,omitempty"`
+	GHACheckAgeSeconds       *int                    `json:"gha_check_age_seconds,omitempty"`
+	LastJobCompleted         *time.Time              `json:"last_job_completed,omitempty"`
+	PostJobSuspendAt         *time.Time              `json:"post_job_suspend_at,omitempty"`
+	// TimerRemainingSeconds is how long until the inactivity timer fires, null while it isn't running
+	TimerRemainingSeconds *int `json:"timer_remaining_seconds"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("Failed to write JSON response", "error", err)
+	}
+}
+
+func currentStatus() statusResponse {
+	now := time.Now()
+
+	tracker.mu.RLock()
+	status := statusResponse{
+		StartedAt:        tracker.startedAt,
+		UptimeSeconds:    int64(now.Sub(tracker.startedAt).Seconds()),
+		RequestCount:     tracker.requestCount,
+		LastPing:         tracker.lastPing,
+		LastActivity:     tracker.lastActivity,
+		InstanceNotFound: tracker.instanceNotFound,
+		StopSchedule:     tracker.stopSchedule,
+		LastSuspendError: tracker.lastSuspendError,
+		LastReconcile:    tracker.lastReconcile,
+	}
+	tracker.mu.RUnlock()
+
+	status.InactivityTimeoutSeconds = int(inactivityTimeout().Seconds())
+	status.KeepOnline = keepOnline()
+	status.Draining = draining.Load()
+	status.SuspendMode = effectiveSuspendMode(now)
+	status.ReportOnly = reportOnly.Load()
+	if armedAt := armedSince(); !armedAt.IsZero() {
+		status.ArmedAt = &armedAt
+	}
+
+	if due := nextSuspendAt(); due != nil {
+		remaining := max(int(due.Sub(now).Seconds()), 0)
+		status.TimerRemainingSeconds = &remaining
+	}
+
+	if age, ok := githubLogCacheAge(); ok {
+		seconds := int(age.Seconds())
+		status.GHACheckAgeSeconds = &seconds
+	}
+
+	if completed, due := lastJobCompletion(); !completed.IsZero() {
+		status.LastJobCompleted = &completed
+		if !due.IsZero() {
+			status.PostJobSuspendAt = &due
+		}
+	}
+
+	status.DisabledSources = currentDisabledSources()
+
+	if config().InstanceListFile != "" {
+		status.Instances = managedInstanceStatuses()
+	}
+
+	if config().ActivityScoring {
+		score := currentActivityScore(now)
+		status.ActivityScore = &score
+	}
+
+	pendingMu.Lock()
+	if pending != nil {
+		status.PendingSuspend = &pendingSuspendStatus{
+			SuspendAt:        pending.suspendAt,
+			RemainingSeconds: int(pending.suspendAt.Sub(now).Seconds()),
+		}
+	}
+	pendingMu.Unlock()
+
+	return status
+}
+
+func statusHandler(w http.ResponseWriter, r *http.Request) {
+	if wantsStatusText(r) {
+		writeStatusText(w, currentStatus())
+		return
+	}
+	writeJSON(w, http.StatusOK, currentStatus())
+}
+
+// wantsStatusText reports whether the client asked for the plain text status, with ?format=text or Accept: text/plain
+// JSON stays the default, and wins when the client accepts both
+func wantsStatusText(r *http.Request) bool {
+	switch r.URL.Query().Get("format") {
+	case "text":
+		return true
+	case "json":
+		return false
+	}
+
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, "text/plain") && !strings.Contains(accept, "application/json")
+}
+
+// writeStatusText writes one aligned "name: value" line per status field, for reading on a terminal without jq
+// Names match the JSON keys so the two formats can be compared
+func writeStatusText(w http.ResponseWriter, status statusResponse) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	v := reflect.ValueOf(status)
+	t := v.Type()
+	for i := range t.NumField() {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		fmt.Fprintf(tw, "%s:\t%s\n", name, formatStatusValue(v.Field(i).Interface()))
+	}
+	if err := tw.Flush(); err != nil {
+		slog.Error("Failed to write status response", "error", err)
+	}
+}
+
+// formatStatusValue renders one status field for writeStatusText
+func formatStatusValue(value any) string {
+	switch value := value.(type) {
+	case time.Time:
+		if value.IsZero() {
+			return "never"
+		}
+		return fmt.Sprintf("%s (%s ago)", value.Format(time.RFC3339), time.Since(value).Round(time.Second))
+	case *time.Time:
+		if value == nil {
+			return "-"
+		}
+		return formatStatusValue(*value)
+	}
+
+	rv := reflect.ValueOf(value)
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return "-"
+		}
+		return formatStatusValue(rv.Elem().Interface())
+	case reflect.Struct, reflect.Slice:
+		if rv.Kind() == reflect.Slice && rv.Len() == 0 {
+			return "-"
+		}
+		// Nested values are rare and short, their JSON is readable enough
+		b, err := json.Marshal(value)
+		if err != nil {
+			return err.Error()
+		}
+		return string(b)
+	}
+	return fmt.Sprint(value)
+}
+
+type nextSuspendResponse struct {
+	SuspendAt *time.Time `json:"suspend_at"`
+}
+
+// nextSuspendAt returns when the instance will be suspended if nothing else happens, or nil if the timer isn't running
+// The inactivity check still gets the last word when the time comes, so this is the earliest the suspend can happen
+func nextSuspendAt() *time.Time {
+	if keepOnline() || draining.Load() {
+		return nil
+	}
+
+	shutdownMutex.Lock()
+	due := shutdownTimerDue
+	armed := !shutdownArmedAt.IsZero()
+	shutdownMutex.Unlock()
+
+	if due.IsZero() {
+		return nil
+	}
+	// The first timer only arms the shutdown, the suspend waits for ARMED_TIMEOUT after that
+	if armedTimeout := config().ArmedTimeout; armedTimeout > 0 && !armed {
+		due = due.Add(armedTimeout)
+	}
+
+	pendingMu.Lock()
+	if pending != nil && pending.suspendAt.Before(due) {
+		due = pending.suspendAt
+	}
+	pendingMu.Unlock()
+
+	return &due
+}
+
+func nextSuspendHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, nextSuspendResponse{SuspendAt: nextSuspendAt()})
+}
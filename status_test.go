@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestNextSuspend(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		if at := nextSuspendAt(); at != nil {
+			t.Fatalf("Expected no suspend without a running timer, got %v", at)
+		}
+
+		start := time.Now()
+		resetShutdownTimer()
+
+		w := httptest.NewRecorder()
+		nextSuspendHandler(w, httptest.NewRequest("GET", "/next-suspend", nil))
+		var body nextSuspendResponse
+		if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if body.SuspendAt == nil || !body.SuspendAt.Equal(start.Add(config().InactivityTimeout)) {
+			t.Fatalf("Expected the suspend at %v, got %v", start.Add(config().InactivityTimeout), body.SuspendAt)
+		}
+
+		updateConfig(func(cfg *Config) { cfg.LibOpsKeepOnline = true })
+		if at := nextSuspendAt(); at != nil {
+			t.Fatalf("Expected no suspend while kept online, got %v", at)
+		}
+		updateConfig(func(cfg *Config) { cfg.LibOpsKeepOnline = false })
+
+		// With a two-phase timeout the suspend comes ARMED_TIMEOUT after the timer arms it
+		updateConfig(func(cfg *Config) { cfg.ArmedTimeout = time.Minute })
+		if at := nextSuspendAt(); at == nil || !at.Equal(start.Add(config().InactivityTimeout+time.Minute)) {
+			t.Fatalf("Expected the armed timeout to be added, got %v", at)
+		}
+
+		stopShutdownTimer()
+		if at := nextSuspendAt(); at != nil {
+			t.Fatalf("Expected no suspend once the timer is stopped, got %v", at)
+		}
+	})
+}
+
+func TestStatusTextFormat(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	recordSuspendResult(errors.New("quota exceeded"))
+
+	tests := []struct {
+		target string
+		accept string
+		text   bool
+	}{
+		{"/status", "", false},
+		{"/status", "*/*", false},
+		{"/status", "text/plain", true},
+		{"/status", "text/plain, application/json", false},
+		{"/status?format=text", "", true},
+		{"/status?format=json", "text/plain", false},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.target, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept", tt.accept)
+		}
+		w := httptest.NewRecorder()
+		statusHandler(w, req)
+
+		contentType := w.Header().Get("Content-Type")
+		if tt.text != strings.HasPrefix(contentType, "text/plain") {
+			t.Fatalf("%s with Accept %q: unexpected Content-Type %q", tt.target, tt.accept, contentType)
+		}
+		if !tt.text {
+			continue
+		}
+
+		body := w.Body.String()
+		for _, want := range []string{"keep_online:", "inactivity_timeout_seconds:", "pending_suspend:", "quota exceeded"} {
+			if !strings.Contains(body, want) {
+				t.Fatalf("Expected %q in the text status, got:\n%s", want, body)
+			}
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	storage "google.golang.org/api/storage/v1"
+)
+
+var errSuspendLocked = errors.New("suspend lock is held by another controller")
+
+// suspendLock is a SUSPEND_LOCK object we created, released by deleting exactly that generation
+type suspendLock struct {
+	bucket     string
+	object     string
+	generation int64
+}
+
+// lockHolder identifies this controller in the lock object, for whoever finds it held
+func lockHolder() string {
+	hostname, _ := os.Hostname()
+	return fmt.Sprintf("%s/%d", hostname, os.Getpid())
+}
+
+// acquireSuspendLock creates the SUSPEND_LOCK object for instance so only one controller suspends it at a time
+// It returns errSuspendLocked if another controller holds it, and a nil lock when SUSPEND_LOCK isn't set
+// A lock older than SUSPEND_LOCK_TTL was left by a controller that died mid-suspend and is taken over
+func acquireSuspendLock(ctx context.Context, instance string) (*suspendLock, error) {
+	cfg := config()
+
+	path, ok := strings.CutPrefix(cfg.SuspendLock, "gs://")
+	if !ok {
+		return nil, nil
+	}
+	bucket, prefix, _ := strings.Cut(path, "/")
+	if bucket == "" {
+		return nil, fmt.Errorf("suspend lock %q should look like gs://bucket/prefix", cfg.SuspendLock)
+	}
+	object := strings.TrimPrefix(strings.TrimSuffix(prefix, "/")+"/"+instance, "/")
+
+	service, err := getStorageService(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	holder := lockHolder()
+	for range 2 {
+		created, err := service.Objects.Insert(bucket, &storage.Object{Name: object, Metadata: map[string]string{"holder": holder}}).
+			Media(strings.NewReader(holder)).
+			IfGenerationMatch(0).
+			Context(ctx).
+			Do()
+		if err == nil {
+			slog.Debug("Acquired suspend lock", "lock", "gs://"+bucket+"/"+object)
+			return &suspendLock{bucket: bucket, object: object, generation: created.Generation}, nil
+		}
+		if !isPreconditionFailed(err) {
+			return nil, fmt.Errorf("failed to create suspend lock: %w", err)
+		}
+
+		existing, err := service.Objects.Get(bucket, object).Context(ctx).Do()
+		if isNotFoundError(err) {
+			// Released between our insert and get
+			continue
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read suspend lock: %w", err)
+		}
+
+		createdAt, _ := time.Parse(time.RFC3339, existing.TimeCreated)
+		if time.Since(createdAt) < cfg.SuspendLockTTL {
+			return nil, fmt.Errorf("%w: %s since %s", errSuspendLocked, existing.Metadata["holder"], existing.TimeCreated)
+		}
+
+		slog.Warn("Taking over a stale suspend lock",
+			"lock", "gs://"+bucket+"/"+object,
+			"holder", existing.Metadata["holder"],
+			"created", existing.TimeCreated)
+		err = service.Objects.Delete(bucket, object).IfGenerationMatch(existing.Generation).Context(ctx).Do()
+		if err != nil && !isNotFoundError(err) && !isPreconditionFailed(err) {
+			return nil, fmt.Errorf("failed to remove stale suspend lock: %w", err)
+		}
+	}
+
+	return nil, errSuspendLocked
+}
+
+// release deletes the lock object if it is still the one we created, a nil lock is a no-op
+func (l *suspendLock) release(ctx context.Context) {
+	if l == nil {
+		return
+	}
+
+	service, err := getStorageService(ctx)
+	if err == nil {
+		err = service.Objects.Delete(l.bucket, l.object).IfGenerationMatch(l.generation).Context(ctx).Do()
+	}
+	if err != nil {
+		slog.Warn("Failed to release suspend lock, it expires after SUSPEND_LOCK_TTL",
+			"lock", "gs://"+l.bucket+"/"+l.object,
+			"error", err)
+	}
+}
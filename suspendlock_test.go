@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+	storage "google.golang.org/api/storage/v1"
+)
+
+// fakeLockBucket is a storage API holding at most one object, honouring generation preconditions
+type fakeLockBucket struct {
+	mu         sync.Mutex
+	generation int64
+	created    time.Time
+	inserts    int
+	deletes    int
+}
+
+func (b *fakeLockBucket) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	match := r.URL.Query().Get("ifGenerationMatch")
+	switch {
+	case r.Method == http.MethodPost && strings.HasPrefix(r.URL.Path, "/upload/"):
+		b.inserts++
+		if match == "0" && b.generation != 0 {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		b.generation = time.Now().UnixNano()
+		b.created = time.Now()
+		writeComputeJSON(w, storage.Object{Name: "locks/test-instance", Generation: b.generation})
+	case r.Method == http.MethodGet:
+		if b.generation == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		writeComputeJSON(w, storage.Object{
+			Name:        "locks/test-instance",
+			Generation:  b.generation,
+			TimeCreated: b.created.Format(time.RFC3339),
+			Metadata:    map[string]string{"holder": "other-controller"},
+		})
+	case r.Method == http.MethodDelete:
+		if b.generation == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if match != "" && match != strconv.FormatInt(b.generation, 10) {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			return
+		}
+		b.deletes++
+		b.generation = 0
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, "unexpected request "+r.Method+" "+r.URL.Path, http.StatusBadRequest)
+	}
+}
+
+func TestSuspendLock(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	bucket := &fakeLockBucket{}
+	useFakeStorageAPI(t, bucket)
+	updateConfig(func(cfg *Config) {
+		cfg.SuspendLock = "gs://controllers/locks"
+		cfg.SuspendLockTTL = 5 * time.Minute
+	})
+
+	lock, err := acquireSuspendLock(context.Background(), "test-instance")
+	if err != nil || lock == nil {
+		t.Fatalf("Expected to acquire the lock, got %v, %v", lock, err)
+	}
+	if lock.object != "locks/test-instance" {
+		t.Fatalf("Expected a per-instance lock object, got %s", lock.object)
+	}
+
+	// A second controller has to wait
+	if _, err := acquireSuspendLock(context.Background(), "test-instance"); !errors.Is(err, errSuspendLocked) {
+		t.Fatalf("Expected errSuspendLocked while the lock is held, got %v", err)
+	}
+
+	lock.release(context.Background())
+	if bucket.deletes != 1 {
+		t.Fatalf("Expected the lock to be deleted on release, got %d deletes", bucket.deletes)
+	}
+	if _, err := acquireSuspendLock(context.Background(), "test-instance"); err != nil {
+		t.Fatalf("Expected the released lock to be free, got %v", err)
+	}
+}
+
+func TestStaleSuspendLockTakenOver(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	bucket := &fakeLockBucket{generation: 1, created: time.Now().Add(-time.Hour)}
+	useFakeStorageAPI(t, bucket)
+	updateConfig(func(cfg *Config) {
+		cfg.SuspendLock = "gs://controllers/locks"
+		cfg.SuspendLockTTL = 5 * time.Minute
+	})
+
+	lock, err := acquireSuspendLock(context.Background(), "test-instance")
+	if err != nil || lock == nil {
+		t.Fatalf("Expected to take over a stale lock, got %v", err)
+	}
+	if bucket.generation == 1 {
+		t.Fatal("Expected the stale lock to be replaced")
+	}
+}
+
+func TestSuspendDeferredWhileLocked(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	suspendFunc = func(context.Context) error {
+		return errors.Join(errors.New("failed to suspend machine"), errSuspendLocked)
+	}
+
+	if err := suspendAndShutdown("inactivity timeout", nil); !errors.Is(err, errSuspendLocked) {
+		t.Fatalf("Expected errSuspendLocked, got %v", err)
+	}
+	select {
+	case <-serverShutdown:
+		t.Fatal("Server should keep running while another controller suspends")
+	default:
+	}
+	if exitCode.Load() != exitOK {
+		t.Fatalf("Expected no failure exit code for a deferred suspend, got %d", exitCode.Load())
+	}
+}
+
+func TestLockedSuspendLeavesLabelAlone(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	useFakeStorageAPI(t, &fakeLockBucket{generation: 1, created: time.Now()})
+	updateConfig(func(cfg *Config) {
+		cfg.SuspendLock = "gs://controllers/locks"
+		cfg.SuspendLockTTL = 5 * time.Minute
+		cfg.RecordSuspendLabel = true
+	})
+
+	var setLabels atomic.Int32
+	useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet:
+			writeComputeJSON(w, compute.Instance{Name: "test-instance", Status: "RUNNING"})
+		case strings.HasSuffix(r.URL.Path, "/setLabels"):
+			setLabels.Add(1)
+			writeComputeJSON(w, compute.Operation{Name: "op-labels"})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	if _, _, err := suspendMachine(t.Context()); !errors.Is(err, errSuspendLocked) {
+		t.Fatalf("Expected errSuspendLocked, got %v", err)
+	}
+	if setLabels.Load() != 0 {
+		t.Fatal("Expected the suspend label to be left to the controller holding the lock")
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// timeoutSchedule holds an inactivity timeout per weekday, zero for days that use INACTIVITY_TIMEOUT
+type timeoutSchedule [7]time.Duration
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseTimeoutSchedule parses a spec like "mon-fri:10m,sat-sun:2m"
+// Ranges may wrap around the week, e.g. "fri-mon:5m"
+func parseTimeoutSchedule(spec string) (*timeoutSchedule, error) {
+	if strings.TrimSpace(spec) == "" {
+		return nil, nil
+	}
+
+	var schedule timeoutSchedule
+	for entry := range strings.SplitSeq(spec, ",") {
+		days, value, ok := strings.Cut(strings.TrimSpace(entry), ":")
+		if !ok {
+			return nil, fmt.Errorf("%q: expected days:timeout", entry)
+		}
+
+		timeout, err := time.ParseDuration(strings.TrimSpace(value))
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("%q: invalid timeout %q", entry, value)
+		}
+
+		first, last, isRange := strings.Cut(strings.ToLower(strings.TrimSpace(days)), "-")
+		if !isRange {
+			last = first
+		}
+		from, ok := weekdays[first]
+		if !ok {
+			return nil, fmt.Errorf("%q: unknown day %q", entry, first)
+		}
+		to, ok := weekdays[last]
+		if !ok {
+			return nil, fmt.Errorf("%q: unknown day %q", entry, last)
+		}
+
+		for day := from; ; day = (day + 1) % 7 {
+			if schedule[day] != 0 {
+				return nil, fmt.Errorf("%q: %s already has a timeout", entry, day)
+			}
+			schedule[day] = timeout
+			if day == to {
+				break
+			}
+		}
+	}
+
+	return &schedule, nil
+}
+
+// timeoutAt returns the scheduled timeout for the day of now, in local time
+func (s *timeoutSchedule) timeoutAt(now time.Time) (time.Duration, bool) {
+	if s == nil || s[now.Weekday()] == 0 {
+		return 0, false
+	}
+	return s[now.Weekday()], true
+}
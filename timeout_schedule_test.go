@@ -0,0 +1,83 @@
+package main
+
+import (
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestParseTimeoutSchedule(t *testing.T) {
+	schedule, err := parseTimeoutSchedule("mon-fri:10m, sat-sun:2m")
+	if err != nil {
+		t.Fatalf("parseTimeoutSchedule: %v", err)
+	}
+	if schedule[time.Wednesday] != 10*time.Minute || schedule[time.Sunday] != 2*time.Minute {
+		t.Fatalf("Unexpected schedule: %v", schedule)
+	}
+
+	// Ranges wrap around the end of the week and days without an entry fall back to INACTIVITY_TIMEOUT
+	schedule, err = parseTimeoutSchedule("fri-mon:5m")
+	if err != nil {
+		t.Fatalf("parseTimeoutSchedule: %v", err)
+	}
+	if schedule[time.Saturday] != 5*time.Minute || schedule[time.Monday] != 5*time.Minute || schedule[time.Tuesday] != 0 {
+		t.Fatalf("Unexpected wrapping schedule: %v", schedule)
+	}
+
+	for _, invalid := range []string{"mon-fri", "mon-fri:soon", "mon-fri:-1m", "someday:5m", "mon-fri:10m,fri:2m"} {
+		if _, err := parseTimeoutSchedule(invalid); err == nil {
+			t.Fatalf("Expected %q to be rejected", invalid)
+		}
+	}
+}
+
+func TestTimeoutScheduleSelectsTodaysTimeout(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		// The synctest clock starts at midnight UTC on Saturday, January 1st 2000
+		schedule, err := parseTimeoutSchedule("mon-fri:10m,sat-sun:2m")
+		if err != nil {
+			t.Fatal(err)
+		}
+		updateConfig(func(cfg *Config) { cfg.TimeoutSchedule = schedule })
+		if day := time.Now().Weekday(); day != time.Saturday {
+			t.Fatalf("Expected the fake clock to start on a Saturday, got %s", day)
+		}
+
+		resetShutdownTimer()
+		time.Sleep(2*time.Minute - time.Second)
+		if mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should wait for the weekend timeout")
+		}
+
+		time.Sleep(2 * time.Second)
+		if !mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should use the weekend timeout on a Saturday")
+		}
+	})
+}
+
+func TestExplicitTimeoutReplacesSchedule(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	schedule, err := parseTimeoutSchedule("sun-sat:2m")
+	if err != nil {
+		t.Fatal(err)
+	}
+	updateConfig(func(cfg *Config) {
+		cfg.TimeoutSchedule = schedule
+		cfg.LibOpsKeepOnline = true
+	})
+
+	if inactivityTimeout() != 2*time.Minute {
+		t.Fatalf("Expected the scheduled timeout, got %s", inactivityTimeout())
+	}
+
+	setInactivityTimeout(time.Hour)
+	if inactivityTimeout() != time.Hour {
+		t.Fatalf("Expected PUT /timeout to replace the schedule, got %s", inactivityTimeout())
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+var (
+	// suspendVerifyInterval is swapped out in tests
+	suspendVerifyInterval = 2 * time.Second
+
+	suspendStateMismatches = &counter{
+		name: "lightsout_suspend_state_mismatches_total",
+		help: "Suspends whose instance didn't reach SUSPENDED or TERMINATED within VERIFY_SUSPEND_TIMEOUT.",
+	}
+)
+
+// suspendedStatuses are the instance statuses that mean a suspend took effect
+var suspendedStatuses = map[string]bool{
+	"SUSPENDED":  true,
+	"TERMINATED": true,
+}
+
+// verifySuspended reads instance until it reports SUSPENDED or TERMINATED, giving up after VERIFY_SUSPEND_TIMEOUT
+// A DONE operation has been seen with the instance still RUNNING, this catches the suspends that silently didn't happen
+func verifySuspended(ctx context.Context, service *compute.Service, instance managedInstance) error {
+	timeout := config().VerifySuspendTimeout
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	status := "unknown"
+	for {
+		current, err := service.Instances.Get(instance.Project, instance.Zone, instance.Name).Context(ctx).Do()
+		if err == nil {
+			status = current.Status
+			if suspendedStatuses[status] {
+				slog.Debug("Suspend verified", "instance", instance.String(), "status", status)
+				return nil
+			}
+		} else if ctx.Err() == nil {
+			slog.Debug("Could not read the instance state, retrying", "instance", instance.String(), "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			suspendStateMismatches.inc()
+			slog.Error("Instance did not reach a suspended state after its suspend finished",
+				"instance", instance.String(),
+				"status", status,
+				"timeout_seconds", int(timeout.Seconds()))
+			return fmt.Errorf("%s: still %s %s after the suspend finished", instance, status, timeout)
+		case <-time.After(suspendVerifyInterval):
+		}
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestVerifySuspended(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	origInterval := suspendVerifyInterval
+	suspendVerifyInterval = 10 * time.Millisecond
+	defer func() { suspendVerifyInterval = origInterval }()
+
+	// The instance lingers in RUNNING for a couple of reads before settling
+	var reads atomic.Int32
+	var settled atomic.Bool
+	useFakeComputeAPI(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		status := "RUNNING"
+		if reads.Add(1) > 2 && settled.Load() {
+			status = "SUSPENDED"
+		}
+		writeComputeJSON(w, compute.Instance{Name: "worker", Status: status})
+	}))
+
+	service, err := getComputeService(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	instance := managedInstance{Project: "test-project", Zone: "test-zone", Name: "worker"}
+
+	updateConfig(func(cfg *Config) { cfg.VerifySuspendTimeout = 5 * time.Second })
+	settled.Store(true)
+	if err := verifySuspended(context.Background(), service, instance); err != nil {
+		t.Fatalf("Expected the suspend to be verified, got %v", err)
+	}
+	if reads.Load() != 3 {
+		t.Fatalf("Expected the instance to be read until it was suspended, got %d reads", reads.Load())
+	}
+
+	updateConfig(func(cfg *Config) { cfg.VerifySuspendTimeout = 100 * time.Millisecond })
+	settled.Store(false)
+	before := suspendStateMismatches.value.Load()
+	err = verifySuspended(context.Background(), service, instance)
+	if err == nil || !strings.Contains(err.Error(), "still RUNNING") {
+		t.Fatalf("Expected a mismatch for an instance that stays RUNNING, got %v", err)
+	}
+	if got := suspendStateMismatches.value.Load() - before; got != 1 {
+		t.Fatalf("Expected one mismatch counted, got %d", got)
+	}
+}
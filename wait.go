@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	waitMu sync.Mutex
+	// openWaits is how many /wait requests are currently blocked
+	openWaits int
+	// lastWaitEnded is when the most recent /wait request returned
+	lastWaitEnded time.Time
+)
+
+// waitActivity treats an open /wait connection as activity happening right now
+func waitActivity(context.Context) (time.Time, error) {
+	waitMu.Lock()
+	defer waitMu.Unlock()
+
+	if openWaits > 0 {
+		return time.Now(), nil
+	}
+	return lastWaitEnded, nil
+}
+
+// waitHandler holds the connection open until the server shuts down, the client goes away or WAIT_TIMEOUT passes
+// The machine stays online for as long as any /wait request is open, as a push-style alternative to polling /ping
+func waitHandler(w http.ResponseWriter, r *http.Request) {
+	timeout := config().WaitTimeout
+
+	// Outlive the server's read and write timeouts, the read deadline also governs noticing the client leaving
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Now().Add(timeout + 5*time.Second))
+
+	waitMu.Lock()
+	openWaits++
+	waitMu.Unlock()
+	// Under keep-online there is no timer to reset, starting one would let the machine suspend
+	if !keepOnline() {
+		resetShutdownTimer()
+	}
+
+	slog.Debug("Wait request opened", "remote_addr", r.RemoteAddr)
+
+	result := "timeout"
+	select {
+	case <-r.Context().Done():
+		result = "disconnected"
+	case <-serverShutdown:
+		result = "shutdown"
+	case <-time.After(timeout):
+	}
+
+	waitMu.Lock()
+	openWaits--
+	lastWaitEnded = time.Now()
+	waitMu.Unlock()
+	// The inactivity timeout counts from when the last client let go
+	if !keepOnline() {
+		resetShutdownTimer()
+	}
+
+	slog.Debug("Wait request closed", "remote_addr", r.RemoteAddr, "result", result)
+
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(result))
+}
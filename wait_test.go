@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestOpenWaitKeepsMachineOnline(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		updateConfig(func(cfg *Config) { cfg.WaitTimeout = time.Hour })
+		activitySources = []ActivitySource{sourceFunc{name: "wait", fn: waitActivity}}
+		resetShutdownTimer()
+
+		ctx, disconnect := context.WithCancel(context.Background())
+		w := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			waitHandler(w, httptest.NewRequest("GET", "/wait", nil).WithContext(ctx))
+			close(done)
+		}()
+
+		time.Sleep(config().InactivityTimeout * 3)
+		if mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should not happen while a /wait request is open")
+		}
+
+		disconnect()
+		<-done
+		if w.Body.String() != "disconnected" {
+			t.Fatalf("Expected the wait to end with the client leaving, got %q", w.Body.String())
+		}
+
+		time.Sleep(config().InactivityTimeout - time.Second)
+		if mockGCP.WasSuspendCalled() {
+			t.Fatal("The inactivity timeout should count from when the /wait ended")
+		}
+
+		time.Sleep(2 * time.Second)
+		if !mockGCP.WasSuspendCalled() {
+			t.Fatal("Suspension should happen once the /wait has been closed for the timeout")
+		}
+	})
+}
+
+func TestWaitTimesOut(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		updateConfig(func(cfg *Config) { cfg.WaitTimeout = time.Minute })
+
+		w := httptest.NewRecorder()
+		start := time.Now()
+		waitHandler(w, httptest.NewRequest("GET", "/wait", nil))
+
+		if w.Body.String() != "timeout" || time.Since(start) != time.Minute {
+			t.Fatalf("Expected the wait to time out after a minute, got %q after %s", w.Body.String(), time.Since(start))
+		}
+	})
+}
+
+func TestWaitLeavesKeepOnlineAlone(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		cleanup := setupTestEnvironment()
+		defer cleanup()
+
+		updateConfig(func(cfg *Config) {
+			cfg.WaitTimeout = time.Minute
+			cfg.LibOpsKeepOnline = true
+		})
+
+		waitHandler(httptest.NewRecorder(), httptest.NewRequest("GET", "/wait", nil))
+
+		shutdownMutex.Lock()
+		timerRunning := shutdownTimer != nil
+		shutdownMutex.Unlock()
+		if timerRunning {
+			t.Fatal("Expected no shutdown timer to be started under keep-online")
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+// Suspend webhook events
+const (
+	webhookPreSuspend  = "pre_suspend"
+	webhookPostSuspend = "post_suspend"
+	// webhookWouldSuspend is sent instead of both in report-only mode
+	webhookWouldSuspend = "would_suspend"
+)
+
+// signatureHeader carries the HMAC-SHA256 of the body, formatted like GitHub's X-Hub-Signature-256
+const signatureHeader = "X-Lightsout-Signature"
+
+type webhookPayload struct {
+	Event    string `json:"event"`
+	Project  string `json:"project"`
+	Zone     string `json:"zone"`
+	Instance string `json:"instance"`
+	Reason   string `json:"reason"`
+	// Timestamp is signed along with the rest of the body so receivers can reject replayed notifications
+	Timestamp int64  `json:"timestamp"`
+	Error     string `json:"error,omitempty"`
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// signWebhook returns the signature header value for body
+func signWebhook(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// notifySuspend POSTs event to SUSPEND_WEBHOOK_URL, if any
+func notifySuspend(ctx context.Context, event, reason string, suspendErr error) error {
+	cfg := config()
+
+	if cfg.SuspendWebhookURL == "" {
+		return nil
+	}
+
+	payload := webhookPayload{
+		Event:     event,
+		Project:   cfg.GoogleProjectID,
+		Zone:      cfg.GCEZone,
+		Instance:  cfg.GCEInstance,
+		Reason:    reason,
+		Timestamp: time.Now().Unix(),
+	}
+	if suspendErr != nil {
+		payload.Error = suspendErr.Error()
+	}
+
+	if err := sendWebhook(ctx, cfg.SuspendWebhookURL, cfg.WebhookSecret, payload); err != nil {
+		return fmt.Errorf("failed to send %s webhook: %w", event, err)
+	}
+	slog.Debug("Suspend webhook sent", "event", event)
+	return nil
+}
+
+// notifySuspendAfter sends the post_suspend event, the suspend already happened so a failure is only logged
+func notifySuspendAfter(ctx context.Context, reason string, suspendErr error) {
+	ctx, cancel := context.WithTimeout(ctx, webhookClient.Timeout)
+	defer cancel()
+
+	if err := notifySuspend(ctx, webhookPostSuspend, reason, suspendErr); err != nil {
+		slog.Warn("Failed to send suspend webhook", "event", webhookPostSuspend, "error", err)
+	}
+}
+
+func sendWebhook(ctx context.Context, url, secret string, payload webhookPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if secret != "" {
+		req.Header.Set(signatureHeader, signWebhook(secret, body))
+	}
+
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook rejected: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSuspendWebhooksAreSigned(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	var mu sync.Mutex
+	var events []webhookPayload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !hmac.Equal([]byte(r.Header.Get(signatureHeader)), []byte(signWebhook("s3cret", body))) {
+			t.Errorf("Signature %q does not match the body", r.Header.Get(signatureHeader))
+		}
+
+		var payload webhookPayload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("Failed to decode webhook: %v", err)
+		}
+		mu.Lock()
+		events = append(events, payload)
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	updateConfig(func(cfg *Config) {
+		cfg.SuspendWebhookURL = server.URL
+		cfg.WebhookSecret = "s3cret"
+	})
+	suspendFunc = func(context.Context) error { return errors.New("quota exceeded") }
+
+	_ = suspendAndShutdown("inactivity timeout", nil)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != 2 || events[0].Event != webhookPreSuspend || events[1].Event != webhookPostSuspend {
+		t.Fatalf("Expected pre and post suspend events, got %+v", events)
+	}
+	if events[1].Error != "quota exceeded" || events[0].Instance != "test-instance" {
+		t.Fatalf("Unexpected payloads %+v", events)
+	}
+	if age := time.Since(time.Unix(events[0].Timestamp, 0)); age < 0 || age > time.Minute {
+		t.Fatalf("Expected a current timestamp, got %d", events[0].Timestamp)
+	}
+}
+
+func TestSignWebhook(t *testing.T) {
+	// The example from GitHub's webhook validation docs
+	got := signWebhook("It's a Secret to Everybody", []byte("Hello, World!"))
+	want := "sha256=757107ea0eb2509fc211221cce984b8a37570b6d7586c22c46f4379c8b043e17"
+	if got != want {
+		t.Fatalf("Expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// procPath is swapped out in tests for a directory laid out like /proc
+var procPath = "/proc"
+
+// workdirActivity treats any process holding a file open under WORKDIR as activity happening right now
+// Builds don't ping during long compile and link steps, but they keep their outputs open
+func workdirActivity(ctx context.Context) (time.Time, error) {
+	holders, denied, err := openFileHolders(ctx, config().Workdir)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	// Processes owned by other users can't be inspected unless we run as root or share their pid namespace
+	if denied > 0 {
+		slog.Debug("Could not inspect some processes' open files", "processes", denied)
+	}
+	slog.Debug("Processes with files open in the workdir", "pids", holders)
+	if len(holders) > 0 {
+		return time.Now(), nil
+	}
+	return time.Time{}, nil
+}
+
+// openFileHolders scans procPath/<pid>/fd for descriptors pointing under dir
+// It returns the pids holding one and how many processes couldn't be read for lack of permission
+func openFileHolders(ctx context.Context, dir string) ([]int, int, error) {
+	dir = filepath.Clean(dir)
+
+	entries, err := os.ReadDir(procPath)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to list processes: %w", err)
+	}
+
+	var (
+		holders []int
+		denied  int
+	)
+	for _, entry := range entries {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, err
+		}
+
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+
+		fdDir := filepath.Join(procPath, entry.Name(), "fd")
+		fds, err := os.ReadDir(fdDir)
+		if errors.Is(err, fs.ErrPermission) {
+			denied++
+			continue
+		} else if err != nil {
+			// The process exited while we were looking
+			continue
+		}
+
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil {
+				continue
+			}
+			// Deleted files keep their path with " (deleted)" appended, they are still open so they still count
+			if target == dir || strings.HasPrefix(target, dir+string(filepath.Separator)) {
+				holders = append(holders, pid)
+				break
+			}
+		}
+	}
+
+	if len(holders) == 0 && denied > 0 && denied == countPids(entries) {
+		return nil, denied, fmt.Errorf("permission denied reading open files of every process")
+	}
+	return holders, denied, nil
+}
+
+// countPids counts the process directories among entries
+func countPids(entries []os.DirEntry) int {
+	n := 0
+	for _, entry := range entries {
+		if pid, err := strconv.Atoi(entry.Name()); err == nil && pid != os.Getpid() {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWorkdirActivity(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	// Lay out a fake /proc where each fd is a symlink to what the process has open
+	proc := t.TempDir()
+	origProc := procPath
+	procPath = proc
+	defer func() { procPath = origProc }()
+
+	workdir := t.TempDir()
+	updateConfig(func(cfg *Config) { cfg.Workdir = workdir })
+
+	openFile := func(pid, fd, target string) {
+		t.Helper()
+		dir := filepath.Join(proc, pid, "fd")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(target, filepath.Join(dir, fd)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	openFile("100", "0", "/dev/null")
+	openFile("100", "1", workdir+"-other/out.log")
+	if err := os.MkdirAll(filepath.Join(proc, "self"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	last, err := workdirActivity(t.Context())
+	if err != nil || !last.IsZero() {
+		t.Fatalf("Expected no activity without files open in the workdir, got %v, %v", last, err)
+	}
+
+	openFile("200", "3", filepath.Join(workdir, "build", "main.o")+" (deleted)")
+	holders, _, err := openFileHolders(t.Context(), workdir)
+	if err != nil || len(holders) != 1 || holders[0] != 200 {
+		t.Fatalf("Expected pid 200 to hold a file in the workdir, got %v, %v", holders, err)
+	}
+	last, err = workdirActivity(t.Context())
+	if err != nil || last.IsZero() {
+		t.Fatalf("Expected activity while a file is open in the workdir, got %v, %v", last, err)
+	}
+}
+
+func TestWorkdirActivityWithoutProc(t *testing.T) {
+	cleanup := setupTestEnvironment()
+	defer cleanup()
+
+	origProc := procPath
+	procPath = filepath.Join(t.TempDir(), "missing")
+	defer func() { procPath = origProc }()
+	updateConfig(func(cfg *Config) { cfg.Workdir = t.TempDir() })
+
+	if _, err := workdirActivity(t.Context()); err == nil {
+		t.Fatal("Expected an error when processes can't be listed")
+	}
+}